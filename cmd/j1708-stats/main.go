@@ -0,0 +1,55 @@
+// Программа j1708-stats объединяет оба агента в один исполняемый файл, например
+// для одного образа Docker: протокол выбирается подкомандой, за ней следуют
+// параметры агента.
+//
+//	j1708-stats j1587 -port=/dev/ttyUSB0 -broker=tcp://localhost:1883
+//	j1708-stats j1939 -can-if=can0 -broker=tcp://localhost:1883
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/serebryakov7/j1708-stats/pkg/agent/j1587"
+	"github.com/serebryakov7/j1708-stats/pkg/agent/j1939"
+)
+
+// commands - подкоманды программы: агенты протоколов.
+var commands = []struct {
+	name, description string
+	run               func(args []string)
+}{
+	{"j1587", "агент J1587/J1708 (последовательный порт)", j1587.Main},
+	{"j1939", "агент J1939 (SocketCAN, только Linux)", j1939.Main},
+}
+
+func usage() {
+	name := filepath.Base(os.Args[0])
+	fmt.Fprintf(os.Stderr, "Использование: %s <команда> [параметры]\n\nКоманды:\n", name)
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-6s %s\n", c.name, c.description)
+	}
+	fmt.Fprintf(os.Stderr, "\nПараметры команды: %s <команда> -h\n", name)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	name := os.Args[1]
+	for _, c := range commands {
+		if c.name == name {
+			c.run(os.Args[2:])
+			return
+		}
+	}
+	if name == "-h" || name == "-help" || name == "--help" || name == "help" {
+		usage()
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Неизвестная команда %q\n\n", name)
+	usage()
+	os.Exit(2)
+}
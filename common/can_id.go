@@ -0,0 +1,59 @@
+package common
+
+import "fmt"
+
+// CANID - составляющие 29-битного идентификатора кадра J1939 для отладки.
+// Указатели позволяют опускать в JSON неизвестные составляющие: в режиме
+// сокета CAN_J1939 ядро передает только PGN и адрес источника, без приоритета
+// и без адреса назначения PDU1.
+type CANID struct {
+	// ID - идентификатор целиком в hex, например 0x18FECA00; пусто - неизвестен.
+	ID       string `json:"id,omitempty"`
+	Priority *int   `json:"priority,omitempty"` // 0-7, 0 - наивысший
+	PGN      int    `json:"pgn"`
+	PF       int    `json:"pf"` // PDU Format: < 240 - PDU1 (адресный), иначе PDU2
+	// PS - PDU Specific: адрес назначения для PDU1, расширение группы для PDU2.
+	PS *int `json:"ps,omitempty"`
+	SA int  `json:"sa"`
+}
+
+// ParseCANID разбирает 29-битный идентификатор J1939 на составляющие.
+// PGN кадра PDU1 не включает адрес назначения (PS = 0).
+func ParseCANID(id uint32) CANID {
+	priority := int(id>>26) & 0x07
+	pf := int(id>>16) & 0xFF
+	ps := int(id>>8) & 0xFF
+	pgn := int(id>>8) & 0x3FFFF
+	if pf < 240 {
+		pgn &^= 0xFF
+	}
+	return CANID{
+		ID:       fmt.Sprintf("0x%08X", id),
+		Priority: &priority,
+		PGN:      pgn,
+		PF:       pf,
+		PS:       &ps,
+		SA:       int(id & 0xFF),
+	}
+}
+
+// CANIDFromPGN возвращает составляющие, известные по PGN и адресу источника
+// без CAN ID: приоритет неизвестен, PS - только у PDU2, где он входит в PGN.
+func CANIDFromPGN(pgn uint32, sa uint8) CANID {
+	c := CANID{PGN: int(pgn), PF: int(pgn>>8) & 0xFF, SA: int(sa)}
+	if c.PF >= 240 {
+		ps := int(pgn & 0xFF)
+		c.PS = &ps
+	}
+	return c
+}
+
+// RawFrame - принятый кадр (или сообщение, собранное из TP) в исходном виде для отладки.
+type RawFrame struct {
+	PGN       int    `json:"pgn"`
+	SA        int    `json:"sa"`
+	Data      string `json:"data"`      // Данные в hex без разделителей
+	Timestamp int64  `json:"timestamp"` // Время приема (Unix Nano)
+	// CANID - составляющие CAN ID, если включено их добавление.
+	CANID *CANID `json:"can_id,omitempty"`
+}
@@ -1,16 +1,36 @@
 package common
 
+import "fmt"
+
 // CommandType определяет тип команды от сервера.
 type CommandType string
 
 const (
 	// CommandTypeClearDTCs предписывает сбросить активные коды неисправностей.
 	CommandTypeClearDTCs CommandType = "clear_dtcs"
+	// CommandTypeSetInterval изменяет интервал публикации данных без переподключения.
+	CommandTypeSetInterval CommandType = "set_interval"
+	// CommandTypeSetTopic изменяет топики MQTT без переподключения.
+	CommandTypeSetTopic CommandType = "set_topic"
+	// CommandTypeResetConfig удаляет сохраненные переопределения настроек
+	// и возвращает значения, заданные флагами.
+	CommandTypeResetConfig CommandType = "reset_config"
+	// CommandTypeResync немедленно публикует текущий снимок данных и все активные DTC
+	// (без учета дедупликации), чтобы сервер мог восстановить пропущенное состояние.
+	CommandTypeResync CommandType = "resync"
+	// CommandTypeSetMetrics ограничивает публикуемый снимок данных списком метрик
+	// (пустой список снимает ограничение). DTC публикуются независимо от списка.
+	CommandTypeSetMetrics CommandType = "set_metrics"
+	// CommandTypeRequestPGN отправляет на шину J1939 запрос PGN (Request, PGN 0xEA00);
+	// ответ блока принимается и разбирается как обычно.
+	CommandTypeRequestPGN CommandType = "request_pgn"
 	// Другие типы команд могут быть добавлены здесь
 )
 
 // ServerCommand представляет команду, полученную от сервера через MQTT.
 type ServerCommand struct {
+	// ID - идентификатор команды, назначенный сервером; возвращается в CommandAck.
+	ID     string        `json:"id,omitempty"`
 	Type   CommandType   `json:"type"`
 	Params CommandParams `json:"params,omitempty"`
 }
@@ -24,12 +44,47 @@ type CommandParams struct {
 	// SPN и FMI могут использоваться для более специфичных команд, связанных с DTC.
 	SPN *int `json:"spn,omitempty"`
 	FMI *int `json:"fmi,omitempty"`
+	// Interval - новый интервал публикации для set_interval в формате time.ParseDuration (например, "5s").
+	Interval *string `json:"interval,omitempty"`
+	// Topic, DTCTopic, CommandTopic - новые топики для set_topic; не указанные остаются прежними.
+	Topic        *string `json:"topic,omitempty"`
+	DTCTopic     *string `json:"dtc_topic,omitempty"`
+	CommandTopic *string `json:"command_topic,omitempty"`
+	// Metrics - имена метрик (в стиле snake) для set_metrics; пустой список - все метрики.
+	Metrics *[]string `json:"metrics,omitempty"`
+	// PGN - запрашиваемый PGN для request_pgn, не больше MaxPGN.
+	PGN *int `json:"pgn,omitempty"`
+	// Destination - адрес назначения request_pgn (0-255); не указан - 255, все блоки.
+	Destination *int `json:"destination,omitempty"`
 	// Другие параметры для других команд
 }
 
+// MaxPGN - наибольший PGN J1939 (18 бит: EDP, DP, PF и PS).
+const MaxPGN = 0x3FFFF
+
+// RequestPGN проверяет параметры команды request_pgn и возвращает PGN и адрес назначения.
+func (p CommandParams) RequestPGN() (pgn uint32, dest uint8, err error) {
+	if p.PGN == nil {
+		return 0, 0, fmt.Errorf("не указан параметр pgn")
+	}
+	if *p.PGN < 0 || *p.PGN > MaxPGN {
+		return 0, 0, fmt.Errorf("PGN %d вне диапазона 0-0x%X", *p.PGN, MaxPGN)
+	}
+	dest = 0xFF
+	if p.Destination != nil {
+		if *p.Destination < 0 || *p.Destination > 0xFF {
+			return 0, 0, fmt.Errorf("адрес назначения %d вне диапазона 0-255", *p.Destination)
+		}
+		dest = uint8(*p.Destination)
+	}
+	return uint32(*p.PGN), dest, nil
+}
+
 // CommandAck представляет подтверждение выполнения команды.
 type CommandAck struct {
-	CommandID string `json:"command_id"` // Идентификатор исходной команды, если есть
-	Success   bool   `json:"success"`
-	Message   string `json:"message,omitempty"`
+	CommandID string      `json:"command_id"` // Идентификатор исходной команды, если есть
+	Type      CommandType `json:"type"`
+	Success   bool        `json:"success"`
+	// Message - ошибка выполнения, например отказ блока (NACK) или отсутствие подтверждения.
+	Message string `json:"message,omitempty"`
 }
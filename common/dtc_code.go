@@ -1,5 +1,12 @@
 package common
 
+// Тип кода неисправности J1587: номер в DTC может обозначать параметр (PID)
+// или подсистему (SID).
+const (
+	DTCCodeTypePID = "pid"
+	DTCCodeTypeSID = "sid"
+)
+
 // DTCCode представляет код неисправности (DTC)
 type DTCCode struct {
 	MID       int   `json:"mid"`           // Message Identifier (J1587) или Source Address (J1939)
@@ -8,4 +15,57 @@ type DTCCode struct {
 	FMI       int   `json:"fmi"`           // Failure Mode Identifier
 	OC        int   `json:"oc,omitempty"`  // Occurrence Count
 	Timestamp int64 `json:"timestamp"`     // Время обнаружения (Unix Nano)
+	// CodeType указывает, что означает номер в SPN для J1587: PID или SID (DTCCodeTypePID/DTCCodeTypeSID).
+	CodeType string `json:"code_type,omitempty"`
+	// VehicleID - VIN автомобиля или идентификатор из -vehicle-id.
+	VehicleID string `json:"vehicle_id,omitempty"`
+	// Seq - номер публикации, общий со снимками данных (-seq); 0 - не задан.
+	Seq uint64 `json:"seq,omitempty"`
+	// CANID - составляющие CAN ID кадра J1939, в котором получен код (-dtc-can-id).
+	CANID *CANID `json:"can_id,omitempty"`
+
+	FreezeFrame *FreezeFrame `json:"freeze_frame,omitempty"` // Стоп-кадр параметров (J1939 DM4)
+}
+
+// FreezeFrame содержит снимок параметров двигателя на момент регистрации DTC (J1939 DM4).
+// Указатели позволяют опускать недоступные параметры в JSON.
+type FreezeFrame struct {
+	EngineTorqueMode *int     `json:"engine_torque_mode,omitempty"` // SPN 899
+	BoostPressure    *float64 `json:"boost_pressure,omitempty"`     // SPN 102, кПа
+	EngineRPM        *float64 `json:"engine_rpm,omitempty"`         // SPN 190, об/мин
+	EngineLoad       *float64 `json:"engine_load,omitempty"`        // SPN 92, %
+	CoolantTemp      *float64 `json:"coolant_temp,omitempty"`       // SPN 110, °C
+	VehicleSpeed     *float64 `json:"vehicle_speed,omitempty"`      // SPN 84, км/ч
+	ManufacturerData []byte   `json:"manufacturer_data,omitempty"`  // Данные производителя после стандартных полей
+}
+
+// Виды отчетов DTCReport.
+const (
+	DTCReportActive   = "dm1" // Активные коды (DM1)
+	DTCReportPrevious = "dm2" // Ранее активные коды (DM2)
+)
+
+// DTCReport - коды неисправностей одного блока из сообщения DM1 или DM2 вместе
+// с состоянием его ламп. Публикуется вместо отдельных DTC при группировке (-dtc-group).
+type DTCReport struct {
+	SA        int        `json:"sa"`   // Source Address блока
+	Type      string     `json:"type"` // DTCReportActive или DTCReportPrevious
+	Lamps     LampStatus `json:"lamps"`
+	DTCs      []DTCCode  `json:"dtcs"`      // Пусто - у блока нет кодов
+	Timestamp int64      `json:"timestamp"` // Время приема сообщения (Unix Nano)
+	// VehicleID - VIN автомобиля или идентификатор из -vehicle-id.
+	VehicleID string `json:"vehicle_id,omitempty"`
+	// Seq - номер публикации, общий со снимками данных (-seq); 0 - не задан.
+	Seq uint64 `json:"seq,omitempty"`
+	// CANID - составляющие CAN ID кадра DM1/DM2 (-dtc-can-id).
+	CANID *CANID `json:"can_id,omitempty"`
+}
+
+// LampStatus - состояние ламп блока из первого байта DM1/DM2 (J1939-73).
+// nil - состояние не передается блоком.
+type LampStatus struct {
+	MIL *bool `json:"mil,omitempty"` // Malfunction Indicator Lamp
+	RSL *bool `json:"rsl,omitempty"` // Red Stop Lamp
+	AWL *bool `json:"awl,omitempty"` // Amber Warning Lamp
+	PL  *bool `json:"pl,omitempty"`  // Protect Lamp
 }
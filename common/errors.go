@@ -0,0 +1,27 @@
+package common
+
+import (
+	"errors"
+	"os"
+)
+
+// Ошибки подключения к шине. Агенты оборачивают в них системные ошибки
+// (fmt.Errorf с %w), поэтому причину можно определить через errors.Is
+// и, например, по-разному повторять попытки или точнее сообщать о ней в самопроверке.
+var (
+	// ErrPortNotFound - последовательный порт не существует (адаптер не подключен или неверный -port).
+	ErrPortNotFound = errors.New("порт не найден")
+	// ErrPortUnavailable - порт существует, но не открывается (например, занят другим процессом).
+	ErrPortUnavailable = errors.New("порт недоступен")
+	// ErrPermissionDenied - недостаточно прав для доступа к порту или сокету.
+	// Совпадает с os.ErrPermission, поэтому ему соответствуют и системные ошибки EACCES и EPERM.
+	ErrPermissionDenied = os.ErrPermission
+	// ErrInterfaceNotFound - сетевой интерфейс CAN не найден.
+	ErrInterfaceNotFound = errors.New("CAN-интерфейс не найден")
+	// ErrSocketUnavailable - сокет CAN нужного типа не создается (нет поддержки в ядре или ОС).
+	ErrSocketUnavailable = errors.New("сокет CAN недоступен")
+	// ErrSocketBindFailed - сокет CAN не привязывается к интерфейсу (например, интерфейс выключен).
+	ErrSocketBindFailed = errors.New("не удалось привязать сокет CAN")
+	// ErrBusSilent - с шины не принято ни одного байта или кадра.
+	ErrBusSilent = errors.New("шина молчит")
+)
@@ -0,0 +1,201 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// JSONNaming задает стиль имен полей в публикуемом JSON.
+type JSONNaming string
+
+const (
+	JSONNamingSnake JSONNaming = "snake" // engine_rpm, def_level
+	JSONNamingCamel JSONNaming = "camel" // engineRpm, defLevel
+)
+
+// ParseJSONNaming разбирает значение параметра -json-naming.
+func ParseJSONNaming(s string) (JSONNaming, error) {
+	switch n := JSONNaming(strings.ToLower(strings.TrimSpace(s))); n {
+	case JSONNamingSnake, JSONNamingCamel:
+		return n, nil
+	default:
+		return "", fmt.Errorf("неизвестный стиль имен %q (допустимо: snake, camel)", s)
+	}
+}
+
+// Key преобразует имя поля (PascalCase или snake_case) к стилю n.
+// Пустой стиль оставляет имя без изменений.
+func (n JSONNaming) Key(name string) string {
+	if n != JSONNamingSnake && n != JSONNamingCamel {
+		return name
+	}
+	words := splitWords(name)
+	if len(words) == 0 {
+		return name
+	}
+	if n == JSONNamingSnake {
+		return strings.Join(words, "_")
+	}
+	var b strings.Builder
+	b.WriteString(words[0])
+	for _, w := range words[1:] {
+		b.WriteString(strings.ToUpper(w[:1]) + w[1:])
+	}
+	return b.String()
+}
+
+// Keys преобразует список имен к стилю n.
+func (n JSONNaming) Keys(names []string) []string {
+	out := make([]string, len(names))
+	for i, name := range names {
+		out[i] = n.Key(name)
+	}
+	return out
+}
+
+// splitWords разбивает имя на слова в нижнем регистре. Аббревиатуры считаются
+// одним словом (EngineRPMRaw -> engine, rpm, raw), цифры остаются в слове
+// перед ними (WheelSpeedRear1Left -> wheel, speed, rear1, left).
+func splitWords(name string) []string {
+	var words []string
+	for _, part := range strings.Split(name, "_") {
+		runes := []rune(part)
+		start := 0
+		for i := 1; i < len(runes); i++ {
+			prev, cur := runes[i-1], runes[i]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsUpper(cur) && (!unicode.IsUpper(prev) || nextLower) {
+				words = append(words, strings.ToLower(string(runes[start:i])))
+				start = i
+			}
+		}
+		if start < len(runes) {
+			words = append(words, strings.ToLower(string(runes[start:])))
+		}
+	}
+	return words
+}
+
+// renameFields переименовывает ключи объекта верхнего уровня к стилю n.
+// Для ключей из nested (имена до переименования) переименовываются и ключи вложенного объекта.
+func renameFields(fields map[string]json.RawMessage, n JSONNaming, nested ...string) (map[string]json.RawMessage, error) {
+	out := make(map[string]json.RawMessage, len(fields))
+	for k, v := range fields {
+		for _, name := range nested {
+			if k != name {
+				continue
+			}
+			var inner map[string]json.RawMessage
+			if err := json.Unmarshal(v, &inner); err != nil {
+				return nil, err
+			}
+			if inner == nil {
+				continue // null
+			}
+			renamed, err := renameFields(inner, n)
+			if err != nil {
+				return nil, err
+			}
+			if v, err = json.Marshal(renamed); err != nil {
+				return nil, err
+			}
+		}
+		out[n.Key(k)] = v
+	}
+	return out, nil
+}
+
+// FieldAliases - переименование полей публикуемого JSON (-json-alias): имя поля
+// в стиле snake_case -> имя в выводе. Псевдонимы применяются после стиля имен
+// (-json-naming) к объектам на любой глубине, поэтому одинаково действуют на
+// снимки данных и DTC: поле переименовывается везде, где встречается.
+type FieldAliases map[string]string
+
+// ParseFieldAliases разбирает значение параметра -json-alias: пары поле=псевдоним
+// через запятую, например "engine_rpm=rpm,spn=SPN". Пустая строка - без псевдонимов.
+func ParseFieldAliases(s string) (FieldAliases, error) {
+	aliases := FieldAliases{}
+	targets := make(map[string]string)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, alias, ok := strings.Cut(item, "=")
+		name, alias = JSONNamingSnake.Key(strings.TrimSpace(name)), strings.TrimSpace(alias)
+		if !ok || name == "" || alias == "" {
+			return nil, fmt.Errorf("некорректный псевдоним %q, ожидается поле=псевдоним", item)
+		}
+		if name == "timestamp" {
+			// По timestamp получатели (CSV, SQLite, сокращение снимка) находят время снимка
+			return nil, fmt.Errorf("поле timestamp не переименовывается")
+		}
+		if _, dup := aliases[name]; dup {
+			return nil, fmt.Errorf("псевдоним поля %s задан повторно", name)
+		}
+		if other, dup := targets[alias]; dup {
+			return nil, fmt.Errorf("псевдоним %s задан для полей %s и %s", alias, other, name)
+		}
+		aliases[name] = alias
+		targets[alias] = name
+	}
+	return aliases, nil
+}
+
+// Key возвращает имя поля name в выводе: псевдоним, если он задан, иначе name.
+// name сравнивается в стиле snake_case, поэтому псевдоним действует при любом -json-naming.
+func (a FieldAliases) Key(name string) string {
+	if alias, ok := a[name]; ok {
+		return alias
+	}
+	if alias, ok := a[JSONNamingSnake.Key(name)]; ok {
+		return alias
+	}
+	return name
+}
+
+// Keys возвращает имена полей names в выводе.
+func (a FieldAliases) Keys(names []string) []string {
+	out := make([]string, len(names))
+	for i, name := range names {
+		out[i] = a.Key(name)
+	}
+	return out
+}
+
+// Apply переименовывает поля сериализованного JSON data. Без псевдонимов data не меняется;
+// иначе ключи объектов выводятся по алфавиту, числа сохраняются без изменения точности.
+func (a FieldAliases) Apply(data []byte) ([]byte, error) {
+	if len(a) == 0 {
+		return data, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(a.rename(v))
+}
+
+// rename переименовывает ключи объектов в v рекурсивно.
+func (a FieldAliases) rename(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, val := range v {
+			out[a.Key(k)] = a.rename(val)
+		}
+		return out
+	case []any:
+		for i, val := range v {
+			v[i] = a.rename(val)
+		}
+		return v
+	default:
+		return v
+	}
+}
@@ -0,0 +1,385 @@
+package common
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// J1587Payload описывает снимок данных, публикуемый агентом J1587.
+// Указатели равны nil, если значение не получено или недоступно, и тогда поле опускается в JSON.
+type J1587Payload struct {
+	Timestamp         string   `json:"timestamp"`                 // Время снимка, RFC 3339 (UTC)
+	VIN               *string  `json:"vin,omitempty"`             // PID 237, идентификационный номер
+	VehicleID         *string  `json:"vehicle_id,omitempty"`      // VIN или идентификатор из -vehicle-id
+	Speed             *float64 `json:"speed,omitempty"`           // PID 84, скорость автомобиля
+	EngineRPM         *float64 `json:"engine_rpm,omitempty"`      // PID 190, об/мин
+	EngineCoolantTemp *float64 `json:"coolant_temp,omitempty"`    // PID 110, °C
+	EngineOilPressure *float64 `json:"oil_pressure,omitempty"`    // PID 100
+	EngineLoad        *float64 `json:"engine_load,omitempty"`     // PID 92, %
+	FuelLevel         *float64 `json:"fuel_level,omitempty"`      // PID 96, %
+	BatteryVoltage    *float64 `json:"battery_voltage,omitempty"` // PID 168, В
+	AmbientAirTemp    *float64 `json:"ambient_temp,omitempty"`    // PID 171, °C
+	TotalDistance     *float64 `json:"total_distance,omitempty"`  // PID 245, км
+
+	// Trip - показатели текущей (незавершенной) поездки.
+	Trip *TripSummary `json:"trip,omitempty"`
+	// LastTrip - итоги последней завершенной поездки.
+	LastTrip *TripSummary `json:"last_trip,omitempty"`
+
+	// Extra - прочие значения снимка, например несглаженные значения с суффиксом _raw.
+	// Выводятся на верхнем уровне объекта рядом с остальными полями.
+	Extra map[string]any `json:"-"`
+	// Naming - стиль имен полей в JSON; пустой - имена как в тегах структуры.
+	Naming JSONNaming `json:"-"`
+	// NullKeys - метрики (имена в стиле snake), которые выводятся со значением null,
+	// если значения нет, вместо того чтобы опускаться.
+	NullKeys []string `json:"-"`
+	// Aliases - псевдонимы полей, применяемые после Naming.
+	Aliases FieldAliases `json:"-"`
+}
+
+// NewJ1587Payload собирает J1587Payload из снимка метрик data.
+// Метрики, не описанные полями структуры, переносятся в Extra.
+func NewJ1587Payload(data map[string]any, timestamp time.Time) J1587Payload {
+	f := payloadFields(clonePayloadMap(data))
+	p := J1587Payload{
+		Timestamp:         timestamp.UTC().Format(time.RFC3339Nano),
+		VIN:               f.string("vin"),
+		VehicleID:         f.string("vehicle_id"),
+		Speed:             f.float("speed"),
+		EngineRPM:         f.float("engine_rpm"),
+		EngineCoolantTemp: f.float("coolant_temp"),
+		EngineOilPressure: f.float("oil_pressure"),
+		EngineLoad:        f.float("engine_load"),
+		FuelLevel:         f.float("fuel_level"),
+		BatteryVoltage:    f.float("battery_voltage"),
+		AmbientAirTemp:    f.float("ambient_temp"),
+		TotalDistance:     f.float("total_distance"),
+	}
+	if v, ok := f.take("trip").(TripSummary); ok {
+		p.Trip = &v
+	}
+	if v, ok := f.take("last_trip").(TripSummary); ok {
+		p.LastTrip = &v
+	}
+	p.Extra = f.rest()
+	return p
+}
+
+// MarshalJSON сериализует поля структуры и значения Extra в один объект
+// с именами полей в стиле p.Naming (включая поля trip и last_trip).
+func (p J1587Payload) MarshalJSON() ([]byte, error) {
+	type plain J1587Payload
+	return marshalPayload(plain(p), p.Extra, p.Naming, p.NullKeys, p.Aliases, "trip", "last_trip")
+}
+
+// J1939Payload описывает снимок данных, публикуемый агентом J1939.
+// Указатели равны nil, если значение не получено или блок сообщил "недоступно",
+// и тогда поле опускается в JSON.
+type J1939Payload struct {
+	Timestamp                string   `json:"timestamp"`                            // Время снимка, RFC 3339 (UTC)
+	VIN                      *string  `json:"vin,omitempty"`                        // SPN 237, идентификационный номер
+	VehicleID                *string  `json:"vehicle_id,omitempty"`                 // VIN или идентификатор из -vehicle-id
+	EngineRPM                *float64 `json:"engine_rpm,omitempty"`                 // SPN 190, об/мин
+	EngineLoad               *float64 `json:"engine_load,omitempty"`                // SPN 92, %
+	EngineTorque             *float64 `json:"engine_torque,omitempty"`              // SPN 513, % эталонного момента
+	AcceleratorPedal         *float64 `json:"accelerator_pedal,omitempty"`          // SPN 91, %
+	Latitude                 *float64 `json:"latitude,omitempty"`                   // SPN 584, градусы
+	Longitude                *float64 `json:"longitude,omitempty"`                  // SPN 585, градусы
+	FuelConsumption          *float64 `json:"fuel_consumption,omitempty"`           // SPN 183, л/ч
+	EngineCoolantTemp        *float64 `json:"coolant_temp,omitempty"`               // SPN 110, °C
+	FuelTemp                 *float64 `json:"fuel_temp,omitempty"`                  // SPN 174, °C
+	EngineOilTemp            *float64 `json:"engine_oil_temp,omitempty"`            // SPN 175, °C
+	AmbientAirTemp           *float64 `json:"ambient_temp,omitempty"`               // SPN 171, °C
+	BoostPressure            *float64 `json:"boost_pressure,omitempty"`             // SPN 102, кПа
+	IntakeManifoldTemp       *float64 `json:"intake_manifold_temp,omitempty"`       // SPN 105, °C
+	TurbochargerSpeed        *float64 `json:"turbocharger_speed,omitempty"`         // SPN 103, об/мин
+	ExhaustGasTemp           *float64 `json:"exhaust_gas_temp,omitempty"`           // SPN 173, °C
+	ExhaustGasTempRight      *float64 `json:"exhaust_gas_temp_right,omitempty"`     // SPN 2433, °C
+	ExhaustGasTempLeft       *float64 `json:"exhaust_gas_temp_left,omitempty"`      // SPN 2434, °C
+	DEFLevel                 *float64 `json:"def_level,omitempty"`                  // SPN 1761, %
+	DEFTemp                  *float64 `json:"def_temp,omitempty"`                   // SPN 3031, °C
+	DPFSootLoad              *float64 `json:"dpf_soot_load,omitempty"`              // SPN 3719, %
+	DPFAshLoad               *float64 `json:"dpf_ash_load,omitempty"`               // SPN 3720, %
+	DPFRegenActive           *bool    `json:"dpf_regen_active,omitempty"`           // SPN 3700
+	DPFRegenInhibited        *bool    `json:"dpf_regen_inhibited,omitempty"`        // SPN 3702
+	RetarderTorque           *float64 `json:"retarder_torque,omitempty"`            // SPN 520, %
+	RetarderSelection        *float64 `json:"retarder_selection,omitempty"`         // SPN 1716, %
+	ABSActive                *bool    `json:"abs_active,omitempty"`                 // SPN 563
+	EBSBrakeSwitch           *bool    `json:"ebs_brake_switch,omitempty"`           // SPN 1121
+	BrakePedalPosition       *float64 `json:"brake_pedal_position,omitempty"`       // SPN 521, %
+	TransmissionSelectedGear *int     `json:"transmission_selected_gear,omitempty"` // SPN 524
+	TransmissionCurrentGear  *int     `json:"transmission_current_gear,omitempty"`  // SPN 523
+	FrontAxleSpeed           *float64 `json:"front_axle_speed,omitempty"`           // SPN 904, км/ч
+	WheelSpeedFrontLeft      *float64 `json:"wheel_speed_front_left,omitempty"`     // SPN 905, км/ч
+	WheelSpeedFrontRight     *float64 `json:"wheel_speed_front_right,omitempty"`    // SPN 906, км/ч
+	WheelSpeedRear1Left      *float64 `json:"wheel_speed_rear1_left,omitempty"`     // SPN 907, км/ч
+	WheelSpeedRear1Right     *float64 `json:"wheel_speed_rear1_right,omitempty"`    // SPN 908, км/ч
+	WheelSpeedRear2Left      *float64 `json:"wheel_speed_rear2_left,omitempty"`     // SPN 909, км/ч
+	WheelSpeedRear2Right     *float64 `json:"wheel_speed_rear2_right,omitempty"`    // SPN 910, км/ч
+	PoweredVehicleWeight     *float64 `json:"powered_vehicle_weight,omitempty"`     // SPN 1585, кг
+	GrossCombinationWeight   *float64 `json:"gross_combination_weight,omitempty"`   // SPN 1760, кг
+	TotalDistance            *float64 `json:"total_distance,omitempty"`             // SPN 917, км
+
+	// MalformedFrames - число усеченных или некорректных кадров по PGN ("0xFECA" -> 3).
+	MalformedFrames map[string]uint64 `json:"malformed_frames,omitempty"`
+	// SensorErrors - метрики, для которых блок передает индикатор ошибки (0xFE, 0xFExx);
+	// их значения в снимок не включаются.
+	SensorErrors []string `json:"sensor_errors,omitempty"`
+	// Readiness - готовность систем бортовой диагностики (DM5).
+	Readiness *Readiness `json:"readiness,omitempty"`
+	// Trip - показатели текущей (незавершенной) поездки.
+	Trip *TripSummary `json:"trip,omitempty"`
+	// LastTrip - итоги последней завершенной поездки.
+	LastTrip *TripSummary `json:"last_trip,omitempty"`
+
+	// Extra - прочие значения снимка, например несглаженные значения с суффиксом _raw.
+	// Выводятся на верхнем уровне объекта рядом с остальными полями.
+	Extra map[string]any `json:"-"`
+	// Naming - стиль имен полей в JSON; пустой - имена как в тегах структуры.
+	Naming JSONNaming `json:"-"`
+	// NullKeys - метрики (имена в стиле snake), которые выводятся со значением null,
+	// если значения нет, вместо того чтобы опускаться.
+	NullKeys []string `json:"-"`
+	// Aliases - псевдонимы полей, применяемые после Naming.
+	Aliases FieldAliases `json:"-"`
+}
+
+// Readiness содержит состояние готовности систем бортовой диагностики (DM5).
+// Битовые маски мониторов: бит установлен, если монитор поддерживается (Supported)
+// или завершил проверку (Completed).
+type Readiness struct {
+	SourceAddress            uint8  `json:"sa"`
+	ActiveDTCCount           int    `json:"active_dtc_count"`            // SPN 1218
+	PreviouslyActiveDTCCount int    `json:"previously_active_dtc_count"` // SPN 1219
+	OBDCompliance            uint8  `json:"obd_compliance"`              // SPN 1220
+	ContinuousSupported      uint8  `json:"continuous_supported"`        // SPN 1221, биты 0-2
+	ContinuousCompleted      uint8  `json:"continuous_completed"`        // SPN 1221, биты 4-6 (инвертированы)
+	NonContinuousSupported   uint16 `json:"non_continuous_supported"`    // SPN 1222
+	NonContinuousCompleted   uint16 `json:"non_continuous_completed"`    // SPN 1223 (инвертирован)
+}
+
+// TripSummary - показатели поездки: от запуска двигателя до его остановки
+// (для незавершенной поездки - до последнего снимка).
+type TripSummary struct {
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	DurationS float64   `json:"duration_s"`
+	// IdleS - время работы двигателя без движения (холостой ход), с.
+	IdleS float64 `json:"idle_s"`
+	// MovingS - время движения, с.
+	MovingS float64 `json:"moving_s"`
+	// DistanceKm - пройденное по одометру расстояние; nil, если одометр недоступен.
+	DistanceKm *float64 `json:"distance_km,omitempty"`
+	// AvgSpeedKmh - средняя скорость за поездку; nil, если расстояние неизвестно.
+	AvgSpeedKmh *float64 `json:"avg_speed_kmh,omitempty"`
+}
+
+// NewJ1939Payload собирает J1939Payload из снимка метрик data.
+// Метрики, не описанные полями структуры, переносятся в Extra.
+func NewJ1939Payload(data map[string]any, timestamp time.Time) J1939Payload {
+	f := payloadFields(clonePayloadMap(data))
+	p := J1939Payload{
+		Timestamp:                timestamp.UTC().Format(time.RFC3339Nano),
+		VIN:                      f.string("vin"),
+		VehicleID:                f.string("vehicle_id"),
+		EngineRPM:                f.float("engine_rpm"),
+		EngineLoad:               f.float("engine_load"),
+		EngineTorque:             f.float("engine_torque"),
+		AcceleratorPedal:         f.float("accelerator_pedal"),
+		Latitude:                 f.float("latitude"),
+		Longitude:                f.float("longitude"),
+		FuelConsumption:          f.float("fuel_consumption"),
+		EngineCoolantTemp:        f.float("coolant_temp"),
+		FuelTemp:                 f.float("fuel_temp"),
+		EngineOilTemp:            f.float("engine_oil_temp"),
+		AmbientAirTemp:           f.float("ambient_temp"),
+		BoostPressure:            f.float("boost_pressure"),
+		IntakeManifoldTemp:       f.float("intake_manifold_temp"),
+		TurbochargerSpeed:        f.float("turbocharger_speed"),
+		ExhaustGasTemp:           f.float("exhaust_gas_temp"),
+		ExhaustGasTempRight:      f.float("exhaust_gas_temp_right"),
+		ExhaustGasTempLeft:       f.float("exhaust_gas_temp_left"),
+		DEFLevel:                 f.float("def_level"),
+		DEFTemp:                  f.float("def_temp"),
+		DPFSootLoad:              f.float("dpf_soot_load"),
+		DPFAshLoad:               f.float("dpf_ash_load"),
+		DPFRegenActive:           f.bool("dpf_regen_active"),
+		DPFRegenInhibited:        f.bool("dpf_regen_inhibited"),
+		RetarderTorque:           f.float("retarder_torque"),
+		RetarderSelection:        f.float("retarder_selection"),
+		ABSActive:                f.bool("abs_active"),
+		EBSBrakeSwitch:           f.bool("ebs_brake_switch"),
+		BrakePedalPosition:       f.float("brake_pedal_position"),
+		TransmissionSelectedGear: f.int("transmission_selected_gear"),
+		TransmissionCurrentGear:  f.int("transmission_current_gear"),
+		FrontAxleSpeed:           f.float("front_axle_speed"),
+		WheelSpeedFrontLeft:      f.float("wheel_speed_front_left"),
+		WheelSpeedFrontRight:     f.float("wheel_speed_front_right"),
+		WheelSpeedRear1Left:      f.float("wheel_speed_rear1_left"),
+		WheelSpeedRear1Right:     f.float("wheel_speed_rear1_right"),
+		WheelSpeedRear2Left:      f.float("wheel_speed_rear2_left"),
+		WheelSpeedRear2Right:     f.float("wheel_speed_rear2_right"),
+		PoweredVehicleWeight:     f.float("powered_vehicle_weight"),
+		GrossCombinationWeight:   f.float("gross_combination_weight"),
+		TotalDistance:            f.float("total_distance"),
+	}
+	if v, ok := f.take("malformed_frames").(map[string]uint64); ok {
+		p.MalformedFrames = v
+	}
+	if v, ok := f.take("sensor_errors").([]string); ok {
+		p.SensorErrors = v
+	}
+	if v, ok := f.take("readiness").(Readiness); ok {
+		p.Readiness = &v
+	}
+	if v, ok := f.take("trip").(TripSummary); ok {
+		p.Trip = &v
+	}
+	if v, ok := f.take("last_trip").(TripSummary); ok {
+		p.LastTrip = &v
+	}
+	p.Extra = f.rest()
+	return p
+}
+
+// MarshalJSON сериализует поля структуры и значения Extra в один объект
+// с именами полей в стиле p.Naming (включая поля readiness, trip и last_trip).
+func (p J1939Payload) MarshalJSON() ([]byte, error) {
+	type plain J1939Payload
+	return marshalPayload(plain(p), p.Extra, p.Naming, p.NullKeys, p.Aliases, "readiness", "trip", "last_trip")
+}
+
+// payloadFields - метрики снимка, из которых собирается payload.
+// Разобранные ключи удаляются, оставшиеся возвращает rest.
+type payloadFields map[string]any
+
+// take извлекает значение метрики key и удаляет его из набора.
+func (f payloadFields) take(key string) any {
+	v := f[key]
+	delete(f, key)
+	return v
+}
+
+// float извлекает числовое значение; nil, если метрики нет или она не число.
+func (f payloadFields) float(key string) *float64 {
+	var v float64
+	switch n := f.take(key).(type) {
+	case float64:
+		v = n
+	case float32:
+		v = float64(n)
+	case int:
+		v = float64(n)
+	case int64:
+		v = float64(n)
+	case uint8:
+		v = float64(n)
+	case uint16:
+		v = float64(n)
+	case uint32:
+		v = float64(n)
+	default:
+		return nil
+	}
+	return &v
+}
+
+// int извлекает целочисленное значение; nil, если метрики нет или она не целое.
+func (f payloadFields) int(key string) *int {
+	var v int
+	switch n := f.take(key).(type) {
+	case int:
+		v = n
+	case int64:
+		v = int(n)
+	case uint8:
+		v = int(n)
+	case uint16:
+		v = int(n)
+	case uint32:
+		v = int(n)
+	default:
+		return nil
+	}
+	return &v
+}
+
+// string извлекает непустое строковое значение; nil, если метрики нет.
+func (f payloadFields) string(key string) *string {
+	v, ok := f.take(key).(string)
+	if !ok || v == "" {
+		return nil
+	}
+	return &v
+}
+
+// bool извлекает логическое значение; nil, если метрики нет или состояние неизвестно.
+func (f payloadFields) bool(key string) *bool {
+	v, ok := f.take(key).(bool)
+	if !ok {
+		return nil
+	}
+	return &v
+}
+
+// rest возвращает метрики, не разобранные в поля структуры; nil, если таких нет.
+func (f payloadFields) rest() map[string]any {
+	if len(f) == 0 {
+		return nil
+	}
+	return f
+}
+
+// clonePayloadMap копирует карту верхнего уровня, чтобы не изменять снимок вызывающего.
+func clonePayloadMap(data map[string]any) map[string]any {
+	out := make(map[string]any, len(data))
+	for k, v := range data {
+		out[k] = v
+	}
+	return out
+}
+
+// marshalPayload сериализует v (структуру) и дописывает в тот же объект значения extra.
+// Ключи extra, совпадающие с полями структуры, не выводятся повторно; отсутствующие
+// ключи nulls выводятся со значением null.
+// Имена полей приводятся к стилю naming, для ключей nested - и во вложенных объектах,
+// затем переименовываются по aliases.
+func marshalPayload(v any, extra map[string]any, naming JSONNaming, nulls []string, aliases FieldAliases, nested ...string) ([]byte, error) {
+	base, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if len(extra) == 0 && naming == "" && len(nulls) == 0 {
+		return aliases.Apply(base)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(base, &fields); err != nil {
+		return nil, err
+	}
+	for k, val := range extra {
+		if _, exists := fields[k]; exists {
+			continue
+		}
+		raw, err := json.Marshal(val)
+		if err != nil {
+			return nil, err
+		}
+		fields[k] = raw
+	}
+	for _, k := range nulls {
+		if _, exists := fields[k]; !exists {
+			fields[k] = json.RawMessage("null")
+		}
+	}
+	if naming != "" {
+		if fields, err = renameFields(fields, naming, nested...); err != nil {
+			return nil, err
+		}
+	}
+	out, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	return aliases.Apply(out)
+}
@@ -0,0 +1,233 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// update перезаписывает golden-файлы текущим выводом: go test ./common -run Golden -update
+var update = flag.Bool("update", false, "перезаписать golden-файлы в testdata")
+
+var (
+	payloadTime = time.Date(2024, 5, 17, 8, 30, 0, 0, time.UTC)
+
+	testTrip = TripSummary{
+		Start:       time.Date(2024, 5, 17, 7, 0, 0, 0, time.UTC),
+		End:         payloadTime,
+		DurationS:   5400,
+		IdleS:       600,
+		MovingS:     4800,
+		DistanceKm:  ptr(120.5),
+		AvgSpeedKmh: ptr(80.3),
+	}
+	// testLastTrip - поездка без одометра: расстояние и средняя скорость опускаются.
+	testLastTrip = TripSummary{
+		Start:     time.Date(2024, 5, 16, 18, 0, 0, 0, time.UTC),
+		End:       time.Date(2024, 5, 16, 18, 20, 0, 0, time.UTC),
+		DurationS: 1200,
+		IdleS:     300,
+		MovingS:   900,
+	}
+)
+
+func ptr[T any](v T) *T { return &v }
+
+// j1587FullData - снимок J1587 со всеми полями J1587Payload и несглаженным значением в Extra.
+func j1587FullData() map[string]any {
+	return map[string]any{
+		"vin":             "1FUJGLDR12LM12345",
+		"vehicle_id":      "1FUJGLDR12LM12345",
+		"speed":           88.0,
+		"engine_rpm":      1500.0,
+		"coolant_temp":    85.0,
+		"oil_pressure":    310.0,
+		"engine_load":     42.5,
+		"fuel_level":      63.2,
+		"battery_voltage": 13.8,
+		"ambient_temp":    -5.0,
+		"total_distance":  198765.287,
+		"trip":            testTrip,
+		"last_trip":       testLastTrip,
+		"engine_rpm_raw":  1512.25,
+	}
+}
+
+// j1939FullData - снимок J1939 со всеми полями J1939Payload и несглаженным значением в Extra.
+func j1939FullData() map[string]any {
+	return map[string]any{
+		"vin":                        "1FUJGLDR12LM12345",
+		"vehicle_id":                 "truck-07",
+		"engine_rpm":                 1500.0,
+		"engine_load":                55.0,
+		"engine_torque":              38.0,
+		"accelerator_pedal":          27.2,
+		"latitude":                   55.751244,
+		"longitude":                  37.618423,
+		"fuel_consumption":           24.35,
+		"coolant_temp":               87.0,
+		"fuel_temp":                  35.0,
+		"engine_oil_temp":            96.5,
+		"ambient_temp":               -12.0,
+		"boost_pressure":             250.0,
+		"intake_manifold_temp":       40.0,
+		"turbocharger_speed":         64000.0,
+		"exhaust_gas_temp":           412.5,
+		"exhaust_gas_temp_right":     405.0,
+		"exhaust_gas_temp_left":      398.75,
+		"def_level":                  71.6,
+		"def_temp":                   18.0,
+		"dpf_soot_load":              34.0,
+		"dpf_ash_load":               12.0,
+		"dpf_regen_active":           true,
+		"dpf_regen_inhibited":        false,
+		"retarder_torque":            -20.0,
+		"retarder_selection":         0.0,
+		"abs_active":                 false,
+		"ebs_brake_switch":           true,
+		"brake_pedal_position":       14.4,
+		"transmission_selected_gear": 12,
+		"transmission_current_gear":  11,
+		"front_axle_speed":           82.5,
+		"wheel_speed_front_left":     82.4,
+		"wheel_speed_front_right":    82.6,
+		"wheel_speed_rear1_left":     82.3,
+		"wheel_speed_rear1_right":    82.7,
+		"wheel_speed_rear2_left":     82.2,
+		"wheel_speed_rear2_right":    82.8,
+		"powered_vehicle_weight":     18500.0,
+		"gross_combination_weight":   39800.0,
+		"total_distance":             345678.125,
+		"malformed_frames":           map[string]uint64{"0xFECA": 3, "0xFEEC": 1},
+		"sensor_errors":              []string{"fuel_temp"},
+		"readiness": Readiness{
+			SourceAddress:            0x00,
+			ActiveDTCCount:           1,
+			PreviouslyActiveDTCCount: 2,
+			OBDCompliance:            5,
+			ContinuousSupported:      0x07,
+			ContinuousCompleted:      0x05,
+			NonContinuousSupported:   0x0169,
+			NonContinuousCompleted:   0x0041,
+		},
+		"trip":           testTrip,
+		"last_trip":      testLastTrip,
+		"engine_rpm_raw": 1496.125,
+	}
+}
+
+// j1587Sparse и j1939Sparse - снимки, в которых получена лишь часть параметров.
+// Нулевые значения (0 °C, false) выводятся, отсутствующие параметры - опускаются.
+func j1587Sparse() J1587Payload {
+	return NewJ1587Payload(map[string]any{"speed": 0.0, "engine_rpm": 650.0, "coolant_temp": 0.0}, payloadTime)
+}
+
+func j1939Sparse() J1939Payload {
+	return NewJ1939Payload(map[string]any{"engine_rpm": 650.0, "ambient_temp": 0.0, "dpf_regen_active": false, "transmission_current_gear": 0}, payloadTime)
+}
+
+func TestPayloadGolden(t *testing.T) {
+	aliases, err := ParseFieldAliases("engine_rpm=rpm,vin=VIN,distance_km=trip_km")
+	if err != nil {
+		t.Fatalf("ParseFieldAliases: %v", err)
+	}
+	tests := []struct {
+		name    string
+		payload func() json.Marshaler
+	}{
+		{"j1587_full", func() json.Marshaler { return NewJ1587Payload(j1587FullData(), payloadTime) }},
+		{"j1587_sparse", func() json.Marshaler { return j1587Sparse() }},
+		{"j1587_camel", func() json.Marshaler {
+			p := NewJ1587Payload(j1587FullData(), payloadTime)
+			p.Naming = JSONNamingCamel
+			return p
+		}},
+		{"j1587_null_keys", func() json.Marshaler {
+			p := j1587Sparse()
+			p.NullKeys = []string{"engine_rpm", "oil_pressure", "fuel_level"}
+			return p
+		}},
+		{"j1587_aliases", func() json.Marshaler {
+			p := NewJ1587Payload(j1587FullData(), payloadTime)
+			p.Aliases = aliases
+			return p
+		}},
+		{"j1939_full", func() json.Marshaler { return NewJ1939Payload(j1939FullData(), payloadTime) }},
+		{"j1939_sparse", func() json.Marshaler { return j1939Sparse() }},
+		{"j1939_camel", func() json.Marshaler {
+			p := NewJ1939Payload(j1939FullData(), payloadTime)
+			p.Naming = JSONNamingCamel
+			return p
+		}},
+		{"j1939_null_keys", func() json.Marshaler {
+			p := j1939Sparse()
+			p.NullKeys = []string{"engine_rpm", "def_level", "total_distance"}
+			return p
+		}},
+		{"j1939_aliases", func() json.Marshaler {
+			p := NewJ1939Payload(j1939FullData(), payloadTime)
+			p.Naming = JSONNamingCamel
+			p.Aliases = aliases
+			return p
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, err := json.Marshal(tt.payload())
+			if err != nil {
+				t.Fatalf("json.Marshal: %v", err)
+			}
+			var got bytes.Buffer
+			if err := json.Indent(&got, raw, "", "  "); err != nil {
+				t.Fatalf("json.Indent: %v", err)
+			}
+			got.WriteByte('\n')
+
+			path := filepath.Join("testdata", tt.name+".golden")
+			if *update {
+				if err := os.WriteFile(path, got.Bytes(), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("%v (создайте golden-файлы с флагом -update)", err)
+			}
+			if !bytes.Equal(got.Bytes(), want) {
+				t.Errorf("JSON отличается от %s; если изменение намеренное, обновите файл с флагом -update\nполучено:\n%s\nожидается:\n%s", path, got.Bytes(), want)
+			}
+		})
+	}
+}
+
+// TestPayloadFullDataCoversFields проверяет, что снимки для golden-файлов *_full заполняют
+// все поля структур: новое поле без значения в снимке не попало бы в golden-файл.
+func TestPayloadFullDataCoversFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload any
+	}{
+		{"J1587Payload", NewJ1587Payload(j1587FullData(), payloadTime)},
+		{"J1939Payload", NewJ1939Payload(j1939FullData(), payloadTime)},
+	}
+	for _, tt := range tests {
+		v := reflect.ValueOf(tt.payload)
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			tag := strings.Split(field.Tag.Get("json"), ",")[0]
+			if tag == "-" || tag == "" {
+				continue
+			}
+			if v.Field(i).IsZero() {
+				t.Errorf("%s.%s (%q) не заполнено в полном снимке", tt.name, field.Name, tag)
+			}
+		}
+	}
+}
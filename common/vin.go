@@ -0,0 +1,26 @@
+package common
+
+import "strings"
+
+// vinMaxLength - максимальная длина принимаемого VIN. Стандартный VIN (ISO 3779)
+// содержит 17 символов, но блоки старых автомобилей передают и более короткие номера.
+const vinMaxLength = 32
+
+// ParseVIN извлекает VIN из данных J1939 PGN 0xFEEC (SPN 237) или J1587 PID 237.
+// Поле может завершаться разделителем '*' и дополняться пробелами, NUL или 0xFF.
+// Возвращает false, если номер пуст, слишком длинный или содержит непечатаемые символы.
+func ParseVIN(raw []byte) (string, bool) {
+	if i := strings.IndexByte(string(raw), '*'); i >= 0 {
+		raw = raw[:i]
+	}
+	vin := strings.Trim(string(raw), " \x00\xff")
+	if vin == "" || len(vin) > vinMaxLength {
+		return "", false
+	}
+	for i := 0; i < len(vin); i++ {
+		if vin[i] < 0x21 || vin[i] > 0x7E {
+			return "", false
+		}
+	}
+	return vin, true
+}
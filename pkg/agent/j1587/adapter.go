@@ -0,0 +1,110 @@
+package j1587
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"strings"
+	"time"
+)
+
+// DefaultAdapterHandshake - сколько после открытия порта ожидается текстовый вывод адаптера.
+const DefaultAdapterHandshake = 2 * time.Second
+
+// adapterTextMarkers - фрагменты текстового вывода USB-адаптеров (ELM327 и подобных):
+// приглашение, эхо команд, ответы и баннеры.
+var adapterTextMarkers = []string{">", "OK", "ELM", "STN", "OBD", "?", "\r", "\n"}
+
+// minAdapterTextLen - минимальная длина текста, который считается выводом адаптера.
+// Более короткие печатные последовательности встречаются в двоичных данных J1587.
+const minAdapterTextLen = 2
+
+// isTextByte сообщает, может ли байт быть частью текстового вывода адаптера.
+func isTextByte(b byte) bool {
+	return (b >= 0x20 && b <= 0x7E) || b == '\r' || b == '\n' || b == '\t'
+}
+
+// looksLikeAdapterText сообщает, похожи ли печатные байты text на вывод адаптера,
+// а не на начало двоичных данных шины.
+func looksLikeAdapterText(text []byte) bool {
+	if len(text) < minAdapterTextLen {
+		return false
+	}
+	for _, marker := range adapterTextMarkers {
+		if bytes.Contains(text, []byte(marker)) {
+			return true
+		}
+	}
+	return false
+}
+
+// skipAdapterChatter выполняется перед разбором фреймов: в течение timeout после запуска
+// читает порт и отбрасывает текстовый вывод адаптера (приглашения, эхо, баннеры вида
+// "ELM327 v1.5\r\r>"), который иначе разбирался бы как фреймы J1587.
+// Фаза завершается по истечении timeout или на первом двоичном байте; двоичные байты
+// сохраняются в p.pending и разбираются как обычно.
+func (p *Bus) skipAdapterChatter(timeout time.Duration) {
+	if timeout <= 0 {
+		return
+	}
+	buf := make([]byte, 128)
+	var text []byte
+	var stripped []byte
+	deadline := time.Now().Add(timeout)
+
+	defer func() {
+		if len(stripped) > 0 {
+			log.Printf("J1587: отброшен текстовый вывод адаптера (%d байт): %q", len(stripped), stripped)
+			if bytes.Contains(bytes.ToUpper(stripped), []byte("ELM")) {
+				log.Println("J1587: похоже, подключен адаптер ELM327 или совместимый. Такие адаптеры обычно не передают сырые данные J1708, используйте адаптер J1708/RS-485")
+			}
+		}
+	}()
+
+	for time.Now().Before(deadline) {
+		select {
+		case <-p.stopChan:
+			return
+		default:
+		}
+		n, err := p.port.Read(buf)
+		if err != nil && err != io.EOF {
+			log.Printf("Ошибка чтения порта: %v", err)
+		}
+		for i, b := range buf[:n] {
+			if isTextByte(b) {
+				text = append(text, b)
+				continue
+			}
+			// Двоичные данные: текст перед ними отбрасывается, только если похож на вывод адаптера
+			if looksLikeAdapterText(text) {
+				stripped = append(stripped, text...)
+			} else {
+				p.pending = append(p.pending, text...)
+			}
+			p.pending = append(p.pending, buf[i:n]...)
+			return
+		}
+		// Законченная строка или приглашение - отбрасываем сразу, не дожидаясь двоичных данных
+		if looksLikeAdapterText(text) && strings.IndexByte("\r\n>", text[len(text)-1]) >= 0 {
+			stripped = append(stripped, text...)
+			text = nil
+		}
+	}
+	// Время вышло: незавершенный текст отбрасывается, если похож на вывод адаптера
+	if looksLikeAdapterText(text) {
+		stripped = append(stripped, text...)
+	} else {
+		p.pending = append(p.pending, text...)
+	}
+}
+
+// read читает байты шины: сначала сохраненные фазой skipAdapterChatter, затем из порта.
+func (p *Bus) read(buf []byte) (int, error) {
+	if len(p.pending) > 0 {
+		n := copy(buf, p.pending)
+		p.pending = p.pending[n:]
+		return n, nil
+	}
+	return p.port.Read(buf)
+}
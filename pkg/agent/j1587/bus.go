@@ -0,0 +1,402 @@
+package j1587
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/serebryakov7/j1708-stats/common"
+	"github.com/serebryakov7/j1708-stats/pkg/clock"
+	"github.com/serebryakov7/j1708-stats/pkg/logging"
+	"github.com/serebryakov7/j1708-stats/pkg/sink"
+	"github.com/serebryakov7/j1708-stats/pkg/storage"
+)
+
+const (
+	interFrameGap = 4 * time.Millisecond
+	// activeDTCTimeout - время, после которого коды модуля, не передающего PID 194,
+	// перестают считаться активными.
+	activeDTCTimeout = 30 * time.Second
+)
+
+// Bus реализует интерфейс Bus для протокола J1587
+type Bus struct {
+	port      io.ReadWriteCloser
+	data      *J1587Data // Теперь это ссылка на структуру из data.go
+	frames    chan []byte
+	stopChan  chan struct{}
+	isRunning bool
+	dtcChan   chan common.DTCCode // Канал для отправки DTC
+	db        *bolt.DB            // База данных состояния агента (VIN, поездки, настройки); nil без bbolt
+	// dtcStore хранит опубликованные DTC для дедупликации; nil при -dtc-store none.
+	dtcStore storage.DTCStore
+	// dtcWindow подавляет повторную публикацию DTC в коротком окне независимо от bbolt.
+	dtcWindow *storage.DTCWindow
+	// ocReporting - публиковать код повторно при росте счетчика срабатываний.
+	ocReporting bool
+	// activeDTCs - последние списки активных кодов модулей (PID 194) для команды resync.
+	activeDTCs *storage.ActiveDTCs
+	// tp собирает сообщения транспортного протокола J1587 (PID 197/198).
+	tp *tpReassembler
+	// localMID - MID, с которым агент передает сообщения (см. SetLocalMID).
+	localMID byte
+	// txMutex не дает фреймам разных отправителей перемешаться в порту.
+	txMutex sync.Mutex
+	// tpSendMutex допускает одно передающее соединение TP; tpReplies - ответы получателя (CTS, EOM).
+	tpSendMutex sync.Mutex
+	tpReplies   chan tpReply
+	// diagReplies - ответы модулей на запросы PID 195, адресованные агенту (PID 196).
+	diagReplies chan tpReply
+	// framing - способ разделения потока байтов на фреймы (framingTiming или framingChecksum).
+	framing string
+	// adapterHandshake - длительность фазы отбрасывания текстового вывода адаптера при запуске.
+	adapterHandshake time.Duration
+	// pending - двоичные байты, прочитанные в фазе skipAdapterChatter и еще не разобранные.
+	pending []byte
+	// validFrames - число фреймов с верной контрольной суммой.
+	validFrames atomic.Uint64
+	// pidObserver, если задан, вызывается для каждого разобранного PID (см. SetPIDObserver).
+	pidObserver func(mid, pid int, data []byte)
+	// framesReceived - число фреймов, принятых с шины.
+	framesReceived atomic.Uint64
+	// clock - источник времени разбора: время DTC, сборка TP, активные коды.
+	// Паузы между фреймами всегда измеряются по системному времени.
+	clock clock.Clock
+}
+
+// NewBus создает новый экземпляр J1587Protocol.
+// port - любой источник байтов шины: последовательный порт (*serial.Port)
+// или, например, заготовленный поток байтов при тестировании разбора фреймов.
+// Read должен возвращать n == 0 по таймауту, чтобы фрейм завершался по паузе.
+// db и dtcStore открываются storage.OpenDTCStore и могут быть nil; Bus закрывает db в Close.
+func NewBus(port io.ReadWriteCloser, db *bolt.DB, dtcStore storage.DTCStore) (*Bus, error) {
+
+	return &Bus{
+		port:      port,
+		data:      NewJ1587Data(), // Инициализируем пустую структуру J1587Data
+		frames:    make(chan []byte),
+		stopChan:  make(chan struct{}),
+		dtcChan:   make(chan common.DTCCode, 10), // Буферизированный канал для DTC
+		db:        db,
+		dtcStore:  dtcStore,
+		dtcWindow: storage.NewDTCWindow(storage.DefaultDTCWindow),
+		tp:        newTPReassembler(),
+		localMID:  DefaultLocalMID,
+		tpReplies: make(chan tpReply, 4),
+		framing:   framingTiming,
+
+		diagReplies:      make(chan tpReply, 4),
+		adapterHandshake: DefaultAdapterHandshake,
+		activeDTCs:       storage.NewActiveDTCs(activeDTCTimeout),
+		clock:            clock.Real,
+	}, nil
+}
+
+// SetClock задает источник времени разбора вместо системного (например, clock.Mock)
+// для шины и ее данных. Вызывается до StartReading.
+func (p *Bus) SetClock(clk clock.Clock) {
+	p.clock = clk
+	p.data.SetClock(clk)
+}
+
+// SetDTCWindow задает окно подавления повторной публикации DTC (0 - отключено).
+func (p *Bus) SetDTCWindow(window time.Duration) {
+	p.dtcWindow = storage.NewDTCWindow(window)
+}
+
+// SetOCReporting включает повторную публикацию DTC, счетчик срабатываний которого
+// больше последнего опубликованного (см. storage.DTCStore.IsNewOccurrence).
+func (p *Bus) SetOCReporting(enabled bool) {
+	p.ocReporting = enabled
+}
+
+// SetFraming задает способ разделения потока байтов на фреймы (см. parseFraming).
+// Вызывается до StartReading.
+func (p *Bus) SetFraming(mode string) {
+	p.framing = mode
+}
+
+// SetAdapterHandshake задает, сколько после запуска отбрасывается текстовый вывод
+// адаптера (см. skipAdapterChatter); 0 - не отбрасывается. Вызывается до StartReading.
+func (p *Bus) SetAdapterHandshake(timeout time.Duration) {
+	p.adapterHandshake = timeout
+}
+
+// SetPIDObserver задает функцию, вызываемую для каждого блока PID/Data в принятых фреймах
+// (включая PID второй страницы и PID 254), например для самопроверки. data - данные
+// блока без байта длины; блок с недостающими данными не передается. Вызывается до StartReading.
+func (p *Bus) SetPIDObserver(observe func(mid, pid int, data []byte)) {
+	p.pidObserver = observe
+}
+
+// ValidFrames возвращает число принятых фреймов с верной контрольной суммой.
+func (p *Bus) ValidFrames() uint64 {
+	return p.validFrames.Load()
+}
+
+// Close закрывает ресурсы Bus, включая базу данных.
+func (p *Bus) Close() error {
+	log.Println("Закрытие ресурсов Bus...")
+	if p.db != nil {
+		log.Println("Закрытие БД DTC...")
+		if err := p.db.Close(); err != nil {
+			log.Printf("Ошибка при закрытии БД DTC: %v", err)
+			// Продолжаем закрывать другие ресурсы, если они есть
+		}
+	}
+	// Здесь можно добавить закрытие других ресурсов, если потребуется
+	return nil
+}
+
+// StartReading начинает чтение данных с порта
+func (p *Bus) StartReading() error {
+	if p.isRunning {
+		return fmt.Errorf("протокол J1587 уже запущен")
+	}
+	if p.port == nil {
+		return fmt.Errorf("порт не был инициализирован")
+	}
+
+	p.isRunning = true
+	go func() {
+		p.skipAdapterChatter(p.adapterHandshake)
+		if p.framing == framingChecksum {
+			p.readFramesByChecksum()
+		} else {
+			p.readFrames()
+		}
+	}()
+	go p.processFrames()
+
+	return nil
+}
+
+// StopReading останавливает чтение данных
+func (p *Bus) StopReading() error {
+	if !p.isRunning {
+		return nil
+	}
+
+	close(p.stopChan)
+	p.isRunning = false
+	return nil
+}
+
+// ActiveDTCs возвращает коды, активные по последним сообщениям PID 194 модулей.
+func (p *Bus) ActiveDTCs() []common.DTCCode {
+	return p.activeDTCs.List(p.clock.Now())
+}
+
+// FramesReceived возвращает число фреймов, принятых с шины с момента запуска.
+func (p *Bus) FramesReceived() uint64 {
+	return p.framesReceived.Load()
+}
+
+// GetData возвращает актуальные данные транспортного средства
+func (p *Bus) GetData() json.Marshaler {
+	return p.data.Copy() // Снимок с зафиксированной временной меткой
+}
+
+// ClearActiveDTCs сбрасывает счетчики кодов неисправностей модуля targetMID
+// (например, 128 - двигатель) запросом PID 195 и ждет подтверждения PID 196
+// не дольше clearDTCTimeout. Хранилище дедупликации DTC очищается после подтверждения,
+// чтобы коды, которые появятся снова, были опубликованы.
+func (p *Bus) ClearActiveDTCs(targetMID byte) error {
+	for len(p.diagReplies) > 0 {
+		<-p.diagReplies
+	}
+	if err := p.SendMessage(targetMID, clearDTCsRequest(targetMID)); err != nil {
+		return fmt.Errorf("не удалось отправить команду сброса DTC J1587: %w", err)
+	}
+	log.Printf("Команда сброса DTC J1587 отправлена на MID: %d", targetMID)
+
+	if err := p.awaitClearConfirmation(targetMID); err != nil {
+		return err
+	}
+	log.Printf("MID %d подтвердил сброс DTC", targetMID)
+
+	// Очищаем хранилище дедупликации DTC
+	if p.dtcStore != nil {
+		log.Println("Очистка хранилища дедупликации DTC...")
+		if err := p.dtcStore.ClearAll(); err != nil {
+			// Логируем ошибку, но не прерываем основной процесс,
+			// так как коды на блоке уже сброшены.
+			log.Printf("Ошибка очистки хранилища DTC: %v", err)
+		} else {
+			log.Println("Хранилище дедупликации DTC успешно очищено.")
+		}
+	}
+	return nil
+}
+
+// awaitClearConfirmation ждет ответа PID 196 модуля mid о сбросе счетчиков всех кодов.
+func (p *Bus) awaitClearConfirmation(mid byte) error {
+	timeout := time.After(clearDTCTimeout)
+	for {
+		select {
+		case reply := <-p.diagReplies:
+			if reply.mid == int(mid) && reply.data[2]&diagCharRequestMask == diagCharClearAll {
+				return nil
+			}
+		case <-timeout:
+			return fmt.Errorf("MID %d не подтвердил сброс DTC за %v", mid, clearDTCTimeout)
+		case <-p.stopChan:
+			return fmt.Errorf("чтение шины остановлено")
+		}
+	}
+}
+
+// StartProcessingDTCs запускает обработку и дедупликацию DTC.
+func (p *Bus) StartProcessingDTCs(publisher sink.Publisher) {
+	log.Println("Запуск обработки DTC для J1587 с использованием хранилища...")
+	for {
+		select {
+		case <-p.stopChan:
+			log.Println("Остановка обработки DTC (канал stopChan закрыт).")
+			return
+		case dtc, ok := <-p.dtcChan:
+			if !ok {
+				log.Println("Канал DTC закрыт, завершение обработки DTC.")
+				return
+			}
+			log.Printf("Получен DTC J1587: %+v (SPN: %d, FMI: %d)", dtc, dtc.SPN, dtc.FMI)
+
+			if !p.dtcWindow.Allow(dtcStorageID(dtc), uint8(dtc.FMI), p.clock.Now()) {
+				logging.Debugf("DTC J1587 (SPN: %d, FMI: %d) уже опубликован в пределах окна, пропущен.", dtc.SPN, dtc.FMI)
+				continue
+			}
+
+			// Без хранилища (-dtc-store none) повтор DTC подавляется только окном dtcWindow
+			isNew := true
+			if p.dtcStore != nil {
+				var err error
+				if p.ocReporting {
+					isNew, err = p.dtcStore.IsNewOccurrence(dtcStorageID(dtc), uint8(dtc.FMI), uint8(dtc.OC))
+				} else {
+					isNew, err = p.dtcStore.IsNew(dtcStorageID(dtc), uint8(dtc.FMI))
+				}
+				if err != nil {
+					log.Printf("Ошибка проверки DTC (SPN: %d, FMI: %d) в хранилище: %v", dtc.SPN, dtc.FMI, err)
+					continue
+				}
+			}
+
+			if isNew {
+				log.Printf("Новый DTC J1587 (SPN: %d, FMI: %d), отправка в MQTT.", dtc.SPN, dtc.FMI)
+				dtc.VehicleID = p.data.VehicleID()
+				publisher.PublishDTC(dtc)
+			} else {
+				log.Printf("Дубликат DTC J1587 (SPN: %d, FMI: %d) пропущен.", dtc.SPN, dtc.FMI)
+			}
+		}
+	}
+}
+
+// dtcStorageID возвращает идентификатор кода для хранилища дедупликации.
+// SID хранятся со смещением, чтобы не пересекаться с PID с тем же номером.
+func dtcStorageID(dtc common.DTCCode) uint32 {
+	if dtc.CodeType == common.DTCCodeTypeSID {
+		return uint32(dtc.SPN) | 1<<16
+	}
+	return uint32(dtc.SPN)
+}
+
+// RestoreVIN загружает VIN, сохраненный при предыдущем запуске, чтобы он публиковался
+// сразу, не дожидаясь передачи с шины.
+func (p *Bus) RestoreVIN() {
+	if p.db == nil {
+		return
+	}
+	vin, err := storage.LoadVIN(p.db)
+	if err != nil {
+		log.Printf("Ошибка чтения сохраненного VIN: %v", err)
+		return
+	}
+	if vin != "" {
+		p.data.Set("vin", vin)
+		log.Printf("VIN из предыдущего запуска: %s", vin)
+	}
+}
+
+// setVIN сохраняет VIN, полученный с шины, и кеширует его в БД при изменении.
+func (p *Bus) setVIN(vin string) {
+	if current, _ := p.data.GetString("vin"); current == vin {
+		return
+	}
+	p.data.Set("vin", vin)
+	log.Printf("J1587: получен VIN %s", vin)
+	if p.db != nil {
+		if err := storage.SaveVIN(p.db, vin); err != nil {
+			log.Printf("Ошибка сохранения VIN: %v", err)
+		}
+	}
+}
+
+// readFrames читает фреймы из последовательного порта
+func (p *Bus) readFrames() {
+	buf := make([]byte, 128)
+	var frame []byte
+	last := time.Now()
+
+	for {
+		select {
+		case <-p.stopChan:
+			return
+		default:
+			n, err := p.read(buf)
+			now := time.Now()
+
+			if err != nil && err != io.EOF {
+				log.Printf("Ошибка чтения порта: %v", err)
+			}
+
+			if n == 0 {
+				// таймаут чтения
+				if len(frame) > 0 && now.Sub(last) >= interFrameGap {
+					p.frames <- frame
+					frame = nil
+				}
+				continue
+			}
+
+			for i := 0; i < n; i++ {
+				if now.Sub(last) >= interFrameGap && len(frame) > 0 {
+					p.frames <- frame
+					frame = nil
+				}
+				frame = append(frame, buf[i])
+				last = now
+			}
+		}
+	}
+}
+
+// readFramesByChecksum читает фреймы из последовательного порта, определяя их границы
+// по контрольной сумме (см. checksumFramer). Паузы между байтами не учитываются.
+func (p *Bus) readFramesByChecksum() {
+	buf := make([]byte, 128)
+	var framer checksumFramer
+
+	for {
+		select {
+		case <-p.stopChan:
+			return
+		default:
+			n, err := p.read(buf)
+			if err != nil && err != io.EOF {
+				log.Printf("Ошибка чтения порта: %v", err)
+			}
+			for _, b := range buf[:n] {
+				if frame := framer.feed(b); frame != nil {
+					p.frames <- frame
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package j1587
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakePort - порт, выдающий заготовленные порции байтов вместо последовательного порта.
+// nil в списке порций - пауза между фреймами: Read ждет дольше interFrameGap
+// и возвращает 0, как по таймауту порта. Записанные байты сохраняются в written.
+type fakePort struct {
+	mu      sync.Mutex
+	chunks  [][]byte
+	written []byte
+}
+
+func newFakePort(chunks ...[]byte) *fakePort {
+	return &fakePort{chunks: chunks}
+}
+
+func (f *fakePort) Read(b []byte) (int, error) {
+	f.mu.Lock()
+	if len(f.chunks) == 0 {
+		f.mu.Unlock()
+		time.Sleep(time.Millisecond)
+		return 0, nil
+	}
+	chunk := f.chunks[0]
+	if chunk == nil {
+		f.chunks = f.chunks[1:]
+		f.mu.Unlock()
+		time.Sleep(2 * interFrameGap)
+		return 0, nil
+	}
+	n := copy(b, chunk)
+	if n < len(chunk) {
+		f.chunks[0] = chunk[n:]
+	} else {
+		f.chunks = f.chunks[1:]
+	}
+	f.mu.Unlock()
+	return n, nil
+}
+
+func (f *fakePort) Write(b []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.written = append(f.written, b...)
+	return len(b), nil
+}
+
+func (f *fakePort) Close() error { return nil }
+
+// Written возвращает копию байтов, записанных в порт.
+func (f *fakePort) Written() []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]byte(nil), f.written...)
+}
+
+// Фреймы, принятые с шины: MID 128 передает скорость 88 и обороты 1500 (PID 84 и 190),
+// затем давление масла с неверной контрольной суммой и температуру охлаждающей жидкости 50 °C.
+var (
+	frameSpeedRPM     = []byte{0x80, 0x54, 0x58, 0xBE, 0x70, 0x17, 0x8F}
+	frameBadChecksum  = []byte{0x80, 0x64, 0x19, 0x04}
+	frameCoolantTemp  = []byte{0x80, 0x6E, 0x5A, 0xB8}
+	adapterLineNoise  = []byte{0x13, 0x37}
+	frameSpeedRPMHead = frameSpeedRPM[:3]
+	frameSpeedRPMTail = frameSpeedRPM[3:]
+)
+
+// collectFrames читает из шины n выделенных фреймов или завершает тест по таймауту.
+func collectFrames(t *testing.T, bus *Bus, n int) [][]byte {
+	t.Helper()
+	var frames [][]byte
+	timeout := time.After(2 * time.Second)
+	for len(frames) < n {
+		select {
+		case frame := <-bus.frames:
+			frames = append(frames, frame)
+		case <-timeout:
+			t.Fatalf("получено фреймов %d из %d: % X", len(frames), n, frames)
+		}
+	}
+	return frames
+}
+
+func checkFrames(t *testing.T, got [][]byte, want ...[]byte) {
+	t.Helper()
+	for i := range want {
+		if !bytes.Equal(got[i], want[i]) {
+			t.Errorf("фрейм %d: % X, ожидается % X", i, got[i], want[i])
+		}
+	}
+}
+
+func TestReadFramesByTiming(t *testing.T) {
+	// Первый фрейм приходит двумя порциями без паузы и должен быть собран целиком
+	port := newFakePort(
+		frameSpeedRPMHead, frameSpeedRPMTail, nil,
+		frameBadChecksum, nil,
+		frameCoolantTemp,
+	)
+	bus, _ := NewBus(port, nil, nil)
+	go bus.readFrames()
+	defer close(bus.stopChan)
+
+	got := collectFrames(t, bus, 3)
+	checkFrames(t, got, frameSpeedRPM, frameBadChecksum, frameCoolantTemp)
+}
+
+func TestReadFramesByChecksum(t *testing.T) {
+	// Данные идут сплошным потоком: шум перед фреймом и фрейм с неверной
+	// контрольной суммой отбрасываются, границы находятся по сумме байтов
+	var stream []byte
+	for _, part := range [][]byte{adapterLineNoise, frameSpeedRPM, frameBadChecksum, frameCoolantTemp} {
+		stream = append(stream, part...)
+	}
+	port := newFakePort(stream[:5], stream[5:11], stream[11:])
+	bus, _ := NewBus(port, nil, nil)
+	go bus.readFramesByChecksum()
+	defer close(bus.stopChan)
+
+	got := collectFrames(t, bus, 2)
+	checkFrames(t, got, frameSpeedRPM, frameCoolantTemp)
+}
+
+func TestStartReadingParsesFrames(t *testing.T) {
+	port := newFakePort(
+		frameSpeedRPMHead, frameSpeedRPMTail, nil,
+		frameBadChecksum, nil,
+		frameCoolantTemp, nil,
+	)
+	bus, _ := NewBus(port, nil, nil)
+	bus.SetAdapterHandshake(0)
+	if err := bus.StartReading(); err != nil {
+		t.Fatalf("StartReading: %v", err)
+	}
+	defer bus.StopReading()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for bus.FramesReceived() < 3 || bus.ValidFrames() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("принято фреймов %d, с верной суммой %d", bus.FramesReceived(), bus.ValidFrames())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// Фрейм с неверной контрольной суммой принят, но не разобран
+	time.Sleep(10 * time.Millisecond)
+	if n := bus.ValidFrames(); n != 2 {
+		t.Errorf("фреймов с верной суммой %d, ожидается 2", n)
+	}
+	if _, ok := bus.data.GetFloat64("oil_pressure"); ok {
+		t.Error("значение из фрейма с неверной контрольной суммой сохранено")
+	}
+	for key, want := range map[string]float64{"speed": 88, "engine_rpm": 1500, "coolant_temp": 50} {
+		if got, ok := bus.data.GetFloat64(key); !ok || got != want {
+			t.Errorf("%s = %v (%v), ожидается %v", key, got, ok, want)
+		}
+	}
+}
+
+func TestStartReadingSkipsAdapterText(t *testing.T) {
+	port := newFakePort([]byte("ELM327 v1.5\r\r>"), frameCoolantTemp, nil)
+	bus, _ := NewBus(port, nil, nil)
+	bus.SetAdapterHandshake(time.Second)
+	if err := bus.StartReading(); err != nil {
+		t.Fatalf("StartReading: %v", err)
+	}
+	defer bus.StopReading()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for bus.ValidFrames() < 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("фрейм после текста адаптера не разобран (принято %d)", bus.FramesReceived())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if n := bus.FramesReceived(); n != 1 {
+		t.Errorf("принято фреймов %d, ожидается 1: текст адаптера разобран как фрейм", n)
+	}
+}
@@ -0,0 +1,495 @@
+package j1587
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/serebryakov7/j1708-stats/common"
+	"github.com/serebryakov7/j1708-stats/pkg/clock"
+	"github.com/serebryakov7/j1708-stats/pkg/clone"
+	"github.com/serebryakov7/j1708-stats/pkg/filter"
+)
+
+// ProtectedData инкапсулирует карту данных J1587 и мьютекс для безопасного доступа.
+type ProtectedData struct {
+	mutex sync.RWMutex
+	Data  map[string]any // Хранилище для разобранных данных J1587: имя метрики -> значение
+	// filters содержит фильтры сглаживания для метрик, для которых оно включено.
+	filters map[string]*filter.MovingAverage
+	// hysteresis содержит фильтры гистерезиса для метрик, для которых он включен.
+	hysteresis map[string]*filter.Hysteresis
+	// changed - метрики, изменение которых подтверждено с последнего вызова TakeChanged.
+	changed map[string]struct{}
+	// allowedKeys - метрики, включаемые в снимок (команда set_metrics); nil - все.
+	allowedKeys map[string]struct{}
+	// knownKeys - реестр допустимых имен метрик; nil отключает проверку.
+	knownKeys map[string]struct{}
+	// strictKeys - отклонять значения с неизвестными именами вместо предупреждения.
+	strictKeys bool
+	// warnedKeys - неизвестные имена, о которых уже выведено предупреждение.
+	warnedKeys map[string]struct{}
+	// naming - стиль имен полей в публикуемом JSON, aliases - псевдонимы полей.
+	naming  common.JSONNaming
+	aliases common.FieldAliases
+	// clock - источник времени для гистерезиса и временной метки снимка.
+	clock clock.Clock
+	// fallbackVehicleID - идентификатор автомобиля (-vehicle-id), используемый, пока VIN не получен.
+	fallbackVehicleID string
+	// nullKeys - метрики, выводимые в снимке как null, пока значения нет (см. SetNullKeys).
+	nullKeys []string
+}
+
+// metricKeys перечисляет метрики, которые формирует парсер, в порядке вывода.
+var metricKeys = []string{
+	"vin",
+	"vehicle_id",
+	"speed",
+	"engine_rpm",
+	"coolant_temp",
+	"oil_pressure",
+	"engine_load",
+	"fuel_level",
+	"battery_voltage",
+	"ambient_temp",
+	"total_distance",
+	"trip",
+	"last_trip",
+	"dtc_storm",
+}
+
+// prunePriority - метрики, удаляемые первыми при превышении -max-payload,
+// от наименее важной к более важной.
+var prunePriority = []string{
+	"trip",
+	"last_trip",
+	"ambient_temp",
+	"battery_voltage",
+}
+
+// outputColumns возвращает список столбцов для табличного вывода:
+// все метрики и, для сглаживаемых, их сырые значения.
+func outputColumns(smoothed map[string]int) []string {
+	columns := make([]string, 0, len(metricKeys)+len(smoothed))
+	for _, key := range metricKeys {
+		columns = append(columns, key)
+		if _, ok := smoothed[key]; ok {
+			columns = append(columns, key+rawSuffix)
+		}
+	}
+	return columns
+}
+
+// identityKeys включаются в снимок независимо от списка set_metrics:
+// по ним сервер определяет автомобиль.
+var identityKeys = []string{"vin", "vehicle_id"}
+
+// rawSuffix добавляется к имени метрики для хранения несглаженного значения.
+const rawSuffix = "_raw"
+
+// NewProtectedData создает новый экземпляр ProtectedData.
+func NewProtectedData() *ProtectedData {
+	return &ProtectedData{
+		Data:    make(map[string]any),
+		changed: make(map[string]struct{}),
+		clock:   clock.Real,
+	}
+}
+
+// SetClock задает источник времени вместо системного (например, clock.Mock).
+func (pd *ProtectedData) SetClock(clk clock.Clock) {
+	pd.mutex.Lock()
+	defer pd.mutex.Unlock()
+	pd.clock = clk
+}
+
+// EnableSmoothing включает сглаживание скользящим средним для указанных метрик.
+// windows: имя метрики -> размер окна. Несглаженное значение сохраняется
+// под ключом с суффиксом "_raw" (например, fuel_level и fuel_level_raw).
+func (pd *ProtectedData) EnableSmoothing(windows map[string]int) {
+	pd.mutex.Lock()
+	defer pd.mutex.Unlock()
+	pd.filters = make(map[string]*filter.MovingAverage, len(windows))
+	for key, size := range windows {
+		pd.filters[key] = filter.NewMovingAverage(size)
+	}
+}
+
+// EnableHysteresis включает гистерезис для указанных метрик: изменение значения
+// считается подтвержденным (см. TakeChanged), только если оно превышает порог
+// и держится не меньше заданного времени. Сами значения не меняются.
+func (pd *ProtectedData) EnableHysteresis(configs map[string]filter.HysteresisConfig) {
+	pd.mutex.Lock()
+	defer pd.mutex.Unlock()
+	pd.hysteresis = make(map[string]*filter.Hysteresis, len(configs))
+	for key, config := range configs {
+		pd.hysteresis[key] = filter.NewHysteresis(config)
+	}
+}
+
+// TakeChanged возвращает отсортированный список метрик, изменение которых подтверждено
+// с прошлого вызова, и очищает его. Для метрик без гистерезиса изменением считается
+// любое новое значение, отличное от предыдущего.
+func (pd *ProtectedData) TakeChanged() []string {
+	pd.mutex.Lock()
+	defer pd.mutex.Unlock()
+	keys := make([]string, 0, len(pd.changed))
+	for key := range pd.changed {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	clear(pd.changed)
+	return keys
+}
+
+// noteChange отмечает метрику измененной, если новое значение отличается от prev
+// с учетом гистерезиса. Вызывается под мьютексом.
+func (pd *ProtectedData) noteChange(key string, prev any, hadPrev bool, value any) {
+	if h, ok := pd.hysteresis[key]; ok {
+		if v, isFloat := value.(float64); isFloat {
+			if h.Update(v, pd.clock.Now()) {
+				pd.changed[key] = struct{}{}
+			}
+			return
+		}
+		// Значение недоступно (nil) или нечисловое - следующее число считается изменением
+		h.Reset()
+	}
+	if !hadPrev || !reflect.DeepEqual(prev, value) {
+		pd.changed[key] = struct{}{}
+	}
+}
+
+// SetAllowedKeys ограничивает снимок данных метриками keys; vin и vehicle_id
+// включаются всегда, несглаженные значения (_raw) - вместе со своими метриками.
+// Пустой список снимает ограничение. Метрики вне реестра (см. SetKnownKeys) отклоняются.
+func (pd *ProtectedData) SetAllowedKeys(keys []string) error {
+	pd.mutex.Lock()
+	defer pd.mutex.Unlock()
+	if len(keys) == 0 {
+		pd.allowedKeys = nil
+		return nil
+	}
+	allowed := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		if pd.knownKeys != nil {
+			if _, ok := pd.knownKeys[key]; !ok {
+				return fmt.Errorf("неизвестная метрика %q", key)
+			}
+		}
+		allowed[key] = struct{}{}
+	}
+	pd.allowedKeys = allowed
+	return nil
+}
+
+// SetNullKeys задает метрики, которые всегда включаются в снимок: если значение
+// не получено или недоступно, метрика выводится со значением null, а не опускается.
+// Так схема снимка не зависит от того, что передал автомобиль.
+func (pd *ProtectedData) SetNullKeys(keys []string) error {
+	pd.mutex.Lock()
+	defer pd.mutex.Unlock()
+	for _, key := range keys {
+		if pd.knownKeys != nil {
+			if _, ok := pd.knownKeys[key]; !ok {
+				return fmt.Errorf("неизвестная метрика %q", key)
+			}
+		}
+	}
+	pd.nullKeys = keys
+	return nil
+}
+
+// AllowedKeys возвращает отсортированный список метрик, включаемых в снимок,
+// или nil, если ограничения нет.
+func (pd *ProtectedData) AllowedKeys() []string {
+	pd.mutex.RLock()
+	defer pd.mutex.RUnlock()
+	if pd.allowedKeys == nil {
+		return nil
+	}
+	keys := make([]string, 0, len(pd.allowedKeys))
+	for key := range pd.allowedKeys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// isAllowed сообщает, включается ли метрика key в снимок. Вызывается под мьютексом.
+func (pd *ProtectedData) isAllowed(key string) bool {
+	if pd.allowedKeys == nil || slices.Contains(identityKeys, key) {
+		return true
+	}
+	_, ok := pd.allowedKeys[strings.TrimSuffix(key, rawSuffix)]
+	return ok
+}
+
+// SetKnownKeys включает проверку имен метрик по реестру keys.
+// В строгом режиме значения с неизвестными именами отклоняются,
+// иначе сохраняются с однократным предупреждением в логе.
+func (pd *ProtectedData) SetKnownKeys(keys []string, strict bool) {
+	pd.mutex.Lock()
+	defer pd.mutex.Unlock()
+	pd.knownKeys = make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		pd.knownKeys[key] = struct{}{}
+	}
+	pd.strictKeys = strict
+	pd.warnedKeys = make(map[string]struct{})
+}
+
+// SetJSONNaming задает стиль имен полей в публикуемом JSON (см. common.JSONNaming).
+func (pd *ProtectedData) SetJSONNaming(naming common.JSONNaming) {
+	pd.mutex.Lock()
+	defer pd.mutex.Unlock()
+	pd.naming = naming
+}
+
+// SetFieldAliases задает псевдонимы полей в публикуемом JSON (см. common.FieldAliases).
+func (pd *ProtectedData) SetFieldAliases(aliases common.FieldAliases) {
+	pd.mutex.Lock()
+	defer pd.mutex.Unlock()
+	pd.aliases = aliases
+}
+
+// SetFallbackVehicleID задает идентификатор автомобиля для блоков, не передающих VIN.
+// Полученный с шины VIN имеет приоритет.
+func (pd *ProtectedData) SetFallbackVehicleID(id string) {
+	pd.mutex.Lock()
+	defer pd.mutex.Unlock()
+	pd.fallbackVehicleID = id
+}
+
+// VehicleID возвращает VIN, а если он не получен - идентификатор из -vehicle-id.
+func (pd *ProtectedData) VehicleID() string {
+	pd.mutex.RLock()
+	defer pd.mutex.RUnlock()
+	return pd.vehicleID()
+}
+
+// vehicleID - реализация VehicleID. Вызывается под мьютексом.
+func (pd *ProtectedData) vehicleID() string {
+	if vin, ok := pd.Data["vin"].(string); ok && vin != "" {
+		return vin
+	}
+	return pd.fallbackVehicleID
+}
+
+// checkKey проверяет имя метрики по реестру. Вызывается под мьютексом.
+func (pd *ProtectedData) checkKey(key string) error {
+	if pd.knownKeys == nil {
+		return nil
+	}
+	if _, ok := pd.knownKeys[key]; ok {
+		return nil
+	}
+	if _, warned := pd.warnedKeys[key]; !warned {
+		pd.warnedKeys[key] = struct{}{}
+		log.Printf("ProtectedData: неизвестная метрика %q (строгий режим: %v)", key, pd.strictKeys)
+	}
+	if pd.strictKeys {
+		return fmt.Errorf("неизвестная метрика %q отклонена", key)
+	}
+	return nil
+}
+
+// Set устанавливает значение в карте данных под защитой мьютекса.
+// Ошибки проверки имени уже отражены в логе, поэтому здесь не возвращаются.
+func (pd *ProtectedData) Set(key string, value any) {
+	_ = pd.SetChecked(key, value)
+}
+
+// SetChecked устанавливает значение, предварительно проверив имя метрики по реестру.
+// Для метрик со включенным сглаживанием сохраняет сглаженное и сырое значения.
+func (pd *ProtectedData) SetChecked(key string, value any) error {
+	pd.mutex.Lock()
+	defer pd.mutex.Unlock()
+
+	if err := pd.checkKey(key); err != nil {
+		return err
+	}
+
+	prev, hadPrev := pd.Data[key]
+	defer func() { pd.noteChange(key, prev, hadPrev, pd.Data[key]) }()
+
+	f, ok := pd.filters[key]
+	if !ok {
+		pd.Data[key] = value
+		return nil
+	}
+
+	pd.Data[key+rawSuffix] = value
+	raw, isFloat := value.(float64)
+	if !isFloat {
+		// Значение недоступно (nil) или нечисловое — начинаем сглаживание заново
+		f.Reset()
+		pd.Data[key] = value
+		return nil
+	}
+	pd.Data[key] = f.Add(raw)
+	return nil
+}
+
+// Get извлекает значение из карты данных под защитой мьютекса.
+func (pd *ProtectedData) Get(key string) (any, bool) {
+	pd.mutex.RLock()
+	defer pd.mutex.RUnlock()
+	val, ok := pd.Data[key]
+	return val, ok
+}
+
+// GetFloat64 возвращает числовое значение метрики.
+// ok = false, если метрики нет, она недоступна (nil) или не является числом.
+func (pd *ProtectedData) GetFloat64(key string) (float64, bool) {
+	val, _ := pd.Get(key)
+	switch v := val.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	default:
+		return 0, false
+	}
+}
+
+// GetInt возвращает целочисленное значение метрики.
+// Значения float64 принимаются только без дробной части.
+func (pd *ProtectedData) GetInt(key string) (int, bool) {
+	val, _ := pd.Get(key)
+	switch v := val.(type) {
+	case int:
+		return v, true
+	case int64:
+		return int(v), true
+	case uint8:
+		return int(v), true
+	case uint16:
+		return int(v), true
+	case uint32:
+		return int(v), true
+	case float64:
+		if v != math.Trunc(v) {
+			return 0, false
+		}
+		return int(v), true
+	default:
+		return 0, false
+	}
+}
+
+// GetString возвращает строковое значение метрики.
+func (pd *ProtectedData) GetString(key string) (string, bool) {
+	val, _ := pd.Get(key)
+	v, ok := val.(string)
+	return v, ok
+}
+
+// GetBool возвращает логическое значение метрики.
+func (pd *ProtectedData) GetBool(key string) (bool, bool) {
+	val, _ := pd.Get(key)
+	v, ok := val.(bool)
+	return v, ok
+}
+
+// WaitForKeys ждет, пока все метрики keys получат значения (не nil), но не дольше timeout.
+// Возвращает метрики, которые так и не получили значения.
+func (pd *ProtectedData) WaitForKeys(keys []string, timeout time.Duration) []string {
+	deadline := pd.now().Add(timeout)
+	for {
+		var missing []string
+		for _, key := range keys {
+			if val, ok := pd.Get(key); !ok || val == nil {
+				missing = append(missing, key)
+			}
+		}
+		if len(missing) == 0 || !pd.now().Before(deadline) {
+			return missing
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// now возвращает текущее время источника pd.clock.
+func (pd *ProtectedData) now() time.Time {
+	pd.mutex.RLock()
+	defer pd.mutex.RUnlock()
+	return pd.clock.Now()
+}
+
+// MarshalJSON реализует интерфейс json.Marshaler для ProtectedData.
+// Сериализует снимок текущих данных с временной меткой момента вызова.
+func (pd *ProtectedData) MarshalJSON() ([]byte, error) {
+	return pd.Copy().MarshalJSON()
+}
+
+// Copy создает снимок данных для безопасной передачи.
+// В снимок попадают только метрики, разрешенные SetAllowedKeys.
+// Срезы, карты и указатели копируются глубоко, а временная метка фиксируется
+// в момент создания снимка, поэтому повторная сериализация дает тот же результат.
+func (pd *ProtectedData) Copy() json.Marshaler {
+	pd.mutex.RLock()
+	defer pd.mutex.RUnlock()
+
+	copiedData := make(map[string]any, len(pd.Data))
+	for key, value := range pd.Data {
+		if !pd.isAllowed(key) {
+			continue
+		}
+		copiedData[key] = clone.Value(value)
+	}
+	if id := pd.vehicleID(); id != "" {
+		copiedData["vehicle_id"] = id
+	}
+	var nullKeys []string
+	for _, key := range pd.nullKeys {
+		if pd.isAllowed(key) {
+			nullKeys = append(nullKeys, key)
+		}
+	}
+	return &copiedDataMarshaler{data: copiedData, timestamp: pd.clock.Now().UTC(), naming: pd.naming, aliases: pd.aliases, nullKeys: nullKeys}
+}
+
+// copiedDataMarshaler вспомогательный тип для реализации json.Marshaler на основе скопированной карты.
+type copiedDataMarshaler struct {
+	data      map[string]any
+	timestamp time.Time // Время создания снимка
+	naming    common.JSONNaming
+	aliases   common.FieldAliases
+	nullKeys  []string
+}
+
+// MarshalJSON для copiedDataMarshaler сериализует снимок по схеме common.J1587Payload
+// с временной меткой создания снимка и выбранным стилем имен полей.
+func (m *copiedDataMarshaler) MarshalJSON() ([]byte, error) {
+	payload := common.NewJ1587Payload(m.data, m.timestamp)
+	payload.Naming = m.naming
+	payload.NullKeys = m.nullKeys
+	payload.Aliases = m.aliases
+	return json.Marshal(payload)
+}
+
+// J1587Data теперь псевдоним для ProtectedData.
+type J1587Data = ProtectedData
+
+// NewJ1587Data теперь вызывает NewProtectedData.
+func NewJ1587Data() *J1587Data {
+	return NewProtectedData()
+}
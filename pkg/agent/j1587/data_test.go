@@ -0,0 +1,120 @@
+package j1587
+
+import (
+	"bytes"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/serebryakov7/j1708-stats/common"
+	"github.com/serebryakov7/j1708-stats/pkg/filter"
+)
+
+// Тесты ниже имеют смысл с детектором гонок: go test -race.
+
+func TestCopyIndependentOfSource(t *testing.T) {
+	pd := NewProtectedData()
+	raw := []byte{0x01, 0x02, 0x03, 0x04}
+	levels := map[string]float64{"left": 40, "right": 38}
+	dtcs := []common.DTCCode{{MID: 128, SPN: 110, FMI: 3}}
+	pd.Set("raw_bytes", raw)
+	pd.Set("tank_levels", levels)
+	pd.Set("dtcs", dtcs)
+
+	snapshot := pd.Copy()
+	want, err := snapshot.MarshalJSON()
+	if err != nil {
+		t.Fatalf("MarshalJSON: %v", err)
+	}
+
+	// Источник меняется на месте, как это мог бы делать разборщик после Set
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			raw[0] = byte(i)
+			levels["left"] = float64(i)
+			dtcs[0].FMI = i % 32
+		}
+	}()
+	for i := 0; i < 1000; i++ {
+		got, err := snapshot.MarshalJSON()
+		if err != nil {
+			t.Fatalf("MarshalJSON: %v", err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("снимок изменился вместе с источником:\n%s\nожидается\n%s", got, want)
+		}
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func TestMarshalJSONConcurrentSet(t *testing.T) {
+	pd := NewProtectedData()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			pd.Set("raw_bytes", []byte{byte(i), byte(i >> 8)})
+			pd.Set("tank_levels", map[string]float64{"left": float64(i)})
+			pd.Set("speed", float64(i%120))
+		}
+	}()
+	for i := 0; i < 1000; i++ {
+		if _, err := pd.MarshalJSON(); err != nil {
+			t.Fatalf("MarshalJSON: %v", err)
+		}
+	}
+	wg.Wait()
+}
+
+func TestCopyNestedValues(t *testing.T) {
+	pd := NewProtectedData()
+	nested := map[string]any{"codes": []int{1, 2}}
+	pd.Set("nested", nested)
+	snapshot := pd.Copy()
+	nested["codes"].([]int)[0] = 99
+	got, err := snapshot.MarshalJSON()
+	if err != nil {
+		t.Fatalf("MarshalJSON: %v", err)
+	}
+	if !bytes.Contains(got, []byte(`"codes":[1,2]`)) {
+		t.Errorf("вложенный срез скопирован не глубоко: %s", got)
+	}
+}
+
+func TestTakeChangedHysteresis(t *testing.T) {
+	pd := NewProtectedData()
+	pd.EnableHysteresis(map[string]filter.HysteresisConfig{"coolant_temp": {Threshold: 1}})
+	steps := []struct {
+		coolant any
+		speed   float64
+		want    []string
+	}{
+		{90.0, 50, []string{"coolant_temp", "speed"}},
+		// coolant_temp в пределах порога, speed не изменилась
+		{90.5, 50, []string{}},
+		// Без гистерезиса изменением считается любое новое значение
+		{89.5, 50.5, []string{"speed"}},
+		{91.5, 50.5, []string{"coolant_temp"}},
+		// Недоступное значение и следующее за ним число - изменения
+		{nil, 50.5, []string{"coolant_temp"}},
+		{91.5, 50.5, []string{"coolant_temp"}},
+	}
+	for i, s := range steps {
+		pd.Set("coolant_temp", s.coolant)
+		pd.Set("speed", s.speed)
+		if got := pd.TakeChanged(); !reflect.DeepEqual(got, s.want) {
+			t.Errorf("шаг %d: TakeChanged = %v, ожидается %v", i, got, s.want)
+		}
+	}
+}
@@ -0,0 +1,343 @@
+package j1587
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/serebryakov7/j1708-stats/common"
+	"github.com/serebryakov7/j1708-stats/pkg/logging"
+)
+
+// calculateJ1587Checksum вычисляет контрольную сумму для J1587 фрейма
+func calculateJ1587Checksum(frame []byte) byte {
+	sum := 0
+	for _, b := range frame {
+		sum += int(b)
+	}
+	return byte(256 - (sum % 256))
+}
+
+// validateJ1587Checksum проверяет контрольную сумму J1587 фрейма
+func validateJ1587Checksum(frame []byte) bool {
+	if len(frame) < 3 { // MID + минимум 1 PID + checksum
+		return false
+	}
+
+	sum := 0
+	for _, b := range frame {
+		sum += int(b)
+	}
+	return (sum % 256) == 0
+}
+
+// Служебные PID J1587, не несущие собственных параметров
+const (
+	// pidDataLinkEscape (254) - служебный PID для собственных (proprietary) сообщений производителя.
+	// Данные передаются как у PID переменной длины и не разбираются.
+	pidDataLinkEscape = 254
+	// pidPageTwoEscape (255) - расширение: следующий байт - номер PID второй страницы,
+	// полный номер параметра равен 256 + этот байт.
+	pidPageTwoEscape = 255
+	// pidPageTwoOffset - смещение номеров PID второй страницы (256-511).
+	pidPageTwoOffset = 256
+)
+
+// getPIDDataLength возвращает длину данных для заданного PID согласно SAE J1587.
+// Диапазоны длин одинаковы для обеих страниц: значение определяется младшим байтом номера
+// (PID 256-383 - 1 байт, 384-447 - 2 байта, 448-509 - переменная длина).
+// Для PID переменной длины длина читается из data[offset].
+func getPIDDataLength(pid int, data []byte, offset int) (int, error) {
+	switch low := pid % pidPageTwoOffset; {
+	case low <= 127:
+		// PID 0-127: 1 байт данных
+		return 1, nil
+	case low >= 128 && low <= 191:
+		// PID 128-191: 2 байта данных
+		return 2, nil
+	case low >= 192 && low <= 253, pid == pidDataLinkEscape:
+		// PID 192-253: переменная длина, следующий байт указывает количество байт данных
+		if offset >= len(data) {
+			return 0, fmt.Errorf("недостаточно данных для чтения длины переменного PID %d", pid)
+		}
+		dataLength := int(data[offset])
+		return dataLength, nil
+	default:
+		return 0, fmt.Errorf("недопустимый PID: %d", pid)
+	}
+}
+
+// isVariableLengthPID сообщает, предшествует ли данным PID байт длины.
+func isVariableLengthPID(pid int) bool {
+	low := pid % pidPageTwoOffset
+	return (low >= 192 && low <= 253) || pid == pidDataLinkEscape
+}
+
+// parseFrame разбирает фрейм J1587 с поддержкой нескольких PID/Data блоков.
+// PID второй страницы передаются через escape-байт 255, например
+// фрейм 80 FF 05 64 ... содержит PID 261 (256 + 5) со значением 0x64.
+func (p *Bus) parseFrame(frame []byte) {
+	if len(frame) < 3 { // MID + минимум 1 PID + 1 байт данных или checksum
+		log.Printf("J1587: фрейм слишком короткий: %d байт", len(frame))
+		return
+	}
+
+	// Проверяем контрольную сумму
+	if !validateJ1587Checksum(frame) {
+		log.Printf("J1587: неверная контрольная сумма для фрейма: % X", frame)
+		return
+	}
+
+	p.validFrames.Add(1)
+
+	mid := int(frame[0])
+	if mid == int(p.localMID) {
+		// Собственные фреймы агента, принятые с шины; ответы модулей приходят с их MID
+		logging.Debugf("J1587: собственный фрейм пропущен: % X", frame)
+		return
+	}
+	data := frame[1 : len(frame)-1] // Исключаем последний байт (checksum)
+
+	logging.Debugf("J1587: парсинг фрейма MID=%d, данные=% X", mid, data)
+
+	p.parsePIDBlocks(mid, data)
+}
+
+// parsePIDBlocks разбирает последовательность блоков PID/Data от модуля mid:
+// данные одного фрейма или сообщение, собранное транспортным протоколом.
+func (p *Bus) parsePIDBlocks(mid int, data []byte) {
+	offset := 0
+	for offset < len(data) {
+		pid := int(data[offset])
+		offset++
+
+		// Escape второй страницы: номер параметра находится в следующем байте
+		if pid == pidPageTwoEscape {
+			if offset >= len(data) {
+				log.Printf("J1587: MID=%d: после escape-байта 255 нет номера PID второй страницы", mid)
+				break
+			}
+			pid = pidPageTwoOffset + int(data[offset])
+			offset++
+		}
+
+		// Определяем длину данных для этого PID
+		dataLength, err := getPIDDataLength(pid, data, offset)
+		if err != nil {
+			log.Printf("J1587: ошибка определения длины данных для PID %d: %v", pid, err)
+			break
+		}
+		if isVariableLengthPID(pid) {
+			offset++ // Байт длины
+		}
+
+		// Проверяем, что у нас достаточно данных
+		if offset+dataLength > len(data) {
+			log.Printf("J1587: недостаточно данных для PID %d: нужно %d байт, доступно %d",
+				pid, dataLength, len(data)-offset)
+			break
+		}
+
+		// Извлекаем данные для этого PID
+		paramData := data[offset : offset+dataLength]
+		offset += dataLength
+
+		if p.pidObserver != nil {
+			p.pidObserver(mid, pid, paramData)
+		}
+
+		if pid == pidDataLinkEscape {
+			logging.Debugf("J1587: MID=%d: собственные данные производителя (PID 254) пропущены: % X", mid, paramData)
+			continue
+		}
+
+		logging.Debugf("J1587: обработка PID=%d, данные=% X", pid, paramData)
+
+		// Обрабатываем конкретный PID
+		p.processPIDData(mid, pid, paramData)
+	}
+}
+
+// safeParseFrame разбирает фрейм, не давая ошибке разбора некорректного фрейма
+// (например, выходу за границы среза) остановить агент.
+func (p *Bus) safeParseFrame(frame []byte) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("J1587: паника при разборе фрейма % X: %v", frame, r)
+		}
+	}()
+	p.parseFrame(frame)
+}
+
+// processPIDData обрабатывает данные для конкретного PID.
+// Многобайтовые параметры J1587 передаются младшим байтом вперед (little-endian).
+func (p *Bus) processPIDData(mid int, pid int, paramData []byte) {
+	// Парсинг различных параметров по их PID
+	switch pid {
+	case PID_VEHICLE_SPEED:
+		if len(paramData) >= 1 {
+			speed := float64(paramData[0])
+			p.data.Set("speed", speed) // Используем Set
+		}
+	case PID_ENGINE_RPM:
+		if len(paramData) >= 2 {
+			// 2 байта, младший первым, 0.25 об/мин/бит
+			rpm := float64(binary.LittleEndian.Uint16(paramData)) * 0.25
+			p.data.Set("engine_rpm", rpm) // Используем Set
+		}
+	case PID_COOLANT_TEMP:
+		if len(paramData) >= 1 {
+			temp := float64(int(paramData[0]) - 40) // Коррекция смещения по J1587
+			p.data.Set("coolant_temp", temp)        // Используем Set
+		}
+	case PID_OIL_PRESSURE:
+		if len(paramData) >= 1 {
+			pressure := float64(paramData[0]) * 4.0
+			p.data.Set("oil_pressure", pressure) // Используем Set
+		}
+	case PID_ENGINE_LOAD:
+		if len(paramData) >= 1 {
+			load := float64(paramData[0]) * 0.5 // 0,5 %/бит
+			p.data.Set("engine_load", load)     // Используем Set
+		}
+	case PID_FUEL_LEVEL:
+		if len(paramData) >= 1 {
+			level := float64(paramData[0]) / 2.55 // Преобразуем в процент
+			p.data.Set("fuel_level", level)       // Используем Set
+		}
+	case PID_BATTERY_VOLTAGE:
+		if len(paramData) >= 2 {
+			// 2 байта, младший первым, 0.05 В/бит
+			voltage := float64(binary.LittleEndian.Uint16(paramData)) * 0.05
+			p.data.Set("battery_voltage", voltage) // Используем Set
+		}
+	case PID_AMBIENT_TEMP:
+		if len(paramData) >= 1 {
+			temp := float64(int(paramData[0]) - 40)
+			p.data.Set("ambient_temp", temp) // Используем Set
+		}
+	case PID_TOTAL_DISTANCE:
+		if len(paramData) >= 4 {
+			// 4 байта, младший первым, 0.161 км/бит (0.1 мили)
+			distance := float64(binary.LittleEndian.Uint32(paramData)) * 0.161
+			p.data.Set("total_distance", distance) // Используем Set
+		}
+	case PID_VIN:
+		if vin, ok := common.ParseVIN(paramData); ok {
+			p.setVIN(vin)
+		} else {
+			logging.Debugf("J1587: MID=%d: некорректный VIN: % X", mid, paramData)
+		}
+	case PID_TP_CONNECTION_MANAGEMENT:
+		p.tp.handleCM(mid, paramData, p.clock.Now())
+		p.deliverTPReply(mid, paramData)
+	case PID_TP_DATA_TRANSFER:
+		if message, ok := p.tp.handleDT(mid, paramData, p.clock.Now()); ok {
+			logging.Debugf("J1587 TP: собрано сообщение MID=%d, %d байт", mid, len(message))
+			p.parsePIDBlocks(mid, message)
+		}
+	case PID_DIAGNOSTIC_RESPONSE:
+		p.deliverDiagnosticResponse(mid, paramData)
+	case PID_ACTIVE_DTC:
+		// Логика DTC остается прежней, так как DTC отправляются в канал, а не сохраняются в p.data
+		codes := parseDTCCodes(mid, pid, paramData, p.clock.Now())
+		// PID 194 передает полную таблицу кодов модуля, включая неактивные
+		var active []common.DTCCode
+		for _, code := range codes {
+			if !code.inactive {
+				active = append(active, code.DTCCode)
+			}
+		}
+		p.activeDTCs.Update(mid, active, p.clock.Now())
+		for _, code := range codes {
+			// В common.DTCCode нет поля Active: неактивные коды из таблицы PID 194
+			// отправляются в общий канал dtcChan вместе с активными.
+			select {
+			case p.dtcChan <- code.DTCCode:
+			default:
+				log.Printf("Канал DTC переполнен, DTC (PID: %d) пропущен (J1587)", pid)
+			}
+		}
+
+	default:
+		logging.Debugf("J1587: неизвестный PID: %d для MID: %d", pid, mid)
+	}
+}
+
+// Биты байта описания кода неисправности J1587 (PID 194)
+const (
+	dtcFlagOCIncluded  = 0x80 // Бит 8: за кодом следует байт счетчика срабатываний
+	dtcFlagInactive    = 0x40 // Бит 7: неисправность неактивна
+	dtcFlagSID         = 0x20 // Бит 6: код является SID (иначе PID)
+	dtcFlagPageTwo     = 0x10 // Бит 5: номер из второй страницы (256-511)
+	dtcMaskFMI         = 0x0F // Биты 4-1: FMI
+	dtcPageTwoIDOffset = 256
+)
+
+// j1587DTC - код неисправности с признаком неактивности из байта описания.
+type j1587DTC struct {
+	common.DTCCode
+	inactive bool
+}
+
+// parseDTCCodes разбирает список кодов неисправностей из данных PID 194.
+// Каждый код занимает 2 байта (номер PID/SID и байт описания) и еще 1 байт,
+// если установлен флаг наличия счетчика срабатываний. now - время обнаружения кодов.
+func parseDTCCodes(mid int, pid int, paramData []byte, now time.Time) []j1587DTC {
+	var codes []j1587DTC
+	for offset := 0; offset+1 < len(paramData); {
+		code := int(paramData[offset])
+		desc := paramData[offset+1]
+		offset += 2
+
+		if desc&dtcFlagPageTwo != 0 {
+			code += dtcPageTwoIDOffset
+		}
+		codeType := common.DTCCodeTypePID
+		if desc&dtcFlagSID != 0 {
+			codeType = common.DTCCodeTypeSID
+		}
+
+		dtc := common.DTCCode{
+			Timestamp: now.UnixNano(),
+			MID:       mid,
+			PID:       pid,  // PID сообщения с кодом (194)
+			SPN:       code, // Номер PID или SID, на который ссылается код (см. CodeType)
+			FMI:       int(desc & dtcMaskFMI),
+			CodeType:  codeType,
+		}
+		if desc&dtcFlagOCIncluded != 0 {
+			if offset >= len(paramData) {
+				log.Printf("J1587: DTC MID=%d код %d: отсутствует байт счетчика срабатываний", mid, code)
+				break
+			}
+			dtc.OC = int(paramData[offset])
+			offset++
+		}
+		codes = append(codes, j1587DTC{DTCCode: dtc, inactive: desc&dtcFlagInactive != 0})
+	}
+	return codes
+}
+
+// processFrames обрабатывает полученные фреймы
+func (p *Bus) processFrames() {
+	for {
+		select {
+		case <-p.stopChan:
+			return
+		case frame := <-p.frames:
+			p.framesReceived.Add(1)
+			if len(frame) < 3 { // MID + минимум 1 PID + checksum
+				log.Printf("J1587: получен слишком короткий фрейм: %d байт", len(frame))
+				continue
+			}
+
+			// Выводим фрейм для отладки
+			logging.Debugf("J1587 FRAME: % X", frame)
+
+			// Парсим фрейм J1587
+			p.safeParseFrame(frame)
+		}
+	}
+}
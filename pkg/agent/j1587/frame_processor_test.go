@@ -0,0 +1,370 @@
+package j1587
+
+import (
+	"io"
+	"log"
+	"math"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/serebryakov7/j1708-stats/common"
+)
+
+// newTestBus создает шину без порта и базы для проверки разбора фреймов.
+func newTestBus(t testing.TB) *Bus {
+	t.Helper()
+	bus, err := NewBus(nil, nil, nil)
+	if err != nil {
+		t.Fatalf("NewBus: %v", err)
+	}
+	return bus
+}
+
+// drainDTCs возвращает DTC, уже отправленные разбором в канал шины.
+func drainDTCs(bus *Bus) []common.DTCCode {
+	var codes []common.DTCCode
+	for {
+		select {
+		case dtc := <-bus.dtcChan:
+			codes = append(codes, dtc)
+		default:
+			return codes
+		}
+	}
+}
+
+// checksumFrames - верные фреймы с контрольной суммой в последнем байте.
+var checksumFrames = []struct {
+	name  string
+	frame []byte
+}{
+	{"скорость и обороты", frameSpeedRPM},
+	{"температура охлаждающей жидкости", frameCoolantTemp},
+	{"несколько PID", []byte{0x80, 0x54, 0x50, 0xBE, 0xC0, 0x12, 0xA8, 0x14, 0x01, 0x8F}},
+	{"DTC PID 194", []byte{0x80, 0xC2, 0x0B, 0x6E, 0x03, 0x05, 0x25, 0x2C, 0x13, 0x97, 0xAC, 0x07, 0x64, 0x42, 0xE9}},
+	// Сумма MID и данных 0x80 + 0x54 + 0x2C = 256: контрольная сумма 0x00, а не 256
+	{"сумма кратна 256", []byte{0x80, 0x54, 0x2C, 0x00}},
+	// Сумма 0x80 + 0x54 + 0x2D = 257: контрольная сумма 0xFF
+	{"сумма 257", []byte{0x80, 0x54, 0x2D, 0xFF}},
+}
+
+func TestCalculateJ1587Checksum(t *testing.T) {
+	for _, tt := range checksumFrames {
+		t.Run(tt.name, func(t *testing.T) {
+			body, want := tt.frame[:len(tt.frame)-1], tt.frame[len(tt.frame)-1]
+			if got := calculateJ1587Checksum(body); got != want {
+				t.Errorf("calculateJ1587Checksum(% X) = 0x%02X, ожидается 0x%02X", body, got, want)
+			}
+		})
+	}
+}
+
+func TestValidateJ1587Checksum(t *testing.T) {
+	for _, tt := range checksumFrames {
+		if !validateJ1587Checksum(tt.frame) {
+			t.Errorf("%s: верный фрейм % X отклонен", tt.name, tt.frame)
+		}
+	}
+
+	invalid := []struct {
+		name  string
+		frame []byte
+	}{
+		{"неверная сумма", frameBadChecksum},
+		{"сумма на 1 больше", []byte{0x80, 0x54, 0x2C, 0x01}},
+		// Сумма байтов 0x80 + 0x80 кратна 256, но фрейм короче MID + PID + суммы
+		{"короткий фрейм", []byte{0x80, 0x80}},
+		{"один байт", []byte{0x00}},
+		{"пустой фрейм", nil},
+	}
+	for _, tt := range invalid {
+		if validateJ1587Checksum(tt.frame) {
+			t.Errorf("%s: фрейм % X принят", tt.name, tt.frame)
+		}
+	}
+}
+
+func TestParseDTCCodesCodeTypes(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tests := []struct {
+		name     string
+		data     []byte
+		want     common.DTCCode
+		inactive bool
+	}{
+		{
+			name: "PID",
+			data: []byte{0x6E, 0x03}, // PID 110, FMI 3
+			want: common.DTCCode{MID: 128, PID: PID_ACTIVE_DTC, SPN: 110, FMI: 3, CodeType: common.DTCCodeTypePID},
+		},
+		{
+			name: "SID",
+			data: []byte{0x05, 0x25}, // SID 5 (форсунка 5), FMI 5
+			want: common.DTCCode{MID: 128, PID: PID_ACTIVE_DTC, SPN: 5, FMI: 5, CodeType: common.DTCCodeTypeSID},
+		},
+		{
+			name: "PID второй страницы",
+			data: []byte{0x2C, 0x13}, // PID 300 (256 + 44), FMI 3
+			want: common.DTCCode{MID: 128, PID: PID_ACTIVE_DTC, SPN: 300, FMI: 3, CodeType: common.DTCCodeTypePID},
+		},
+		{
+			name: "SID со счетчиком",
+			data: []byte{0x97, 0xAC, 0x07}, // SID 151, FMI 12, счетчик 7
+			want: common.DTCCode{MID: 128, PID: PID_ACTIVE_DTC, SPN: 151, FMI: 12, OC: 7, CodeType: common.DTCCodeTypeSID},
+		},
+		{
+			name:     "неактивный PID",
+			data:     []byte{0x64, 0x42}, // PID 100, FMI 2
+			want:     common.DTCCode{MID: 128, PID: PID_ACTIVE_DTC, SPN: 100, FMI: 2, CodeType: common.DTCCodeTypePID},
+			inactive: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			codes := parseDTCCodes(128, PID_ACTIVE_DTC, tt.data, now)
+			if len(codes) != 1 {
+				t.Fatalf("кодов %d, ожидается 1: %+v", len(codes), codes)
+			}
+			tt.want.Timestamp = now.UnixNano()
+			if codes[0].DTCCode != tt.want {
+				t.Errorf("код %+v, ожидается %+v", codes[0].DTCCode, tt.want)
+			}
+			if codes[0].inactive != tt.inactive {
+				t.Errorf("inactive = %v, ожидается %v", codes[0].inactive, tt.inactive)
+			}
+		})
+	}
+}
+
+func TestParseDTCCodesMissingOC(t *testing.T) {
+	// Флаг счетчика установлен, но байта счетчика нет: код отбрасывается, предыдущие остаются
+	codes := parseDTCCodes(128, PID_ACTIVE_DTC, []byte{0x6E, 0x03, 0x05, 0xA5}, time.Now())
+	if len(codes) != 1 || codes[0].SPN != 110 {
+		t.Fatalf("коды %+v, ожидается только PID 110", codes)
+	}
+}
+
+func TestParseFrameDTCCodeTypes(t *testing.T) {
+	bus := newTestBus(t)
+	// MID 128, PID 194 длиной 11: PID 110/FMI 3, SID 5/FMI 5, PID 300/FMI 3,
+	// SID 151/FMI 12 со счетчиком 7 и неактивный PID 100/FMI 2
+	frame := []byte{0x80, 0xC2, 0x0B, 0x6E, 0x03, 0x05, 0x25, 0x2C, 0x13, 0x97, 0xAC, 0x07, 0x64, 0x42, 0xE9}
+	bus.parseFrame(frame)
+
+	type code struct {
+		spn, fmi int
+		codeType string
+	}
+	want := []code{
+		{110, 3, common.DTCCodeTypePID},
+		{5, 5, common.DTCCodeTypeSID},
+		{300, 3, common.DTCCodeTypePID},
+		{151, 12, common.DTCCodeTypeSID},
+		{100, 2, common.DTCCodeTypePID},
+	}
+	got := drainDTCs(bus)
+	if len(got) != len(want) {
+		t.Fatalf("получено DTC %d, ожидается %d: %+v", len(got), len(want), got)
+	}
+	for i, dtc := range got {
+		if c := (code{dtc.SPN, dtc.FMI, dtc.CodeType}); c != want[i] {
+			t.Errorf("DTC %d: %+v, ожидается %+v", i, c, want[i])
+		}
+		if dtc.MID != 128 || dtc.PID != PID_ACTIVE_DTC {
+			t.Errorf("DTC %d: MID %d, PID %d", i, dtc.MID, dtc.PID)
+		}
+	}
+	// Неактивный код публикуется, но не входит в список активных
+	if active := bus.ActiveDTCs(); len(active) != 4 {
+		t.Errorf("активных DTC %d, ожидается 4: %+v", len(active), active)
+	}
+}
+
+func TestDTCStorageIDSeparatesSID(t *testing.T) {
+	pid := common.DTCCode{SPN: 5, CodeType: common.DTCCodeTypePID}
+	sid := common.DTCCode{SPN: 5, CodeType: common.DTCCodeTypeSID}
+	if dtcStorageID(pid) == dtcStorageID(sid) {
+		t.Errorf("PID 5 и SID 5 имеют одинаковый идентификатор хранилища %d", dtcStorageID(pid))
+	}
+}
+
+func TestParseFramePageTwo(t *testing.T) {
+	type block struct {
+		pid  int
+		data string
+	}
+	tests := []struct {
+		name   string
+		body   []byte // MID и блоки PID/Data без контрольной суммы
+		blocks []block
+		want   map[string]float64
+	}{
+		{
+			name:   "PID 261 второй страницы",
+			body:   []byte{0x80, 0xFF, 0x05, 0x64},
+			blocks: []block{{261, "\x64"}},
+		},
+		{
+			name:   "двухбайтовый PID 389 второй страницы и PID 84",
+			body:   []byte{0x80, 0xFF, 0x85, 0x34, 0x12, 0x54, 0x58},
+			blocks: []block{{389, "\x34\x12"}, {84, "\x58"}},
+			want:   map[string]float64{"speed": 88},
+		},
+		{
+			// Блок PID 254 пропускается по байту длины, следующий PID разбирается
+			name:   "PID 254 и PID 110",
+			body:   []byte{0x80, 0xFE, 0x03, 0x01, 0xFF, 0x6E, 0x6E, 0x5A},
+			blocks: []block{{254, "\x01\xFF\x6E"}, {110, "\x5A"}},
+			want:   map[string]float64{"coolant_temp": 50},
+		},
+		{
+			// Escape 255 в конце без номера PID: разобранные до него блоки сохраняются
+			name:   "255 в конце фрейма",
+			body:   []byte{0x80, 0x54, 0x58, 0xFF},
+			blocks: []block{{84, "\x58"}},
+			want:   map[string]float64{"speed": 88},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bus := newTestBus(t)
+			var blocks []block
+			bus.SetPIDObserver(func(mid, pid int, data []byte) {
+				if mid != 0x80 {
+					t.Errorf("PID %d: MID %d, ожидается 128", pid, mid)
+				}
+				blocks = append(blocks, block{pid, string(data)})
+			})
+			bus.parseFrame(withChecksum(tt.body))
+			if n := bus.ValidFrames(); n != 1 {
+				t.Fatalf("фрейм % X не принят", tt.body)
+			}
+			if !reflect.DeepEqual(blocks, tt.blocks) {
+				t.Errorf("блоки %+q, ожидается %+q", blocks, tt.blocks)
+			}
+			for key, want := range tt.want {
+				if got, ok := bus.data.GetFloat64(key); !ok || got != want {
+					t.Errorf("%s = %v (%v), ожидается %v", key, got, ok, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseFrameMultiBytePIDs(t *testing.T) {
+	// Многобайтовые параметры J1587 передаются младшим байтом вперед: при разборе
+	// старшим байтом вперед обороты 0x12C0 превратились бы в 0xC012 (12292 об/мин)
+	tests := []struct {
+		name  string
+		frame []byte
+		want  map[string]float64
+	}{
+		{
+			name:  "обороты PID 190",
+			frame: []byte{0x80, 0xBE, 0xC0, 0x12, 0xF0},
+			want:  map[string]float64{"engine_rpm": 1200},
+		},
+		{
+			name:  "напряжение PID 168",
+			frame: []byte{0x80, 0xA8, 0x14, 0x01, 0xC3},
+			want:  map[string]float64{"battery_voltage": 13.8},
+		},
+		{
+			name:  "пробег PID 245",
+			frame: []byte{0x80, 0xF5, 0x04, 0x87, 0xD6, 0x12, 0x00, 0x18},
+			want:  map[string]float64{"total_distance": 198765.287},
+		},
+		{
+			name:  "несколько PID в одном фрейме",
+			frame: []byte{0x80, 0x54, 0x50, 0xBE, 0xC0, 0x12, 0xA8, 0x14, 0x01, 0x8F},
+			want:  map[string]float64{"speed": 80, "engine_rpm": 1200, "battery_voltage": 13.8},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bus := newTestBus(t)
+			bus.parseFrame(tt.frame)
+			if n := bus.ValidFrames(); n != 1 {
+				t.Fatalf("фрейм % X не принят (неверная контрольная сумма?)", tt.frame)
+			}
+			for key, want := range tt.want {
+				got, ok := bus.data.GetFloat64(key)
+				if !ok || math.Abs(got-want) > 1e-6 {
+					t.Errorf("%s = %v (%v), ожидается %v", key, got, ok, want)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkParseFrame(b *testing.B) {
+	benchmarks := []struct {
+		name  string
+		frame []byte
+	}{
+		{"один PID", frameCoolantTemp},
+		{"скорость и обороты", frameSpeedRPM},
+		{"несколько PID", []byte{0x80, 0x54, 0x50, 0xBE, 0xC0, 0x12, 0xA8, 0x14, 0x01, 0x8F}},
+		{"DTC PID 194", []byte{0x80, 0xC2, 0x0B, 0x6E, 0x03, 0x05, 0x25, 0x2C, 0x13, 0x97, 0xAC, 0x07, 0x64, 0x42, 0xE9}},
+		{"неверная контрольная сумма", frameBadChecksum},
+	}
+	// Отброшенные фреймы пишутся в журнал: вывод подавляется, чтобы не измерять запись в stderr
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			bus := newTestBus(b)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				bus.parseFrame(bm.frame)
+				// Канал DTC вычитывается, как это делает публикация в main
+				drainDTCs(bus)
+			}
+		})
+	}
+}
+
+// withChecksum возвращает фрейм из MID и данных с добавленной контрольной суммой.
+func withChecksum(body []byte) []byte {
+	return append(append([]byte(nil), body...), calculateJ1587Checksum(body))
+}
+
+// FuzzParseFrame вызывает parseFrame напрямую, минуя recover в safeParseFrame,
+// чтобы паника разбора завершала тест. Вход - MID и блоки PID/Data без контрольной
+// суммы: фрейм разбирается как есть и с верной суммой, иначе почти все входы
+// отбрасывались бы проверкой суммы.
+func FuzzParseFrame(f *testing.F) {
+	seeds := [][]byte{
+		nil,
+		{0x80},
+		frameSpeedRPM[:len(frameSpeedRPM)-1],
+		{0x80, 0x54, 0x50, 0xBE, 0xC0, 0x12, 0xA8, 0x14, 0x01},
+		// PID 194: PID, SID, PID второй страницы, SID со счетчиком и неактивный PID
+		{0x80, 0xC2, 0x0B, 0x6E, 0x03, 0x05, 0x25, 0x2C, 0x13, 0x97, 0xAC, 0x07, 0x64, 0x42},
+		// PID 194 с флагом счетчика без байта счетчика
+		{0x80, 0xC2, 0x04, 0x6E, 0x03, 0x05, 0xA5},
+		// Escape 255: PID 261 второй страницы; escape в конце фрейма
+		{0x80, 0xFF, 0x05, 0x64},
+		{0x80, 0x54, 0x50, 0xFF},
+		// Escape 254: собственное сообщение производителя длиной 3 и длина больше данных
+		{0x80, 0xFE, 0x03, 0x01, 0x02, 0x03},
+		{0x80, 0xFE, 0x10, 0x01},
+		// Переменная длина без байта длины
+		{0x80, 0xC2},
+		// TP: объявление RTS (1 сегмент, 2 байта) и сегмент с PID 110 в одном фрейме
+		{0x80, 0xC5, 0x05, 0xFF, tpCMRTS, 0x01, 0x02, 0x00, 0xC6, 0x04, 0xFF, 0x01, 0x6E, 0x5A},
+		// VIN (PID 237)
+		append([]byte{0x80, 0xED, 0x11}, "1FUJGLDR12LM12345"...),
+	}
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, body []byte) {
+		bus := newTestBus(t)
+		bus.parseFrame(body)
+		bus.parseFrame(withChecksum(body))
+		drainDTCs(bus)
+	})
+}
@@ -0,0 +1,93 @@
+package j1587
+
+import (
+	"fmt"
+
+	"github.com/serebryakov7/j1708-stats/pkg/logging"
+)
+
+// Способы разделения потока байтов на фреймы (-framing).
+const (
+	// framingTiming - фрейм завершается паузой между байтами не меньше interFrameGap.
+	framingTiming = "timing"
+	// framingChecksum - границы фреймов определяются по контрольной сумме и структуре
+	// PID/Data, без учета пауз. Для адаптеров, которые передают данные без межфреймовых пауз.
+	framingChecksum = "checksum"
+)
+
+// j1708MaxFrameLen - максимальная длина фрейма J1708 вместе с MID и контрольной суммой.
+const j1708MaxFrameLen = 21
+
+// parseFraming проверяет значение флага -framing.
+func parseFraming(mode string) (string, error) {
+	switch mode {
+	case framingTiming, framingChecksum:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("неизвестный способ разделения фреймов %q (ожидается %s или %s)", mode, framingTiming, framingChecksum)
+	}
+}
+
+// checksumFramer выделяет фреймы J1708 из непрерывного потока байтов.
+// После каждого байта ищется фрейм, который им завершается: сумма его байтов кратна 256,
+// а структура правдоподобна (см. plausibleFrame). Кандидаты проверяются от самого
+// длинного (начиная с первого накопленного байта) к самому короткому; байты перед
+// найденным фреймом отбрасываются. Накапливается не больше j1708MaxFrameLen байт.
+type checksumFramer struct {
+	buf []byte
+}
+
+// feed добавляет байт и возвращает фрейм, если он завершился этим байтом.
+func (f *checksumFramer) feed(b byte) []byte {
+	f.buf = append(f.buf, b)
+	if len(f.buf) > j1708MaxFrameLen {
+		logging.Debugf("J1587: граница фрейма не найдена, пропущен байт %02X", f.buf[0])
+		f.buf = f.buf[1:]
+	}
+	sum := 0
+	for _, c := range f.buf {
+		sum += int(c)
+	}
+	for start := 0; len(f.buf)-start >= 3; start++ {
+		if sum%256 == 0 && plausibleFrame(f.buf[start:]) {
+			if start > 0 {
+				logging.Debugf("J1587: перед фреймом пропущены байты % X", f.buf[:start])
+			}
+			frame := f.buf[start:]
+			f.buf = nil
+			return frame
+		}
+		sum -= int(f.buf[start])
+	}
+	return nil
+}
+
+// plausibleFrame сообщает, похож ли frame (MID, блоки PID/Data и контрольная сумма)
+// на фрейм J1587: блоки PID/Data должны точно заполнять данные фрейма.
+func plausibleFrame(frame []byte) bool {
+	if len(frame) < 3 {
+		return false
+	}
+	data := frame[1 : len(frame)-1]
+	offset := 0
+	for offset < len(data) {
+		pid := int(data[offset])
+		offset++
+		if pid == pidPageTwoEscape {
+			if offset >= len(data) {
+				return false
+			}
+			pid = pidPageTwoOffset + int(data[offset])
+			offset++
+		}
+		dataLength, err := getPIDDataLength(pid, data, offset)
+		if err != nil {
+			return false
+		}
+		if isVariableLengthPID(pid) {
+			offset++
+		}
+		offset += dataLength
+	}
+	return offset == len(data)
+}
@@ -0,0 +1,665 @@
+package j1587
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/tarm/serial"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/serebryakov7/j1708-stats/common"
+	"github.com/serebryakov7/j1708-stats/pkg/analytics"
+	"github.com/serebryakov7/j1708-stats/pkg/envflag"
+	"github.com/serebryakov7/j1708-stats/pkg/filter"
+	"github.com/serebryakov7/j1708-stats/pkg/httpapi"
+	"github.com/serebryakov7/j1708-stats/pkg/logging"
+	"github.com/serebryakov7/j1708-stats/pkg/mqtt"
+	"github.com/serebryakov7/j1708-stats/pkg/profiling"
+	"github.com/serebryakov7/j1708-stats/pkg/retry"
+	"github.com/serebryakov7/j1708-stats/pkg/selftest"
+	"github.com/serebryakov7/j1708-stats/pkg/sink"
+	"github.com/serebryakov7/j1708-stats/pkg/storage"
+)
+
+// Настройки по умолчанию
+const (
+	defaultPortName         = "/dev/ttyUSB0"
+	defaultBaudRate         = "9600"
+	defaultMqttBroker       = "tcp://localhost:1883"
+	defaultMqttTopic        = "vehicle/data/j1587"
+	defaultMqttDTCTopic     = "vehicle/dtc/j1587"
+	defaultMqttCommandTopic = "vehicle/command/j1587"
+	defaultUpdateInterval   = 10 * time.Second
+	defaultHeartbeatTopic   = "vehicle/heartbeat/j1587"
+	defaultDbPath           = "agent_j1587_dtc.db"
+)
+
+// flags - параметры агента. Собственный набор вместо flag.CommandLine позволяет
+// собрать оба агента в одну программу (cmd/j1708-stats).
+var flags = flag.NewFlagSet("j1587", flag.ExitOnError)
+
+var (
+	portName          = flags.String("port", defaultPortName, "Последовательный порт для чтения данных")
+	baudRate          = flags.String("baud", defaultBaudRate, "Скорость передачи данных в бодах или auto - определить автоматически по числу корректных фреймов")
+	serialParity      = flags.String("parity", "none", "Четность порта: none, odd, even, mark или space")
+	serialDataBits    = flags.Int("databits", 8, "Число битов данных порта (5-8)")
+	serialStopBits    = flags.String("stopbits", "1", "Число стоповых битов порта: 1, 1.5 или 2")
+	selftestMode      = flags.Bool("selftest", false, "Самопроверка установки: прочитать шину в течение -selftest-duration, вывести число кадров и наблюдавшиеся PID, проверить подключение к MQTT и завершиться с кодом 0 (пройдена) или 1")
+	selftestDuration  = flags.Duration("selftest-duration", selftest.DefaultDuration, "Время чтения шины при самопроверке (-selftest)")
+	openAttempts      = flags.Int("open-attempts", 10, "Максимальное число попыток открыть порт при запуске (адаптер может быть еще не готов после загрузки), 0 - без ограничения")
+	openTimeout       = flags.Duration("open-timeout", time.Minute, "Время, в течение которого повторяются попытки открыть порт при запуске, 0 - без ограничения")
+	adapterHandshake  = flags.Duration("adapter-handshake", DefaultAdapterHandshake, "Сколько после запуска отбрасывать текстовый вывод адаптера (приглашения и баннеры ELM327 и подобных), 0 - не отбрасывать")
+	localMID          = flags.Int("local-mid", DefaultLocalMID, "MID, с которым агент передает команды на шину (172 - Off-Board Diagnostics #1); фреймы с этим MID не разбираются")
+	framing           = flags.String("framing", framingTiming, "Разделение потока байтов на фреймы: timing (по паузам между фреймами) или checksum (по контрольной сумме, для адаптеров без межфреймовых пауз)")
+	mqttBroker        = flags.String("broker", defaultMqttBroker, "MQTT брокер")
+	mqttTopic         = flags.String("topic", defaultMqttTopic, "MQTT топик для основных данных")
+	mqttDTCTopic      = flags.String("dtc_topic", defaultMqttDTCTopic, "MQTT топик для кодов неисправностей (DTC)")
+	mqttCommandTopic  = flags.String("command_topic", defaultMqttCommandTopic, "MQTT топик для команд")
+	mqttAckTopic      = flags.String("ack_topic", "", "MQTT топик результатов выполнения команд (пусто - топик команд + /ack)")
+	updateInterval    = flags.Duration("interval", defaultUpdateInterval, "Интервал обновления MQTT в секундах")
+	clientID          = flags.String("client-id", "", "Идентификатор клиента MQTT (пусто - постоянный, производный от VIN или имени хоста и интерфейса)")
+	randomClientID    = flags.Bool("random-client-id", false, "Добавлять к идентификатору клиента MQTT случайный суффикс (сессия брокера не сохраняется между запусками)")
+	mqttKeepAlive     = flags.Duration("keepalive", mqtt.DefaultKeepAlive, "Интервал keepalive MQTT")
+	mqttTimeout       = flags.Duration("mqtt-timeout", mqtt.DefaultWaitTimeout, "Максимальное время ожидания подключения к брокеру и подтверждения публикации; по истечении публикация считается неудачной")
+	mqttQoS           = flags.Int("qos", 0, "QoS публикации данных, DTC и heartbeat: 0 - без подтверждения, 1 или 2 - брокер подтверждает доставку (PUBACK/PUBCOMP), подтвержденные публикации учитываются в heartbeat")
+	cleanSession      = flags.Bool("clean-session", true, "Начинать MQTT-сессию заново при каждом подключении (false - брокер хранит сессию и команды QoS 1)")
+	publishJitter     = flags.Float64("jitter", 0, "Доля случайного отклонения интервала публикации MQTT, например 0.2 - ±20% (0 - строго по интервалу)")
+	dtcStorm          = flags.Int("dtc-storm", 0, "Число DTC за -dtc-storm-window, при превышении которого коды публикуются сводкой (0 - выключено)")
+	dtcStormWindow    = flags.Duration("dtc-storm-window", 10*time.Second, "Окно подсчета DTC для -dtc-storm")
+	dtcStormDetail    = flags.Duration("dtc-storm-detail", 5*time.Second, "Во время шторма DTC отдельные коды публикуются не чаще раза в этот интервал (0 - только сводка)")
+	statsInterval     = flags.Duration("stats-interval", 5*time.Minute, "Интервал вывода в лог скорости отправки данных (0 - не выводить)")
+	maxPayload        = flags.Int("max-payload", 0, "Максимальный размер сообщения MQTT в байтах; более крупные снимки сокращаются удалением наименее важных полей (0 - без ограничения)")
+	retainData        = flags.Bool("retain", false, "Публиковать снимок данных с флагом retain: новый подписчик сразу получает последнее значение (DTC не сохраняются)")
+	heartbeatTopic    = flags.String("heartbeat_topic", defaultHeartbeatTopic, "MQTT топик для heartbeat")
+	heartbeatInterval = flags.Duration("heartbeat-interval", time.Minute, "Интервал публикации heartbeat (0 - не публиковать)")
+	dbPath            = flags.String("dbpath", defaultDbPath, "Путь к файлу базы bbolt для дедупликации DTC")
+	dtcStore          = flags.String("dtc-store", storage.DTCStoreBolt, "Хранилище DTC: bolt (база -dbpath), memory (в памяти, без базы) или none (повтор DTC подавляется только -dtc-window); без базы состояние не сохраняется")
+	dtcMaxKeys        = flags.Int("dtc-max-keys", 0, "Максимальное число кодов в хранилище DTC; при превышении удаляются самые давно зарегистрированные (0 - без ограничения)")
+	dtcOCReporting    = flags.Bool("dtc-oc", false, "Публиковать DTC повторно, когда его счетчик срабатываний (OC) становится больше последнего опубликованного")
+	dtcWindow         = flags.Duration("dtc-window", storage.DefaultDTCWindow, "Окно, в течение которого один и тот же DTC (SPN:FMI) не публикуется повторно независимо от bbolt (0 - отключено)")
+	vehicleID         = flags.String("vehicle-id", "", "Идентификатор автомобиля для блоков, не передающих VIN: публикуется в vehicle_id и подставляется в {vehicle_id} в топиках; полученный с шины VIN имеет приоритет")
+	jsonNaming        = flags.String("json-naming", string(common.JSONNamingSnake), "Стиль имен полей в публикуемом JSON: snake (engine_rpm) или camel (engineRpm)")
+	jsonAlias         = flags.String("json-alias", "", "Псевдонимы полей публикуемого JSON (снимки данных и DTC): поле=псевдоним через запятую, например engine_rpm=rpm,spn=SPN")
+	tripOffDelay      = flags.Duration("trip-off-delay", analytics.DefaultOffDelay, "Время без работающего двигателя, после которого поездка считается завершенной")
+	stdoutMode        = flags.Bool("stdout", false, "Печатать данные и DTC в stdout в виде JSON-строк вместо отправки в MQTT")
+	csvPath           = flags.String("csv", "", "Путь к CSV-файлу для записи снимков данных (пусто - не писать)")
+	sqlitePath        = flags.String("sqlite", "", "Путь к базе SQLite для локального хранения метрик и DTC (пусто - не писать, требует сборки с -tags sqlite)")
+	sqliteRetain      = flags.Duration("sqlite-retention", 30*24*time.Hour, "Срок хранения записей в SQLite (0 - бессрочно)")
+	smoothing         = flags.String("smooth", "", "Сглаживание метрик скользящим средним: ключ=окно через запятую (например, fuel_level=5,coolant_temp=10)")
+	hysteresis        = flags.String("hysteresis", "", "Публикация по изменению с гистерезисом: ключ=порог[:время] через запятую (например, coolant_temp=1:5s,fuel_level=0.5); изменение, превысившее порог и продержавшееся заданное время, публикуется сразу, не дожидаясь -interval")
+	onChangeInterval  = flags.Duration("on-change-interval", time.Second, "Минимальный интервал между внеочередными публикациями по изменению метрик -hysteresis")
+	strictKeys        = flags.Bool("strict-keys", false, "Отклонять метрики с именами вне реестра известных метрик (иначе только предупреждение в логе)")
+	onceMode          = flags.Bool("once", false, "Собрать один снимок данных, опубликовать его и завершить работу")
+	nullKeys          = flags.String("null-keys", "", "Метрики через запятую, которые всегда включаются в снимок: без значения - как null (по умолчанию отсутствующие метрики опускаются)")
+	onceKeys          = flags.String("once-keys", "speed,engine_rpm,coolant_temp,fuel_level,total_distance", "Метрики через запятую, которые в режиме -once должны получить значения до публикации")
+	onceTimeout       = flags.Duration("once-timeout", 30*time.Second, "Максимальное время ожидания метрик в режиме -once")
+	logLevel          = flags.String("log-level", "info", "Уровень логирования: info или debug (меняется во время работы сигналами SIGUSR1/SIGUSR2)")
+	httpAddr          = flags.String("http-addr", "", "Адрес HTTP API для команд сервера, например 0.0.0.0:8080 (пусто - выключен)")
+	httpToken         = flags.String("http-token", "", "Токен HTTP API: запросы принимаются с заголовком Authorization: Bearer <токен>")
+	httpUser          = flags.String("http-user", "", "Имя пользователя Basic-аутентификации HTTP API (пароль - -http-password)")
+	httpPassword      = flags.String("http-password", "", "Пароль Basic-аутентификации HTTP API")
+	httpAuthHealth    = flags.Bool("http-auth-health", false, "Требовать аутентификацию и для /healthz")
+	sequence          = flags.Bool("seq", false, "Добавлять в снимки данных и DTC общий возрастающий номер публикации seq")
+	pprofAddr         = flags.String("pprof-addr", "", "Адрес HTTP-сервера pprof, например 127.0.0.1:6060 (пусто - выключен)")
+)
+
+// Main запускает агент J1587/J1708 с параметрами командной строки args (без имени программы).
+// Работает до сигнала завершения; ошибки параметров и результат -once и -selftest завершают процесс.
+func Main(args []string) {
+	flags.Parse(args)
+	fromEnv, err := envflag.Apply(flags, envflag.Prefix)
+	if err != nil {
+		log.Fatalf("Ошибка разбора параметров: %v", err)
+	}
+
+	log.Println("Запуск агента J1587...")
+	if len(fromEnv) > 0 {
+		log.Printf("Параметры из переменных окружения: %s", strings.Join(fromEnv, ", "))
+	}
+
+	level, err := logging.ParseLevel(*logLevel)
+	if err != nil {
+		log.Fatalf("Ошибка разбора параметра -log-level: %v", err)
+	}
+	logging.SetLevel(level)
+	logging.WatchSignals()
+
+	if *pprofAddr != "" {
+		profiling.Serve(*pprofAddr)
+	}
+
+	smoothingWindows, err := filter.ParseWindows(*smoothing)
+	if err != nil {
+		log.Fatalf("Ошибка разбора параметра -smooth: %v", err)
+	}
+	hysteresisConfigs, err := filter.ParseHysteresis(*hysteresis)
+	if err != nil {
+		log.Fatalf("Ошибка разбора параметра -hysteresis: %v", err)
+	}
+	if len(hysteresisConfigs) > 0 && *onChangeInterval <= 0 {
+		log.Fatalf("Параметр -on-change-interval должен быть больше 0: %v", *onChangeInterval)
+	}
+
+	if *mqttQoS < 0 || *mqttQoS > 2 {
+		log.Fatalf("Параметр -qos должен быть 0, 1 или 2: %d", *mqttQoS)
+	}
+	if *publishJitter < 0 || *publishJitter >= 1 {
+		log.Fatalf("Параметр -jitter должен быть в диапазоне [0, 1): %v", *publishJitter)
+	}
+	if *dtcStorm > 0 && *dtcStormWindow <= 0 {
+		log.Fatalf("Параметр -dtc-storm-window должен быть больше 0: %v", *dtcStormWindow)
+	}
+	if *sqlitePath != "" && !sink.SQLiteAvailable() {
+		log.Fatalf("Параметр -sqlite недоступен: агент собран без драйвера SQLite, соберите его с тегом sqlite (go build -tags sqlite)")
+	}
+
+	naming, err := common.ParseJSONNaming(*jsonNaming)
+	if err != nil {
+		log.Fatalf("Ошибка разбора параметра -json-naming: %v", err)
+	}
+	aliases, err := common.ParseFieldAliases(*jsonAlias)
+	if err != nil {
+		log.Fatalf("Ошибка разбора параметра -json-alias: %v", err)
+	}
+
+	framingMode, err := parseFraming(*framing)
+	if err != nil {
+		log.Fatalf("Ошибка разбора параметра -framing: %v", err)
+	}
+	if *localMID < 0 || *localMID > 255 {
+		log.Fatalf("Параметр -local-mid должен быть в диапазоне 0-255: %d", *localMID)
+	}
+
+	baud, err := parseBaud(*baudRate)
+	if err != nil {
+		log.Fatalf("Ошибка разбора параметра -baud: %v", err)
+	}
+	openPolicy := retry.Policy{MaxAttempts: *openAttempts, Timeout: *openTimeout}
+	if baud == 0 {
+		log.Printf("Определение скорости порта %s: перебор %v по %v...", *portName, probeBauds, baudProbeDuration)
+		err = retry.Do("Определение скорости порта", openPolicy, isTransientPortError, func() (err error) {
+			baud, err = detectBaud()
+			return err
+		})
+		if err != nil {
+			log.Fatalf("Не удалось определить скорость порта: %v (%s)", err, portErrorHint(err))
+		}
+		log.Printf("Определена скорость порта: %d бод", baud)
+	}
+
+	portConfig, err := newSerialConfig(baud)
+	if err != nil {
+		log.Fatalf("Ошибка разбора параметров порта: %v", err)
+	}
+	var port *serial.Port
+	err = retry.Do("Открытие порта "+*portName, openPolicy, isTransientPortError, func() (err error) {
+		port, err = openSerialPort(portConfig)
+		return err
+	})
+	if err != nil {
+		log.Fatalf("Ошибка открытия порта: %v (%s)", err, portErrorHint(err))
+	}
+	defer port.Close()
+
+	if *selftestMode {
+		// База не открывается: самопроверка не должна зависеть от состояния агента
+		code := runSelftest(port, framingMode)
+		port.Close()
+		os.Exit(code)
+	}
+
+	storeMode, err := storage.ParseDTCStore(*dtcStore)
+	if err != nil {
+		log.Fatalf("Ошибка разбора параметра -dtc-store: %v", err)
+	}
+	db, store, err := storage.OpenDTCStore(storeMode, *dbPath, *dtcMaxKeys)
+	if errors.Is(err, storage.ErrDBLocked) {
+		log.Fatalf("Ошибка открытия базы DTC: %v. Вероятно, уже запущен другой агент с тем же -dbpath: укажите другой путь или запустите с -dtc-store memory", err)
+	}
+	if err != nil {
+		log.Fatalf("Ошибка открытия базы DTC: %v", err)
+	}
+	switch storeMode {
+	case storage.DTCStoreBolt:
+		log.Printf("База данных DTC %s успешно открыта.", *dbPath)
+	case storage.DTCStoreMemory:
+		log.Println("Хранилище DTC в памяти (-dtc-store memory): коды публикуются повторно после перезапуска, состояние не сохраняется")
+	case storage.DTCStoreNone:
+		log.Println("Хранилище DTC отключено (-dtc-store none): дедупликация только окном -dtc-window, состояние не сохраняется")
+	}
+
+	bus, err := NewBus(port, db, store)
+	if err != nil {
+		log.Fatalf("Ошибка инициализации Bus: %v", err)
+	}
+	defer bus.Close() // Добавлен вызов Close для Bus
+	bus.SetDTCWindow(*dtcWindow)
+	bus.SetOCReporting(*dtcOCReporting)
+	bus.SetFraming(framingMode)
+	bus.SetAdapterHandshake(*adapterHandshake)
+	bus.SetLocalMID(byte(*localMID))
+	if framingMode == framingChecksum {
+		log.Println("Границы фреймов определяются по контрольной сумме (-framing checksum)")
+	}
+
+	bus.data.SetKnownKeys(metricKeys, *strictKeys)
+	bus.data.SetJSONNaming(naming)
+	bus.data.SetFieldAliases(aliases)
+	if err := bus.data.SetNullKeys(splitKeys(*nullKeys)); err != nil {
+		log.Fatalf("Ошибка разбора параметра -null-keys: %v", err)
+	}
+	bus.data.SetFallbackVehicleID(*vehicleID)
+	bus.RestoreVIN()
+	if len(smoothingWindows) > 0 {
+		bus.data.EnableSmoothing(smoothingWindows)
+		log.Printf("Сглаживание включено для метрик: %v", smoothingWindows)
+	}
+	if len(hysteresisConfigs) > 0 {
+		bus.data.EnableHysteresis(hysteresisConfigs)
+		log.Printf("Публикация по изменению включена для метрик: %v (не чаще раза в %v)", hysteresisConfigs, *onChangeInterval)
+	}
+
+	if err := bus.StartReading(); err != nil {
+		log.Fatalf("Ошибка запуска чтения данных J1587: %v", err)
+	}
+	defer bus.StopReading()
+
+	// Все получатели сериализуют снимки через meter, чтобы учитывался весь объем отправки
+	meter := sink.NewMeter(*statsInterval)
+	dataSource := meter.Source(bus.GetData)
+
+	var publisher sink.Publisher
+	// commandHandler выполняет команды сервера из MQTT и HTTP API; nil в режиме stdout
+	var commandHandler func(cmd common.ServerCommand) error
+	if *stdoutMode {
+		log.Println("Режим stdout: данные печатаются в stdout, MQTT не используется.")
+		stdout := sink.NewStdout(*updateInterval, dataSource)
+		stdout.SetFieldAliases(aliases)
+		publisher = stdout
+	} else {
+		mqttConfig := mqtt.MQTTConfig{
+			Broker:            *mqttBroker,
+			ClientID:          mqttClientID(bus.data.VehicleID(), filepath.Base(*portName)),
+			Topic:             *mqttTopic,
+			DTCTopic:          *mqttDTCTopic,
+			CommandTopic:      *mqttCommandTopic,
+			AckTopic:          *mqttAckTopic,
+			UpdateInterval:    *updateInterval,
+			KeepAlive:         *mqttKeepAlive,
+			WaitTimeout:       *mqttTimeout,
+			QoS:               byte(*mqttQoS),
+			CleanSession:      *cleanSession,
+			Protocol:          "j1587",
+			HeartbeatTopic:    *heartbeatTopic,
+			HeartbeatInterval: *heartbeatInterval,
+			RetainData:        *retainData,
+			PublishJitter:     *publishJitter,
+			MaxPayloadBytes:   *maxPayload,
+			PrunePriority:     aliases.Keys(naming.Keys(prunePriority)),
+			FieldAliases:      aliases,
+			Sequence:          *sequence,
+		}
+		applyOverrides(bus, &mqttConfig)
+
+		var mqttClient *mqtt.MQTTClient
+		commandHandler = func(cmd common.ServerCommand) error {
+			return handleMQTTCommand(bus, mqttClient, publisher, cmd)
+		}
+		mqttClient = mqtt.NewClient(mqttConfig, dataSource, commandHandler)
+		mqttClient.SetFramesCounter(bus.FramesReceived)
+		mqttClient.SetVINSource(func() string {
+			vin, _ := bus.data.GetString("vin")
+			return vin
+		})
+		mqttClient.SetVehicleIDSource(bus.data.VehicleID)
+		mqttClient.SetHeartbeatInfo(func() map[string]any {
+			info := map[string]any{"throughput": meter.Throughput()}
+			addStorageInfo(info, bus.db, store)
+			return info
+		})
+		publisher = mqttClient
+	}
+
+	if *csvPath != "" {
+		publisher = sink.Multi{publisher, sink.NewCSV(*csvPath, aliases.Keys(naming.Keys(outputColumns(smoothingWindows))), *updateInterval, dataSource)}
+	}
+
+	if *sqlitePath != "" {
+		publisher = sink.Multi{publisher, sink.NewSQLite(*sqlitePath, *sqliteRetain, *updateInterval, dataSource)}
+	}
+
+	publisher = meter.Wrap(publisher)
+
+	if *dtcStorm > 0 {
+		guard := sink.NewStormGuard(sink.StormConfig{Limit: *dtcStorm, Window: *dtcStormWindow, DetailInterval: *dtcStormDetail}, func(summary sink.StormSummary) {
+			bus.data.Set("dtc_storm", summary)
+			publisher.PublishNow()
+		})
+		publisher = guard.Wrap(publisher)
+	}
+
+	if err := publisher.Connect(); err != nil {
+		log.Fatalf("Ошибка подключения получателей данных: %v", err)
+	}
+	defer publisher.Disconnect()
+
+	if *onceMode {
+		go bus.StartProcessingDTCs(publisher)
+		publishOnce(bus.data, publisher)
+		return
+	}
+
+	publisher.StartPublishing()
+	defer publisher.StopPublishing()
+
+	// Запускаем обработку DTC в Bus
+	go bus.StartProcessingDTCs(publisher)
+
+	startTripTracking(bus, bus.db, *tripOffDelay, publisher)
+	if len(hysteresisConfigs) > 0 {
+		startChangePublishing(bus, hysteresisConfigs, *onChangeInterval, publisher)
+	}
+
+	if *httpAddr != "" {
+		api := httpapi.New(*httpAddr, httpAuth(), commandHandler)
+		api.Start()
+		defer api.Close()
+	}
+
+	log.Printf("Сбор и отправка данных J1587 запущены. Нажмите Ctrl+C для завершения.")
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	<-sigChan
+
+	log.Println("Завершение работы агента J1587...")
+}
+
+// mqttClientID возвращает идентификатор клиента MQTT: значение -client-id или
+// постоянный идентификатор из VIN (если он известен при запуске, например сохранен ранее),
+// иначе из имени хоста, и интерфейса шины.
+func mqttClientID(vehicleID, iface string) string {
+	if *clientID != "" {
+		return *clientID
+	}
+	if vehicleID == "" {
+		vehicleID, _ = os.Hostname()
+	}
+	return mqtt.DeriveClientID("j1587-agent", *randomClientID, vehicleID, iface)
+}
+
+// httpAuth возвращает параметры аутентификации HTTP API из флагов.
+func httpAuth() httpapi.Auth {
+	return httpapi.Auth{Token: *httpToken, User: *httpUser, Password: *httpPassword, ProtectHealth: *httpAuthHealth}
+}
+
+// addStorageInfo добавляет в сведения heartbeat размер базы bbolt (db_size_bytes)
+// и число кодов в хранилище DTC (dtc_store_keys), если они используются.
+func addStorageInfo(info map[string]any, db *bolt.DB, store storage.DTCStore) {
+	if db != nil {
+		if size, err := storage.DBSize(db); err == nil {
+			info["db_size_bytes"] = size
+		}
+	}
+	if store != nil {
+		if n, err := store.Len(); err == nil {
+			info["dtc_store_keys"] = n
+		}
+	}
+}
+
+// tripSample возвращает текущие значения метрик для трекера поездок.
+func tripSample(bus *Bus) analytics.Sample {
+	rpm, rpmOK := bus.data.GetFloat64("engine_rpm")
+	odometer, odometerOK := bus.data.GetFloat64("total_distance")
+	speed, speedOK := bus.data.GetFloat64("speed")
+	return analytics.Sample{
+		RPM:        rpm,
+		RPMOK:      rpmOK,
+		Odometer:   odometer,
+		OdometerOK: odometerOK,
+		Speed:      speed,
+		SpeedOK:    speedOK,
+		Frames:     bus.FramesReceived(),
+	}
+}
+
+// startChangePublishing публикует снимок вне расписания, когда подтверждено изменение
+// одной из метрик -hysteresis. Изменения проверяются раз в interval, поэтому
+// внеочередные публикации происходят не чаще.
+func startChangePublishing(bus *Bus, keys map[string]filter.HysteresisConfig, interval time.Duration, publisher sink.Publisher) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-bus.stopChan:
+				return
+			case <-ticker.C:
+				for _, key := range bus.data.TakeChanged() {
+					if _, ok := keys[key]; ok {
+						logging.Debugf("Подтверждено изменение %s, внеочередная публикация", key)
+						publisher.PublishNow()
+						break
+					}
+				}
+			}
+		}
+	}()
+}
+
+// startTripTracking запускает трекер поездок. Показатели текущей поездки обновляются
+// в метрике trip, итоги завершенной сохраняются в last_trip и сразу публикуются,
+// не дожидаясь интервала.
+func startTripTracking(bus *Bus, db *bolt.DB, offDelay time.Duration, publisher sink.Publisher) {
+	tracker := analytics.NewTripTracker(db, offDelay)
+	sample := func() analytics.Sample { return tripSample(bus) }
+	onSample := func(current *common.TripSummary) {
+		if current == nil {
+			bus.data.Set("trip", nil)
+			return
+		}
+		bus.data.Set("trip", *current)
+	}
+	onEnd := func(summary common.TripSummary) {
+		bus.data.Set("last_trip", summary)
+		publisher.PublishNow()
+	}
+	go tracker.Run(bus.stopChan, sample, onSample, onEnd)
+}
+
+func handleMQTTCommand(bus *Bus, mqttClient *mqtt.MQTTClient, publisher sink.Publisher, cmd common.ServerCommand) error {
+	log.Printf("Получена команда: %+v", cmd)
+
+	switch cmd.Type {
+	case "clear_dtc":
+		var targetMID byte = 128 // MID по умолчанию
+		if cmd.Params.TargetMID != nil {
+			targetMID = *cmd.Params.TargetMID
+		}
+
+		if err := bus.ClearActiveDTCs(targetMID); err != nil {
+			log.Printf("Ошибка выполнения команды сброса DTC: %v", err)
+			return fmt.Errorf("ошибка сброса DTC для MID %d: %w", targetMID, err)
+		}
+		log.Printf("Команда сброса DTC для MID %d выполнена", targetMID)
+		return nil
+	case common.CommandTypeSetInterval:
+		if cmd.Params.Interval == nil {
+			return fmt.Errorf("команда %s: не указан параметр interval", cmd.Type)
+		}
+		interval, err := time.ParseDuration(*cmd.Params.Interval)
+		if err != nil {
+			return fmt.Errorf("команда %s: некорректный интервал %q: %w", cmd.Type, *cmd.Params.Interval, err)
+		}
+		if err := mqttClient.SetInterval(interval); err != nil {
+			return fmt.Errorf("команда %s: %w", cmd.Type, err)
+		}
+		log.Printf("Интервал публикации изменен на %v", interval)
+		return saveOverrides(bus, func(o *storage.Overrides) { o.Interval = interval })
+	case common.CommandTypeSetTopic:
+		var topics mqtt.Topics
+		if p := cmd.Params.Topic; p != nil {
+			if *p == "" {
+				return fmt.Errorf("команда %s: пустой параметр topic", cmd.Type)
+			}
+			topics.Topic = *p
+		}
+		if p := cmd.Params.DTCTopic; p != nil {
+			if *p == "" {
+				return fmt.Errorf("команда %s: пустой параметр dtc_topic", cmd.Type)
+			}
+			topics.DTCTopic = *p
+		}
+		if p := cmd.Params.CommandTopic; p != nil {
+			if *p == "" {
+				return fmt.Errorf("команда %s: пустой параметр command_topic", cmd.Type)
+			}
+			topics.CommandTopic = *p
+		}
+		if err := mqttClient.SetTopics(topics); err != nil {
+			return fmt.Errorf("команда %s: %w", cmd.Type, err)
+		}
+		return saveOverrides(bus, func(o *storage.Overrides) {
+			if topics.Topic != "" {
+				o.Topic = topics.Topic
+			}
+			if topics.DTCTopic != "" {
+				o.DTCTopic = topics.DTCTopic
+			}
+			if topics.CommandTopic != "" {
+				o.CommandTopic = topics.CommandTopic
+			}
+		})
+	case common.CommandTypeResync:
+		resync(publisher, bus.ActiveDTCs(), bus.data.VehicleID())
+		return nil
+	case common.CommandTypeSetMetrics:
+		if cmd.Params.Metrics == nil {
+			return fmt.Errorf("команда %s: не указан параметр metrics", cmd.Type)
+		}
+		keys := *cmd.Params.Metrics
+		if err := bus.data.SetAllowedKeys(keys); err != nil {
+			return fmt.Errorf("команда %s: %w", cmd.Type, err)
+		}
+		logAllowedKeys(keys)
+		return saveOverrides(bus, func(o *storage.Overrides) { o.Metrics = keys })
+	case common.CommandTypeResetConfig:
+		if bus.db == nil {
+			log.Println("База не используется (-dtc-store), сохраненных настроек нет")
+		} else if err := storage.ClearOverrides(bus.db); err != nil {
+			return fmt.Errorf("команда %s: ошибка удаления сохраненных настроек: %w", cmd.Type, err)
+		}
+		// Возвращаем значения из флагов
+		_ = bus.data.SetAllowedKeys(nil)
+		if err := mqttClient.SetInterval(*updateInterval); err != nil {
+			return fmt.Errorf("команда %s: %w", cmd.Type, err)
+		}
+		if err := mqttClient.SetTopics(mqtt.Topics{Topic: *mqttTopic, DTCTopic: *mqttDTCTopic, CommandTopic: *mqttCommandTopic}); err != nil {
+			return fmt.Errorf("команда %s: %w", cmd.Type, err)
+		}
+		log.Println("Сохраненные настройки сброшены, действуют значения из флагов")
+		return nil
+	default:
+		log.Printf("Неизвестный тип команды: %s. Команда обработана успешно (действие по умолчанию).", cmd.Type)
+		return nil
+	}
+}
+
+// resync публикует текущий снимок данных и активные DTC вне расписания (команда resync).
+// Публикация идет через publisher, как обычная: снимок и коды учитываются в объеме
+// отправки, ограничиваются при шторме DTC и пишутся в CSV/SQLite. Хранилище DTC
+// не проверяется: коды уже могли быть отправлены ранее.
+func resync(publisher sink.Publisher, active []common.DTCCode, vehicleID string) {
+	publisher.PublishNow()
+	for _, dtc := range active {
+		dtc.VehicleID = vehicleID
+		publisher.PublishDTC(dtc)
+	}
+	log.Printf("Resync: опубликованы снимок данных и %d активных DTC", len(active))
+}
+
+// applyOverrides накладывает сохраненные командами сервера настройки поверх флагов.
+func applyOverrides(bus *Bus, config *mqtt.MQTTConfig) {
+	if bus.db == nil {
+		return
+	}
+	o, err := storage.LoadOverrides(bus.db)
+	if err != nil {
+		log.Printf("Ошибка чтения сохраненных настроек, используются флаги: %v", err)
+		return
+	}
+	if o.IsZero() {
+		return
+	}
+	if o.Interval > 0 {
+		config.UpdateInterval = o.Interval
+	}
+	if o.Topic != "" {
+		config.Topic = o.Topic
+	}
+	if o.DTCTopic != "" {
+		config.DTCTopic = o.DTCTopic
+	}
+	if o.CommandTopic != "" {
+		config.CommandTopic = o.CommandTopic
+	}
+	if len(o.Metrics) > 0 {
+		if err := bus.data.SetAllowedKeys(o.Metrics); err != nil {
+			log.Printf("Сохраненный список метрик не применен: %v", err)
+		}
+	}
+	log.Printf("Применены сохраненные настройки: %+v", o)
+}
+
+// logAllowedKeys выводит в лог список метрик, заданный командой set_metrics.
+func logAllowedKeys(keys []string) {
+	if len(keys) == 0 {
+		log.Println("Ограничение списка метрик снято, публикуются все метрики")
+		return
+	}
+	log.Printf("Публикуются только метрики: %v", keys)
+}
+
+// saveOverrides сохраняет изменение настроек, чтобы оно пережило перезапуск агента.
+func saveOverrides(bus *Bus, update func(o *storage.Overrides)) error {
+	if bus.db == nil {
+		return fmt.Errorf("настройка применена, но не сохранена: база не используется (-dtc-store)")
+	}
+	if err := storage.UpdateOverrides(bus.db, update); err != nil {
+		return fmt.Errorf("настройка применена, но не сохранена: %w", err)
+	}
+	return nil
+}
+
+// splitKeys разбирает список имен метрик через запятую, пропуская пустые.
+func splitKeys(list string) []string {
+	var keys []string
+	for _, key := range strings.Split(list, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// publishOnce ждет значений метрик -once-keys не дольше -once-timeout
+// и публикует один снимок данных. Если метрики не получены, публикуется неполный снимок.
+func publishOnce(data *ProtectedData, publisher sink.Publisher) {
+	keys := splitKeys(*onceKeys)
+
+	log.Printf("Режим -once: ожидание метрик %v (не дольше %v)...", keys, *onceTimeout)
+	if missing := data.WaitForKeys(keys, *onceTimeout); len(missing) > 0 {
+		log.Printf("Режим -once: метрики %v не получены, публикуется неполный снимок", missing)
+	}
+	publisher.PublishNow()
+	log.Println("Режим -once: снимок данных опубликован")
+}
@@ -0,0 +1,22 @@
+package j1587
+
+// J1587 Parameter IDs
+const (
+	PID_VEHICLE_SPEED   = 84
+	PID_ENGINE_RPM      = 190
+	PID_COOLANT_TEMP    = 110
+	PID_OIL_PRESSURE    = 100
+	PID_ENGINE_LOAD     = 92 // Percent Engine Load (PID 91 - положение педали акселератора)
+	PID_FUEL_LEVEL      = 96
+	PID_BATTERY_VOLTAGE = 168
+	PID_AMBIENT_TEMP    = 171
+	PID_TOTAL_DISTANCE  = 245
+	PID_VIN             = 237 // Переменная длина, обычно передается транспортным протоколом
+	PID_ACTIVE_DTC      = 194
+	// Запрос и ответ диагностики: описание кода или сброс счетчиков (см. clearDTCsRequest)
+	PID_DIAGNOSTIC_REQUEST  = 195 // Diagnostic Data Request/Clear Count
+	PID_DIAGNOSTIC_RESPONSE = 196 // Diagnostic Data/Clear Count Response
+	// Транспортный протокол (см. tp.go)
+	PID_TP_CONNECTION_MANAGEMENT = 197
+	PID_TP_DATA_TRANSFER         = 198
+)
@@ -0,0 +1,56 @@
+package j1587
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/serebryakov7/j1708-stats/pkg/selftest"
+)
+
+// runSelftest читает шину в течение -selftest-duration, проверяет подключение к MQTT
+// (кроме режима -stdout) и выводит отчет. Возвращает код завершения: 0 - самопроверка
+// пройдена, 1 - нет.
+func runSelftest(port io.ReadWriteCloser, framingMode string) int {
+	report := selftest.NewReport("j1587", *portName, *selftestDuration)
+
+	bus, err := NewBus(port, nil, nil)
+	if err != nil {
+		log.Printf("Самопроверка: ошибка инициализации Bus: %v", err)
+		return 1
+	}
+	bus.SetFraming(framingMode)
+	bus.SetAdapterHandshake(*adapterHandshake)
+	bus.SetPIDObserver(func(mid, pid int, data []byte) {
+		report.Observe(fmt.Sprintf("PID %d", pid))
+	})
+
+	log.Printf("Самопроверка: чтение %s в течение %v...", *portName, *selftestDuration)
+	if err := bus.StartReading(); err != nil {
+		log.Printf("Самопроверка: ошибка запуска чтения: %v", err)
+		return 1
+	}
+	// DTC в самопроверке не публикуются
+	go func() {
+		for range bus.dtcChan {
+		}
+	}()
+	time.Sleep(*selftestDuration)
+	bus.StopReading()
+	report.Frames = bus.FramesReceived()
+	report.Valid = bus.ValidFrames()
+
+	if !*stdoutMode {
+		log.Printf("Самопроверка: подключение к MQTT брокеру %s...", *mqttBroker)
+		clientID := mqttClientID("", filepath.Base(*portName)) + "-selftest"
+		report.SetMQTTResult(*mqttBroker, selftest.CheckMQTT(*mqttBroker, clientID))
+	}
+
+	report.Log()
+	if !report.Passed() {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,242 @@
+package j1587
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// DefaultLocalMID - MID, с которым агент передает сообщения на шину:
+// 172 - Off-Board Diagnostics #1 (диагностическое средство, подключенное к шине).
+const DefaultLocalMID = 172
+
+const (
+	// txGap - пауза после передачи фрейма, чтобы не занимать шину подряд.
+	txGap = 50 * time.Millisecond
+	// tpSegmentSize - число байтов сообщения в одном сегменте PID 198, при котором
+	// фрейм не превышает j1708MaxFrameLen: MID, PID, длина, получатель, номер сегмента, данные, checksum.
+	tpSegmentSize = 15
+)
+
+// Param - параметр сообщения J1587: номер PID (0-511) и данные без байта длины.
+type Param struct {
+	PID  int
+	Data []byte
+}
+
+// encodeParams кодирует параметры в последовательность блоков PID/Data, как ее
+// разбирает parsePIDBlocks: PID второй страницы передаются через escape-байт 255,
+// у PID переменной длины перед данными добавляется байт длины. Длина данных
+// PID фиксированной длины должна соответствовать SAE J1587.
+func encodeParams(params []Param) ([]byte, error) {
+	var out []byte
+	for _, prm := range params {
+		low := prm.PID % pidPageTwoOffset
+		if prm.PID < 0 || prm.PID >= 2*pidPageTwoOffset || low == pidPageTwoEscape || (low == pidDataLinkEscape && prm.PID != pidDataLinkEscape) {
+			return nil, fmt.Errorf("недопустимый PID %d", prm.PID)
+		}
+		if prm.PID >= pidPageTwoOffset {
+			out = append(out, pidPageTwoEscape, byte(low))
+		} else {
+			out = append(out, byte(prm.PID))
+		}
+		if isVariableLengthPID(prm.PID) {
+			if len(prm.Data) > 255 {
+				return nil, fmt.Errorf("PID %d: данные длиннее 255 байт (%d)", prm.PID, len(prm.Data))
+			}
+			out = append(out, byte(len(prm.Data)))
+		} else if want, _ := getPIDDataLength(prm.PID, nil, 0); len(prm.Data) != want {
+			return nil, fmt.Errorf("PID %d: ожидается %d байт данных, передано %d", prm.PID, want, len(prm.Data))
+		}
+		out = append(out, prm.Data...)
+	}
+	return out, nil
+}
+
+// SetLocalMID задает MID, с которым агент передает сообщения (по умолчанию DefaultLocalMID).
+// Фреймы с этим MID на шине считаются собственными и не разбираются. Вызывается до StartReading.
+func (p *Bus) SetLocalMID(mid byte) {
+	p.localMID = mid
+}
+
+// SendMessage передает параметры params одним сообщением J1587 от MID агента.
+// Сообщение, не помещающееся во фрейм J1708, передается транспортным протоколом
+// (PID 197/198) модулю dest, который должен разрешать передачу сегментов (CTS)
+// и подтвердить получение (EOM); для однофреймового сообщения dest не используется.
+func (p *Bus) SendMessage(dest byte, params ...Param) error {
+	message, err := encodeParams(params)
+	if err != nil {
+		return err
+	}
+	if len(message) == 0 {
+		return fmt.Errorf("сообщение без параметров")
+	}
+	if 1+len(message)+1 <= j1708MaxFrameLen {
+		return p.writeFrame(message)
+	}
+	return p.sendTP(dest, message)
+}
+
+// SendFrame отправляет фрейм из одного параметра pid с данными data от MID агента.
+// Байт длины PID переменной длины добавляется автоматически.
+func (p *Bus) SendFrame(pid int, data []byte) error {
+	message, err := encodeParams([]Param{{PID: pid, Data: data}})
+	if err != nil {
+		return err
+	}
+	return p.writeFrame(message)
+}
+
+// writeFrame передает фрейм: MID агента, блоки PID/Data body и контрольная сумма SAE J1587.
+func (p *Bus) writeFrame(body []byte) error {
+	if p.port == nil {
+		return fmt.Errorf("порт не инициализирован для отправки команды")
+	}
+	if !p.isRunning {
+		return fmt.Errorf("протокол J1587 не запущен, отправка команды невозможна")
+	}
+	frame := make([]byte, 0, len(body)+2)
+	frame = append(frame, p.localMID)
+	frame = append(frame, body...)
+	if len(frame)+1 > j1708MaxFrameLen {
+		return fmt.Errorf("фрейм длиннее %d байт: %d", j1708MaxFrameLen, len(frame)+1)
+	}
+	frame = append(frame, calculateJ1587Checksum(frame))
+
+	p.txMutex.Lock()
+	defer p.txMutex.Unlock()
+	log.Printf("J1587 SENDING FRAME: % X", frame)
+	if _, err := p.port.Write(frame); err != nil {
+		return fmt.Errorf("ошибка отправки J1587 команды: %v", err)
+	}
+	time.Sleep(txGap)
+	return nil
+}
+
+// tpReply - сообщение управления соединением (PID 197) от модуля mid, адресованное агенту.
+type tpReply struct {
+	mid  int
+	data []byte
+}
+
+// deliverTPReply передает передающему соединению sendTP сообщение управления от модуля mid,
+// если оно адресовано агенту. Вызывается из горутины разбора фреймов.
+func (p *Bus) deliverTPReply(mid int, data []byte) {
+	if len(data) < 2 || data[0] != p.localMID || mid == int(p.localMID) {
+		return
+	}
+	select {
+	case p.tpReplies <- tpReply{mid: mid, data: append([]byte(nil), data...)}:
+	default:
+		log.Printf("J1587 TP: ответ MID %d пропущен, очередь ответов заполнена", mid)
+	}
+}
+
+// sendTP передает message модулю dest транспортным протоколом J1587: объявляет
+// сообщение (RTS), передает сегменты, которые запрашивает получатель (CTS),
+// и ждет подтверждения получения (EOM). Одновременно открыто не больше одного соединения.
+func (p *Bus) sendTP(dest byte, message []byte) error {
+	segments := (len(message) + tpSegmentSize - 1) / tpSegmentSize
+	if len(message) > tpMaxSize {
+		return fmt.Errorf("сообщение длиннее %d байт: %d", tpMaxSize, len(message))
+	}
+
+	p.tpSendMutex.Lock()
+	defer p.tpSendMutex.Unlock()
+	// Ответы на предыдущее соединение, пришедшие после его завершения, не относятся к этому
+	for len(p.tpReplies) > 0 {
+		<-p.tpReplies
+	}
+
+	rts := []byte{dest, tpCMRTS, byte(segments), byte(len(message)), byte(len(message) >> 8)}
+	if err := p.SendFrame(PID_TP_CONNECTION_MANAGEMENT, rts); err != nil {
+		return err
+	}
+	log.Printf("J1587 TP: MID %d объявлено сообщение %d байт (%d сегментов)", dest, len(message), segments)
+
+	for {
+		var reply tpReply
+		select {
+		case reply = <-p.tpReplies:
+		case <-time.After(tpTimeout):
+			p.abortTP(dest)
+			return fmt.Errorf("MID %d не ответил за %v", dest, tpTimeout)
+		case <-p.stopChan:
+			return fmt.Errorf("чтение шины остановлено")
+		}
+		if reply.mid != int(dest) {
+			continue
+		}
+		switch reply.data[1] {
+		case tpCMCTS:
+			if len(reply.data) < 4 {
+				continue
+			}
+			// CTS с нулевым числом сегментов - получатель просит подождать
+			count, next := int(reply.data[2]), int(reply.data[3])
+			if next < 1 || next > segments {
+				p.abortTP(dest)
+				return fmt.Errorf("MID %d запросил сегмент %d вне диапазона 1-%d", dest, next, segments)
+			}
+			for seq := next; seq < next+count && seq <= segments; seq++ {
+				chunk := message[(seq-1)*tpSegmentSize : min(seq*tpSegmentSize, len(message))]
+				dt := append([]byte{dest, byte(seq)}, chunk...)
+				if err := p.SendFrame(PID_TP_DATA_TRANSFER, dt); err != nil {
+					return err
+				}
+			}
+		case tpCMEOM:
+			log.Printf("J1587 TP: MID %d подтвердил получение сообщения", dest)
+			return nil
+		case tpCMRSD:
+			reason := -1
+			if len(reply.data) > 2 {
+				reason = int(reply.data[2])
+			}
+			return fmt.Errorf("MID %d отказал в приеме сообщения (причина %d)", dest, reason)
+		case tpCMAbort:
+			return fmt.Errorf("MID %d прервал соединение", dest)
+		}
+	}
+}
+
+// abortTP сообщает модулю dest о прерывании соединения.
+func (p *Bus) abortTP(dest byte) {
+	if err := p.SendFrame(PID_TP_CONNECTION_MANAGEMENT, []byte{dest, tpCMAbort}); err != nil {
+		log.Printf("J1587 TP: не удалось прервать соединение с MID %d: %v", dest, err)
+	}
+}
+
+// Символ кода в запросе PID 195 и ответе PID 196: биты 8-7 задают вид запроса,
+// младшие биты - как байт описания кода PID 194 (SID, страница, FMI).
+const (
+	diagCharRequestMask = 0xC0
+	diagCharDescription = 0x00 // Запрос текстового описания кода
+	diagCharClearCode   = 0x40 // Сброс счетчика указанного кода
+	diagCharClearAll    = 0x80 // Сброс счетчиков всех кодов модуля
+)
+
+// clearDTCTimeout - время ожидания подтверждения сброса кодов (PID 196).
+const clearDTCTimeout = 2 * time.Second
+
+// clearDTCsRequest возвращает запрос сброса счетчиков всех кодов неисправностей модуля mid:
+// PID 195 (Diagnostic Data Request/Clear Count) с данными
+// [MID модуля, номер PID/SID кода (0 - не используется), символ кода 0x80].
+// Например, сброс кодов двигателя: AC C3 03 80 00 80 checksum.
+// Модуль подтверждает сброс ответом PID 196 с тем же символом кода, адресованным агенту.
+func clearDTCsRequest(mid byte) Param {
+	return Param{PID: PID_DIAGNOSTIC_REQUEST, Data: []byte{mid, 0, diagCharClearAll}}
+}
+
+// deliverDiagnosticResponse передает ClearActiveDTCs ответ PID 196 модуля mid,
+// адресованный агенту: [MID получателя, номер PID/SID кода, символ кода, текст...].
+func (p *Bus) deliverDiagnosticResponse(mid int, data []byte) {
+	if len(data) < 3 || data[0] != p.localMID {
+		return
+	}
+	select {
+	case p.diagReplies <- tpReply{mid: mid, data: append([]byte(nil), data...)}:
+	default:
+		log.Printf("J1587: ответ диагностики MID %d пропущен, очередь ответов заполнена", mid)
+	}
+}
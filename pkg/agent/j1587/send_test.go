@@ -0,0 +1,65 @@
+package j1587
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// newSendBus создает шину поверх fakePort, готовую к отправке без запуска чтения.
+func newSendBus(t *testing.T) (*Bus, *fakePort) {
+	t.Helper()
+	port := newFakePort()
+	bus, err := NewBus(port, nil, nil)
+	if err != nil {
+		t.Fatalf("NewBus: %v", err)
+	}
+	bus.isRunning = true
+	return bus, port
+}
+
+func TestClearDTCsRequestFrame(t *testing.T) {
+	bus, port := newSendBus(t)
+	if err := bus.SendMessage(128, clearDTCsRequest(128)); err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	// MID 172, PID 195 длиной 3: MID двигателя 128, номер кода 0, символ 0x80 (сброс всех кодов);
+	// контрольная сумма 256 - (172 + 195 + 3 + 128 + 0 + 128) % 256 = 0x8E
+	want := []byte{0xAC, 0xC3, 0x03, 0x80, 0x00, 0x80, 0x8E}
+	if got := port.Written(); !bytes.Equal(got, want) {
+		t.Errorf("отправлен фрейм % X, ожидается % X", got, want)
+	}
+}
+
+func TestClearActiveDTCsConfirmed(t *testing.T) {
+	bus, port := newSendBus(t)
+	errCh := make(chan error, 1)
+	go func() { errCh <- bus.ClearActiveDTCs(128) }()
+
+	deadline := time.Now().Add(time.Second)
+	for len(port.Written()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("запрос сброса не отправлен")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// Ответ другого модуля и ответ на запрос описания кода сброс не подтверждают
+	bus.parseFrame(withChecksum([]byte{0x8E, 0xC4, 0x03, 0xAC, 0x00, 0x80}))
+	bus.parseFrame(withChecksum([]byte{0x80, 0xC4, 0x03, 0xAC, 0x6E, 0x03}))
+	select {
+	case err := <-errCh:
+		t.Fatalf("ClearActiveDTCs завершился до подтверждения: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// MID 128, PID 196 для MID 172 с символом кода 0x80 - сброс выполнен
+	bus.parseFrame(withChecksum([]byte{0x80, 0xC4, 0x03, 0xAC, 0x00, 0x80}))
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Errorf("ClearActiveDTCs: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("ClearActiveDTCs не завершился после подтверждения")
+	}
+}
@@ -0,0 +1,195 @@
+package j1587
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tarm/serial"
+
+	"github.com/serebryakov7/j1708-stats/common"
+)
+
+// serialReadTimeout - таймаут чтения порта; по нему фрейм завершается паузой (см. readFrames).
+const serialReadTimeout = 100 * time.Millisecond
+
+// parseParity разбирает значение -parity: none, odd, even, mark, space
+// или первую букву названия (N, O, E, M, S).
+func parseParity(s string) (serial.Parity, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "none", "n":
+		return serial.ParityNone, nil
+	case "odd", "o":
+		return serial.ParityOdd, nil
+	case "even", "e":
+		return serial.ParityEven, nil
+	case "mark", "m":
+		return serial.ParityMark, nil
+	case "space", "s":
+		return serial.ParitySpace, nil
+	default:
+		return 0, fmt.Errorf("некорректная четность %q (ожидается none, odd, even, mark или space)", s)
+	}
+}
+
+// parseStopBits разбирает значение -stopbits: 1, 1.5 или 2.
+func parseStopBits(s string) (serial.StopBits, error) {
+	switch strings.TrimSpace(s) {
+	case "1":
+		return serial.Stop1, nil
+	case "1.5":
+		return serial.Stop1Half, nil
+	case "2":
+		return serial.Stop2, nil
+	default:
+		return 0, fmt.Errorf("некорректное число стоповых битов %q (ожидается 1, 1.5 или 2)", s)
+	}
+}
+
+// newSerialConfig формирует настройки порта из флагов -port, -parity, -databits и -stopbits
+// со скоростью baud.
+func newSerialConfig(baud int) (*serial.Config, error) {
+	parity, err := parseParity(*serialParity)
+	if err != nil {
+		return nil, err
+	}
+	stopBits, err := parseStopBits(*serialStopBits)
+	if err != nil {
+		return nil, err
+	}
+	if *serialDataBits < 5 || *serialDataBits > 8 {
+		return nil, fmt.Errorf("некорректное число битов данных %d (ожидается от 5 до 8)", *serialDataBits)
+	}
+	return &serial.Config{
+		Name:        *portName,
+		Baud:        baud,
+		Size:        byte(*serialDataBits),
+		Parity:      parity,
+		StopBits:    stopBits,
+		ReadTimeout: serialReadTimeout,
+	}, nil
+}
+
+// openSerialPort открывает порт и классифицирует ошибку открытия:
+// common.ErrPortNotFound, common.ErrPermissionDenied или common.ErrPortUnavailable.
+func openSerialPort(config *serial.Config) (*serial.Port, error) {
+	port, err := serial.OpenPort(config)
+	switch {
+	case err == nil:
+		return port, nil
+	case errors.Is(err, os.ErrNotExist):
+		return nil, fmt.Errorf("%w: %s: %w", common.ErrPortNotFound, config.Name, err)
+	case errors.Is(err, os.ErrPermission):
+		// Ошибка уже соответствует common.ErrPermissionDenied
+		return nil, fmt.Errorf("нет доступа к порту %s: %w", config.Name, err)
+	default:
+		return nil, fmt.Errorf("%w: %s: %w", common.ErrPortUnavailable, config.Name, err)
+	}
+}
+
+// isTransientPortError сообщает, может ли ошибка открытия порта исчезнуть сама:
+// при загрузке адаптер может еще не появиться, а права на него - еще не быть выданы udev.
+func isTransientPortError(err error) bool {
+	return errors.Is(err, common.ErrPortNotFound) ||
+		errors.Is(err, common.ErrPortUnavailable) ||
+		errors.Is(err, common.ErrPermissionDenied)
+}
+
+// portErrorHint возвращает подсказку по устранению ошибки открытия или чтения порта.
+func portErrorHint(err error) string {
+	switch {
+	case errors.Is(err, common.ErrPortNotFound):
+		return "проверьте подключение адаптера и параметр -port"
+	case errors.Is(err, common.ErrPermissionDenied):
+		return "добавьте пользователя агента в группу dialout или запустите агент с правами на порт"
+	case errors.Is(err, common.ErrPortUnavailable):
+		return "порт может быть занят другим процессом, например уже запущенным агентом"
+	case errors.Is(err, common.ErrBusSilent):
+		return "проверьте, что зажигание включено и адаптер подключен к шине"
+	default:
+		return "проверьте параметры порта -baud, -parity, -databits и -stopbits"
+	}
+}
+
+// baudAuto - значение -baud для автоматического определения скорости.
+const baudAuto = "auto"
+
+// probeBauds - скорости, перебираемые при -baud auto: стандартная скорость J1708
+// и скорости быстрых USB-адаптеров.
+var probeBauds = []int{9600, 19200, 115200}
+
+// baudProbeDuration - время чтения порта на каждой скорости при -baud auto.
+const baudProbeDuration = 3 * time.Second
+
+// parseBaud разбирает значение -baud: скорость в бодах или auto (возвращается 0).
+func parseBaud(s string) (int, error) {
+	if strings.EqualFold(strings.TrimSpace(s), baudAuto) {
+		return 0, nil
+	}
+	baud, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || baud <= 0 {
+		return 0, fmt.Errorf("некорректная скорость %q (ожидается число бод или %s)", s, baudAuto)
+	}
+	return baud, nil
+}
+
+// detectBaud поочередно открывает порт на скоростях probeBauds, читает его
+// в течение baudProbeDuration и возвращает скорость, на которой принято больше всего
+// фреймов с верной контрольной суммой и правдоподобной структурой (см. checksumFramer).
+// Возвращает ошибку, если ни на одной скорости фреймы не приняты: common.ErrBusSilent,
+// если не принято ни одного байта.
+func detectBaud() (int, error) {
+	best, bestFrames, totalBytes := 0, 0, 0
+	for _, baud := range probeBauds {
+		frames, n, err := countFramesAt(baud, baudProbeDuration)
+		if err != nil {
+			return 0, err
+		}
+		log.Printf("Определение скорости: %d бод - %d корректных фреймов", baud, frames)
+		totalBytes += n
+		if frames > bestFrames {
+			best, bestFrames = baud, frames
+		}
+	}
+	if totalBytes == 0 {
+		return 0, fmt.Errorf("%w: ни на одной из скоростей %v не принято ни одного байта", common.ErrBusSilent, probeBauds)
+	}
+	if bestFrames == 0 {
+		return 0, fmt.Errorf("ни на одной из скоростей %v не принято корректных фреймов", probeBauds)
+	}
+	return best, nil
+}
+
+// countFramesAt считает фреймы и байты, принятые на скорости baud за время duration.
+func countFramesAt(baud int, duration time.Duration) (frames, bytes int, err error) {
+	config, err := newSerialConfig(baud)
+	if err != nil {
+		return 0, 0, err
+	}
+	port, err := openSerialPort(config)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer port.Close()
+
+	buf := make([]byte, 128)
+	var framer checksumFramer
+	for deadline := time.Now().Add(duration); time.Now().Before(deadline); {
+		n, err := port.Read(buf)
+		if err != nil && err != io.EOF {
+			return 0, 0, fmt.Errorf("ошибка чтения порта на скорости %d: %w", baud, err)
+		}
+		bytes += n
+		for _, b := range buf[:n] {
+			if framer.feed(b) != nil {
+				frames++
+			}
+		}
+	}
+	return frames, bytes, nil
+}
@@ -0,0 +1,112 @@
+package j1587
+
+import (
+	"log"
+	"time"
+)
+
+// Транспортный протокол J1587 для сообщений, не помещающихся в один фрейм J1708 (например, VIN).
+// Сообщение передается сегментами через PID 198 после объявления RTS в PID 197;
+// собранные данные - это обычная последовательность блоков PID/Data.
+const (
+	tpCMRTS   = 1   // Request To Send: число сегментов и общий размер сообщения
+	tpCMCTS   = 2   // Clear To Send
+	tpCMEOM   = 3   // End Of Message
+	tpCMRSD   = 4   // Reason for Service Denial - отказ получателя
+	tpCMAbort = 255 // Connection Abort
+
+	tpMaxSize = 3825            // Максимальный размер сообщения: 255 сегментов по 15 байт
+	tpTimeout = 5 * time.Second // Таймаут между фреймами одного соединения
+)
+
+// tpSession - состояние сборки одного многосегментного сообщения.
+type tpSession struct {
+	size     int
+	segments [][]byte // Индекс - номер сегмента минус 1; nil - сегмент еще не получен
+	received int
+	lastSeen time.Time
+}
+
+// tpReassembler пассивно собирает сообщения транспортного протокола J1587, наблюдая за шиной.
+// Сессии различаются парой MID отправителя и получателя.
+type tpReassembler struct {
+	sessions map[uint16]*tpSession
+}
+
+func newTPReassembler() *tpReassembler {
+	return &tpReassembler{sessions: make(map[uint16]*tpSession)}
+}
+
+// handleCM обрабатывает данные PID 197 (управление соединением) от модуля mid.
+func (r *tpReassembler) handleCM(mid int, data []byte, now time.Time) {
+	if len(data) < 2 {
+		return
+	}
+	key := uint16(mid)<<8 | uint16(data[0])
+	switch data[1] {
+	case tpCMRTS:
+		if len(data) < 5 {
+			return
+		}
+		segments := int(data[2])
+		size := int(data[3]) | int(data[4])<<8
+		if segments == 0 || size == 0 || size > tpMaxSize {
+			log.Printf("J1587 TP: некорректное объявление от MID %d: сегментов %d, размер %d", mid, segments, size)
+			delete(r.sessions, key)
+			return
+		}
+		r.sessions[key] = &tpSession{
+			size:     size,
+			segments: make([][]byte, segments),
+			lastSeen: now,
+		}
+	case tpCMRSD, tpCMAbort:
+		delete(r.sessions, key)
+	}
+	// CTS и EOM на сборку не влияют: сообщение считается полученным,
+	// когда приняты все объявленные сегменты.
+}
+
+// handleDT обрабатывает данные PID 198 (передача сегмента) от модуля mid.
+// Возвращает собранное сообщение и true, когда получены все сегменты.
+func (r *tpReassembler) handleDT(mid int, data []byte, now time.Time) ([]byte, bool) {
+	if len(data) < 3 {
+		return nil, false
+	}
+	key := uint16(mid)<<8 | uint16(data[0])
+	session, ok := r.sessions[key]
+	if !ok {
+		return nil, false
+	}
+	if now.Sub(session.lastSeen) > tpTimeout {
+		log.Printf("J1587 TP: сессия от MID %d к MID %d прервана по таймауту", mid, data[0])
+		delete(r.sessions, key)
+		return nil, false
+	}
+	session.lastSeen = now
+
+	seq := int(data[1])
+	if seq < 1 || seq > len(session.segments) {
+		log.Printf("J1587 TP: сегмент %d от MID %d вне диапазона 1-%d", seq, mid, len(session.segments))
+		return nil, false
+	}
+	// Повторно переданный сегмент (после CTS) заменяет ранее полученный
+	if session.segments[seq-1] == nil {
+		session.received++
+	}
+	session.segments[seq-1] = append([]byte(nil), data[2:]...)
+	if session.received < len(session.segments) {
+		return nil, false
+	}
+
+	delete(r.sessions, key)
+	message := make([]byte, 0, session.size)
+	for _, segment := range session.segments {
+		message = append(message, segment...)
+	}
+	if len(message) < session.size {
+		log.Printf("J1587 TP: сообщение от MID %d короче объявленного: %d из %d байт", mid, len(message), session.size)
+		return nil, false
+	}
+	return message[:session.size], true
+}
@@ -0,0 +1,118 @@
+package j1939
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// DefaultAckTimeout - время ожидания подтверждения команды (T3 по J1939-21).
+const DefaultAckTimeout = 1250 * time.Millisecond
+
+// Управляющий байт подтверждения (PGN 0xE800, байт 1).
+const (
+	ackPositive      = 0 // ACK
+	ackNegative      = 1 // NACK
+	ackAccessDenied  = 2 // Access Denied
+	ackCannotRespond = 3 // Cannot Respond
+)
+
+// errNoAck - блок не подтвердил команду за время ожидания.
+var errNoAck = errors.New("нет подтверждения команды")
+
+// errNACK - блок отклонил команду (NACK, Access Denied или Cannot Respond).
+var errNACK = errors.New("блок отклонил команду")
+
+// ackReply - подтверждение от адреса sa с управляющим байтом control.
+type ackReply struct {
+	sa      uint8
+	control uint8
+}
+
+// ackWaiter ожидает подтверждения PGN pgn от адреса from.
+type ackWaiter struct {
+	pgn  uint32
+	from uint8
+	ch   chan ackReply
+}
+
+// SetAckTimeout задает время ожидания подтверждения команд (по умолчанию DefaultAckTimeout).
+func (p *Bus) SetAckTimeout(timeout time.Duration) {
+	p.ackTimeout = timeout
+}
+
+// handleAck передает подтверждение (PGN 0xE800) ожидающим его командам.
+// Данные: управляющий байт, значение групповой функции, 2 байта резерва,
+// адрес узла, которому адресовано подтверждение, и подтверждаемый PGN (3 байта, младший первым).
+func (p *Bus) handleAck(frame J1939FrameInfo) {
+	data := frame.Data
+	if len(data) < 8 {
+		return
+	}
+	// До J1939-21 (2006) байт 5 резервировался и равен 0xFF
+	if addr := data[4]; addr != p.LocalSA() && addr != 0xFF {
+		return
+	}
+	pgn := uint32(data[5]) | uint32(data[6])<<8 | uint32(data[7])<<16
+
+	p.ackMutex.Lock()
+	defer p.ackMutex.Unlock()
+	for w := range p.ackWaiters {
+		if w.pgn == pgn && w.from == frame.SA {
+			select {
+			case w.ch <- ackReply{sa: frame.SA, control: data[0]}:
+			default:
+			}
+		}
+	}
+}
+
+// sendAwaitingAck вызывает send и ждет подтверждения PGN ackPGN от адреса destAddr.
+// Глобальные команды (destAddr 0xFF) по J1939-21 не подтверждаются, для них ожидания нет.
+func (p *Bus) sendAwaitingAck(ackPGN uint32, destAddr uint8, send func() error) error {
+	if destAddr == 0xFF {
+		return send()
+	}
+	w := &ackWaiter{pgn: ackPGN, from: destAddr, ch: make(chan ackReply, 1)}
+	p.ackMutex.Lock()
+	p.ackWaiters[w] = struct{}{}
+	p.ackMutex.Unlock()
+	defer func() {
+		p.ackMutex.Lock()
+		delete(p.ackWaiters, w)
+		p.ackMutex.Unlock()
+	}()
+
+	if err := send(); err != nil {
+		return err
+	}
+	select {
+	case reply := <-w.ch:
+		switch reply.control {
+		case ackPositive:
+			log.Printf("SA 0x%02X подтвердил PGN 0x%X (ACK)", reply.sa, ackPGN)
+			return nil
+		case ackNegative:
+			return fmt.Errorf("%w: SA 0x%02X, PGN 0x%X: NACK", errNACK, reply.sa, ackPGN)
+		case ackAccessDenied:
+			return fmt.Errorf("%w: SA 0x%02X, PGN 0x%X: доступ запрещен", errNACK, reply.sa, ackPGN)
+		case ackCannotRespond:
+			return fmt.Errorf("%w: SA 0x%02X, PGN 0x%X: блок не может ответить", errNACK, reply.sa, ackPGN)
+		default:
+			return fmt.Errorf("%w: SA 0x%02X, PGN 0x%X: неизвестный ответ %d", errNACK, reply.sa, ackPGN, reply.control)
+		}
+	case <-time.After(p.ackTimeout):
+		return fmt.Errorf("%w: SA 0x%02X, PGN 0x%X, ожидание %v", errNoAck, destAddr, ackPGN, p.ackTimeout)
+	case <-p.stopChan:
+		return fmt.Errorf("обработка J1939 остановлена")
+	}
+}
+
+// ClearActiveDTCs сбрасывает активные коды неисправностей блока destAddr (DM11):
+// запрашивает PGN 0xFED3 и ждет подтверждения. Глобальный сброс (0xFF) не подтверждается.
+func (p *Bus) ClearActiveDTCs(destAddr uint8) error {
+	return p.sendAwaitingAck(pgnDM11, destAddr, func() error {
+		return p.RequestPGN(pgnDM11, destAddr)
+	})
+}
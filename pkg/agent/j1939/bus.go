@@ -0,0 +1,444 @@
+package j1939
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time" // Добавлен импорт time
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/serebryakov7/j1708-stats/common"
+	"github.com/serebryakov7/j1708-stats/pkg/logging"
+)
+
+// Режимы работы с CAN-интерфейсом.
+const (
+	canModeJ1939 = "j1939" // Сокет CAN_J1939: адресация и TP выполняются ядром
+	canModeRaw   = "raw"   // Сокет CAN_RAW: разбор CAN ID и TP выполняются агентом
+	canModeAuto  = "auto"  // CAN_J1939, а при его недоступности - CAN_RAW
+)
+
+// J1939FrameInfo содержит информацию о кадре J1939.
+type J1939FrameInfo struct {
+	PGN  uint32
+	SA   uint8
+	Data []byte
+	// Timestamp - время приема кадра (метка ядра или драйвера, если доступна).
+	Timestamp time.Time
+	// CANID - 29-битный идентификатор кадра, если он известен (HasCANID): только в режиме
+	// CAN_RAW и не для сообщений, собранных из TP. Сокет CAN_J1939 передает PGN и адрес
+	// источника в SockaddrCANJ1939, приоритет и адрес назначения PDU1 теряются.
+	CANID    uint32
+	HasCANID bool
+}
+
+// IDComponents возвращает составляющие CAN ID кадра: полные в режиме CAN_RAW,
+// иначе - известные по PGN и адресу источника.
+func (f J1939FrameInfo) IDComponents() common.CANID {
+	if f.HasCANID {
+		return common.ParseCANID(f.CANID)
+	}
+	return common.CANIDFromPGN(f.PGN, f.SA)
+}
+
+// dropLogInterval - не чаще этого интервала выводится сообщение о кадрах,
+// пропущенных из-за переполнения очереди обработки.
+const dropLogInterval = time.Second
+
+// errRecvTimeout - за время ожидания (-recv-timeout) из сокета ничего не принято.
+// Чтение повторяется после проверки сигнала остановки.
+var errRecvTimeout = errors.New("время ожидания приема истекло")
+
+// frameSource - источник и приемник кадров J1939.
+// Реализация для SocketCAN находится в socketcan.go, в тестах ее можно заменить.
+type frameSource interface {
+	// Recv блокируется до получения кадра. По истечении времени ожидания возвращает
+	// errRecvTimeout, после Close - ошибку, удовлетворяющую errors.Is(err, net.ErrClosed).
+	Recv() (J1939FrameInfo, error)
+	// Send отправляет сообщение (до MaxPayload байт) с PGN на адрес destAddr.
+	Send(pgn uint32, data []byte, destAddr uint8) error
+	// MaxPayload возвращает максимальный размер отправляемого сообщения:
+	// 8 байт для одиночного кадра или больше, если источник выполняет TP.
+	MaxPayload() int
+	// LocalSA возвращает адрес источника агента на шине.
+	LocalSA() uint8
+	Close() error
+}
+
+// Bus реализует логику для протокола J1939
+type Bus struct {
+	source   frameSource
+	data     *J1939Data
+	framesCh chan J1939FrameInfo
+	stopChan chan struct{}
+	dtcChan  chan common.DTCCode
+	// dtcReports - отчеты DTC по блокам, если включена группировка (EnableDTCGrouping).
+	dtcReports       chan common.DTCReport
+	canInterfaceName string
+	frameProcessor   *FrameProcessor
+	// recvTimeout - время ожидания приема из сокетов; 0 - без ограничения,
+	// тогда чтение прерывается только закрытием сокета в Stop.
+	recvTimeout time.Duration
+	// readers - горутины чтения сокетов; Stop дожидается их перед закрытием сокетов.
+	readers sync.WaitGroup
+	// framesReceived - число кадров, принятых из источника.
+	framesReceived atomic.Uint64
+	// framesDropped - число кадров, пропущенных из-за переполнения framesCh;
+	// dropLog ограничивает частоту сообщений о них.
+	framesDropped atomic.Uint64
+	dropLog       *logging.Limiter
+	// health - состояние контроллера по кадрам ошибок, отслеживается после MonitorBusHealth
+	// (healthMonitored); errorSource - источник кадров ошибок, nil до MonitorBusHealth.
+	health          *busHealth
+	healthMonitored atomic.Bool
+	errorSource     errorFrameSource
+	// frameObserver, если задан, вызывается для каждого обрабатываемого кадра (см. SetFrameObserver).
+	frameObserver func(frame J1939FrameInfo)
+	// ackWaiters - команды, ожидающие подтверждения (см. sendAwaitingAck).
+	ackMutex   sync.Mutex
+	ackWaiters map[*ackWaiter]struct{}
+	ackTimeout time.Duration
+	// readinessSA - адрес блока OBD, у которого запрашивается DM5;
+	// readinessInterval - период повторного запроса (0 - только при запуске).
+	readinessSA       uint8
+	readinessInterval time.Duration
+}
+
+// NewBus создает новый экземпляр Bus.
+// Открывает сокет SocketCAN в выбранном режиме (см. canModeJ1939, canModeRaw, canModeAuto)
+// с временем ожидания приема recvTimeout. Принимает *bolt.DB для передачи в FrameProcessor.
+func NewBus(canInterface string, canMode string, recvTimeout time.Duration, db *bolt.DB) (*Bus, error) { // Добавлен параметр db
+	source, err := openSocketCAN(canInterface, canMode, recvTimeout)
+	if err != nil {
+		return nil, err
+	}
+	p := newBusWithSource(source, db)
+	p.canInterfaceName = canInterface
+	p.recvTimeout = recvTimeout
+	return p, nil
+}
+
+// newBusWithSource создает Bus поверх произвольного источника кадров.
+func newBusWithSource(source frameSource, db *bolt.DB) *Bus {
+	p := &Bus{
+		source:   source,
+		data:     NewJ1939Data(),
+		framesCh: make(chan J1939FrameInfo, 100), // Буферизированный канал для кадров
+		dtcChan:  make(chan common.DTCCode, 10),  // Буферизированный канал для DTC
+		stopChan: make(chan struct{}),
+
+		dtcReports: make(chan common.DTCReport, 10),
+		health:     newBusHealth(time.Now()),
+		dropLog:    logging.NewLimiter(dropLogInterval),
+		ackWaiters: make(map[*ackWaiter]struct{}),
+		ackTimeout: DefaultAckTimeout,
+	}
+	// Передаем db в NewFrameProcessor
+	p.frameProcessor = NewFrameProcessor(p.data, p.dtcChan, db) // Изменено: передаем db
+	p.frameProcessor.SetPGNRequester(p.RequestPGN)
+	return p
+}
+
+// Start запускает горутины для чтения и обработки кадров.
+func (p *Bus) Start() {
+	log.Println("Запуск протокола J1939...")
+	p.startReader(p.readFrames)
+	go p.processFrames()
+	log.Println("Протокол J1939 запущен.")
+
+	// DM5 передается только по запросу; готовность мониторов меняется за поездку,
+	// поэтому запрос повторяется с интервалом readinessInterval
+	p.requestReadiness()
+	if p.readinessInterval > 0 {
+		go p.pollReadiness()
+	}
+	// VIN многие блоки тоже передают только по запросу
+	if err := p.RequestPGN(pgnVI, 0xFF); err != nil {
+		log.Printf("Ошибка запроса VIN: %v", err)
+	}
+}
+
+// SetReadinessRequest задает адрес блока OBD, у которого запрашивается и от которого
+// принимается DM5, и период повторного запроса (0 - только при запуске). Вызывается до Start.
+func (p *Bus) SetReadinessRequest(sa uint8, interval time.Duration) {
+	p.readinessSA = sa
+	p.readinessInterval = interval
+	p.frameProcessor.SetReadinessSource(sa)
+}
+
+// requestReadiness запрашивает DM5 у блока readinessSA.
+func (p *Bus) requestReadiness() {
+	if err := p.RequestPGN(pgnDM5, p.readinessSA); err != nil {
+		log.Printf("Ошибка запроса DM5: %v", err)
+	}
+}
+
+// pollReadiness повторяет запрос DM5 с интервалом readinessInterval до остановки шины.
+func (p *Bus) pollReadiness() {
+	ticker := time.NewTicker(p.readinessInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stopChan:
+			return
+		case <-ticker.C:
+			p.requestReadiness()
+		}
+	}
+}
+
+// Stop останавливает обработку J1939 и закрывает ресурсы.
+func (p *Bus) Stop() error {
+	log.Println("Остановка протокола J1939...")
+
+	if p.stopChan != nil {
+		select {
+		case <-p.stopChan:
+			log.Println("Stop: stopChan уже был закрыт.")
+		default:
+			close(p.stopChan)
+			log.Println("Stop: stopChan закрыт.")
+		}
+	} else {
+		log.Println("Предупреждение: Stop() вызван, когда stopChan уже nil.")
+	}
+
+	p.waitReaders()
+
+	if err := p.source.Close(); err != nil {
+		if errors.Is(err, net.ErrClosed) {
+			log.Println("J1939 сокет уже был закрыт.")
+		} else {
+			log.Printf("Ошибка при закрытии J1939 сокета: %v", err)
+		}
+	} else {
+		log.Println("J1939 сокет успешно закрыт.")
+	}
+
+	if p.errorSource != nil {
+		if err := p.errorSource.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			log.Printf("Ошибка при закрытии сокета кадров ошибок CAN: %v", err)
+		}
+	}
+
+	log.Println("Протокол J1939 остановлен.")
+	return nil
+}
+
+// startReader запускает горутину чтения сокета, завершения которой дожидается Stop.
+func (p *Bus) startReader(read func()) {
+	p.readers.Add(1)
+	go func() {
+		defer p.readers.Done()
+		read()
+	}()
+}
+
+// waitReaders ждет, пока горутины чтения заметят сигнал остановки: при заданном
+// времени ожидания приема они проверяют его не реже раза в recvTimeout.
+// Без времени ожидания чтение прерывается закрытием сокетов, поэтому не ждет.
+func (p *Bus) waitReaders() {
+	if p.recvTimeout <= 0 {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		p.readers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2*p.recvTimeout + time.Second):
+		log.Println("Горутины чтения J1939 не завершились вовремя, сокеты закрываются принудительно.")
+	}
+}
+
+// GetData возвращает текущие данные J1939.
+func (p *Bus) GetData() json.Marshaler {
+	return p.data.Copy() // Используем метод Copy() для безопасного доступа
+}
+
+// LocalSA возвращает адрес источника агента на шине J1939.
+func (p *Bus) LocalSA() uint8 {
+	return p.source.LocalSA()
+}
+
+// FramesReceived возвращает число кадров, принятых с шины с момента запуска.
+func (p *Bus) FramesReceived() uint64 {
+	return p.framesReceived.Load()
+}
+
+// FramesDropped возвращает число кадров, пропущенных из-за переполнения очереди обработки.
+func (p *Bus) FramesDropped() uint64 {
+	return p.framesDropped.Load()
+}
+
+// SetFrameObserver задает функцию, вызываемую для каждого обрабатываемого кадра,
+// например для самопроверки. Вызывается до запуска обработки кадров.
+func (p *Bus) SetFrameObserver(observe func(frame J1939FrameInfo)) {
+	p.frameObserver = observe
+}
+
+// GetDTCChannel возвращает канал для получения DTC.
+func (p *Bus) GetDTCChannel() <-chan common.DTCCode {
+	return p.dtcChan
+}
+
+// EnableDTCGrouping включает группировку DM1/DM2: коды каждого сообщения передаются
+// одним отчетом в GetDTCReportChannel вместо отдельных DTC. Вызывается до Start.
+func (p *Bus) EnableDTCGrouping() {
+	p.frameProcessor.SetDTCGrouping(p.dtcReports)
+}
+
+// GetDTCReportChannel возвращает канал отчетов DTC (см. EnableDTCGrouping).
+func (p *Bus) GetDTCReportChannel() <-chan common.DTCReport {
+	return p.dtcReports
+}
+
+// processFrames обрабатывает кадры из framesCh.
+func (p *Bus) processFrames() {
+	log.Println("Горутина обработки кадров J1939 запущена.")
+	defer func() {
+		log.Println("Горутина обработки кадров J1939 остановлена.")
+		close(p.dtcChan) // Закрываем dtcChan, когда обработка кадров завершена
+		close(p.dtcReports)
+	}()
+
+	for {
+		select {
+		case frame, ok := <-p.framesCh:
+			if !ok {
+				log.Println("Канал кадров J1939 закрыт, выход из горутины обработки.")
+				return
+			}
+			// log.Printf("Обработка кадра: PGN=0x%X, SA=0x%X, DataLen=%d", frame.PGN, frame.SA, len(frame.Data))
+			if p.frameObserver != nil {
+				p.frameObserver(frame)
+			}
+			if frame.PGN == pgnACK {
+				p.handleAck(frame)
+			}
+			if p.frameProcessor.dtcCANID {
+				p.frameProcessor.frameID = frame.IDComponents()
+			}
+			p.frameProcessor.ProcessFrame(frame.PGN, frame.SA, frame.Data, frame.Timestamp)
+		case <-p.stopChan:
+			log.Println("Получен сигнал остановки в горутине обработки кадров J1939.")
+			return
+		}
+	}
+}
+
+// SendPGN отправляет сообщение с PGN на адрес destAddr (0xFF - всем).
+// Сообщения длиннее 8 байт передаются через TP, если его поддерживает источник (режим CAN_J1939).
+func (p *Bus) SendPGN(pgn uint32, data []byte, destAddr uint8) error {
+	if maxLen := p.source.MaxPayload(); len(data) > maxLen {
+		return fmt.Errorf("длина данных PGN 0x%X (%d байт) превышает %d байт, допустимые в режиме сокета", pgn, len(data), maxLen)
+	}
+	logging.Debugf("Отправка PGN 0x%X: SA=0x%X, DA=0x%X, Data=% X", pgn, p.LocalSA(), destAddr, data)
+	return p.source.Send(pgn, data, destAddr)
+}
+
+// SendCommand отправляет команду J1939 и ждет ее подтверждения (PGN 0xE800) от destAddr
+// не дольше времени, заданного SetAckTimeout. Возвращает ошибку, если блок ответил NACK
+// или не ответил; команда на адрес 0xFF подтверждения не ждет.
+func (p *Bus) SendCommand(pgn uint32, data []byte, destAddr uint8) error {
+	log.Printf("Отправка J1939 команды: PGN=0x%X (%d), SA=0x%X, DA=0x%X, Data=%X", pgn, pgn, p.LocalSA(), destAddr, data)
+	return p.sendAwaitingAck(pgn, destAddr, func() error {
+		return p.SendPGN(pgn, data, destAddr)
+	})
+}
+
+// StartBroadcast периодически, с интервалом interval, отправляет PGN на адрес destAddr
+// до остановки шины. payload вызывается перед каждой отправкой и может вернуть
+// вычисленные значения; ok = false пропускает отправку (например, если данных еще нет).
+func (p *Bus) StartBroadcast(pgn uint32, interval time.Duration, destAddr uint8, payload func() (data []byte, ok bool)) {
+	log.Printf("Периодическая отправка PGN 0x%X на DA 0x%X с интервалом %v", pgn, destAddr, interval)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stopChan:
+				return
+			case <-ticker.C:
+				data, ok := payload()
+				if !ok {
+					continue
+				}
+				if err := p.SendPGN(pgn, data, destAddr); err != nil {
+					log.Printf("Ошибка периодической отправки PGN 0x%X: %v", pgn, err)
+				}
+			}
+		}
+	}()
+}
+
+// RequestPGN отправляет запрос PGN (Request PGN 0xEA00) на указанный адрес.
+// Ответ (в том числе многопакетный через TP) принимается обычным путем в readFrames.
+func (p *Bus) RequestPGN(pgn uint32, destAddr uint8) error {
+	// Запрашиваемый PGN передается в 3 байтах, младший байт первым
+	data := []byte{byte(pgn), byte(pgn >> 8), byte(pgn >> 16)}
+	return p.SendPGN(pgnRQST, data, destAddr)
+}
+
+// readFrames читает кадры из источника J1939.
+func (p *Bus) readFrames() {
+	log.Println("Горутина чтения кадров J1939 запущена.")
+	defer func() {
+		log.Println("Горутина чтения кадров J1939 остановлена.")
+		close(p.framesCh) // Закрываем framesCh, когда чтение завершено
+	}()
+
+	for {
+		select {
+		case <-p.stopChan:
+			log.Println("Получен сигнал остановки в горутине чтения кадров J1939.")
+			return
+		default:
+			// Recv возвращается не реже раза в recvTimeout, чтобы проверить stopChan;
+			// без времени ожидания для завершения Stop() закрывает источник.
+			frameInfo, err := p.source.Recv()
+			if errors.Is(err, errRecvTimeout) {
+				continue
+			}
+			if err != nil {
+				select {
+				case <-p.stopChan: // Если stopChan закрыт, это ожидаемое завершение
+					log.Println("Recv завершился из-за закрытия stopChan (вероятно, сокет был закрыт).")
+					return
+				default:
+					if errors.Is(err, net.ErrClosed) {
+						log.Println("Recv: сокет был закрыт, выход из горутины чтения.")
+						return
+					}
+					log.Printf("Ошибка чтения из сокета J1939: %v. Продолжение работы...", err)
+					// Можно добавить небольшую задержку перед повторной попыткой, чтобы избежать слишком частого логирования ошибок
+					time.Sleep(100 * time.Millisecond)
+					continue
+				}
+			}
+
+			p.framesReceived.Add(1)
+
+			// Отправляем в канал для обработки, но не блокируемся, если канал полон
+			select {
+			case p.framesCh <- frameInfo:
+				// Успешно отправлено
+			case <-p.stopChan:
+				log.Println("Получен сигнал остановки при попытке отправить кадр в framesCh.")
+				return
+			default:
+				total := p.framesDropped.Add(1)
+				if count, ok := p.dropLog.Allow(time.Now()); ok {
+					log.Printf("Канал framesCh полон: пропущено кадров: %d (последний PGN 0x%X от SA 0x%X), всего: %d", count, frameInfo.PGN, frameInfo.SA, total)
+				}
+			}
+		}
+	}
+}
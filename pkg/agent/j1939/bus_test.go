@@ -0,0 +1,291 @@
+package j1939
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/serebryakov7/j1708-stats/common"
+	"github.com/serebryakov7/j1708-stats/pkg/storage"
+)
+
+// mockSource - источник кадров для тестов Bus: принятые кадры передаются в frames,
+// отправленные сообщения сохраняются в sent.
+type mockSource struct {
+	frames    chan J1939FrameInfo
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	mu   sync.Mutex
+	sent []sentPGN
+}
+
+// sentPGN - сообщение, отправленное через mockSource.
+type sentPGN struct {
+	PGN      uint32
+	Data     []byte
+	DestAddr uint8
+}
+
+// mockRecvTimeout - время ожидания приема mockSource, аналог -recv-timeout.
+const mockRecvTimeout = 10 * time.Millisecond
+
+func newMockSource() *mockSource {
+	return &mockSource{
+		frames: make(chan J1939FrameInfo, 16),
+		closed: make(chan struct{}),
+	}
+}
+
+func (m *mockSource) Recv() (J1939FrameInfo, error) {
+	select {
+	case frame := <-m.frames:
+		return frame, nil
+	case <-m.closed:
+		return J1939FrameInfo{}, net.ErrClosed
+	case <-time.After(mockRecvTimeout):
+		return J1939FrameInfo{}, errRecvTimeout
+	}
+}
+
+func (m *mockSource) Send(pgn uint32, data []byte, destAddr uint8) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, sentPGN{PGN: pgn, Data: append([]byte(nil), data...), DestAddr: destAddr})
+	return nil
+}
+
+func (m *mockSource) MaxPayload() int { return 8 }
+
+func (m *mockSource) LocalSA() uint8 { return 0xF9 }
+
+func (m *mockSource) Close() error {
+	m.closeOnce.Do(func() { close(m.closed) })
+	return nil
+}
+
+// Sent возвращает копию списка отправленных сообщений.
+func (m *mockSource) Sent() []sentPGN {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]sentPGN(nil), m.sent...)
+}
+
+// receive передает кадр шине, как если бы он был принят из сокета.
+func (m *mockSource) receive(pgn uint32, sa uint8, data []byte) {
+	m.frames <- J1939FrameInfo{PGN: pgn, SA: sa, Data: data, Timestamp: time.Now()}
+}
+
+// newTestBus создает шину поверх mockSource без базы; Stop вызывается по завершении теста.
+func newTestBus(t *testing.T) (*Bus, *mockSource) {
+	t.Helper()
+	source := newMockSource()
+	bus := newBusWithSource(source, nil)
+	bus.recvTimeout = mockRecvTimeout
+	return bus, source
+}
+
+// waitFor ждет выполнения условия не дольше секунды.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("не дождались: %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// requestData возвращает данные запроса PGN (0xEA00): PGN в 3 байтах, младший первым.
+func requestData(pgn uint32) []byte {
+	return []byte{byte(pgn), byte(pgn >> 8), byte(pgn >> 16)}
+}
+
+// nextDTC возвращает следующий DTC шины или false, если за время ожидания его нет.
+func nextDTC(bus *Bus, wait time.Duration) (common.DTCCode, bool) {
+	select {
+	case dtc, ok := <-bus.GetDTCChannel():
+		return dtc, ok
+	case <-time.After(wait):
+		return common.DTCCode{}, false
+	}
+}
+
+var (
+	// frameEEC1 - EEC1 двигателя: крутящий момент 0 %, 1500 об/мин.
+	frameEEC1 = []byte{0xF0, 0x7D, 0x7D, 0xE0, 0x2E, 0xFF, 0xFF, 0xFF}
+	// frameDM1Coolant - DM1 с включенной лампой AWL и кодом SPN 110, FMI 3, OC 1.
+	frameDM1Coolant = []byte{0x04, 0xFF, 0x6E, 0x00, 0x03, 0x01, 0xFF, 0xFF}
+	// frameDM1CoolantOil - DM1 с двумя кодами (собран из TP): SPN 110, FMI 3, OC 1
+	// и SPN 100, FMI 1, OC 2.
+	frameDM1CoolantOil = []byte{0x04, 0xFF, 0x6E, 0x00, 0x03, 0x01, 0x64, 0x00, 0x01, 0x02}
+	// frameDM1NoDTC - DM1 без активных неисправностей: SPN 0, FMI 0, OC 0.
+	frameDM1NoDTC = []byte{0x00, 0xFF, 0x00, 0x00, 0x00, 0x00, 0xFF, 0xFF}
+)
+
+func TestBusProcessesReceivedFrames(t *testing.T) {
+	bus, source := newTestBus(t)
+	bus.Start()
+	defer bus.Stop()
+
+	source.receive(pgnEEC1, 0x00, frameEEC1)
+	waitFor(t, "engine_rpm", func() bool {
+		rpm, ok := bus.data.GetFloat64("engine_rpm")
+		return ok && rpm == 1500
+	})
+	if n := bus.FramesReceived(); n != 1 {
+		t.Errorf("FramesReceived = %d, ожидается 1", n)
+	}
+	// При запуске DM5 запрашивается у двигателя (адрес OBD по умолчанию), VIN - у всех блоков
+	sent := source.Sent()
+	if len(sent) < 2 || sent[0].PGN != pgnRQST || sent[1].PGN != pgnRQST {
+		t.Fatalf("отправлено %+v, ожидаются запросы DM5 и VIN", sent)
+	}
+	for i, req := range []struct {
+		pgn  uint32
+		dest uint8
+	}{{pgnDM5, 0x00}, {pgnVI, 0xFF}} {
+		want := requestData(req.pgn)
+		if string(sent[i].Data) != string(want) || sent[i].DestAddr != req.dest {
+			t.Errorf("запрос %d: % X на 0x%02X, ожидается % X на 0x%02X", i, sent[i].Data, sent[i].DestAddr, want, req.dest)
+		}
+	}
+}
+
+func TestBusReadinessRequest(t *testing.T) {
+	bus, source := newTestBus(t)
+	bus.SetReadinessRequest(0x17, 10*time.Millisecond)
+	bus.Start()
+	defer bus.Stop()
+
+	// DM5 повторно запрашивается у заданного блока OBD
+	dm5Requests := func() int {
+		n := 0
+		for _, f := range source.Sent() {
+			if f.PGN == pgnRQST && string(f.Data) == string(requestData(pgnDM5)) {
+				if f.DestAddr != 0x17 {
+					t.Errorf("DM5 запрошен у 0x%02X, ожидается 0x17", f.DestAddr)
+				}
+				n++
+			}
+		}
+		return n
+	}
+	waitFor(t, "повторный запрос DM5", func() bool { return dm5Requests() >= 3 })
+
+	// Готовность публикуется только по DM5 от заданного блока
+	source.receive(pgnDM5, 0x00, []byte{0x01, 0x00, 0x05, 0x00, 0x00, 0x00, 0x00, 0x00})
+	source.receive(pgnDM5, 0x17, []byte{0x02, 0x00, 0x05, 0x00, 0x00, 0x00, 0x00, 0x00})
+	waitFor(t, "readiness", func() bool {
+		r, ok := bus.data.Get("readiness")
+		return ok && r.(common.Readiness).SourceAddress == 0x17
+	})
+	if r, _ := bus.data.Get("readiness"); r.(common.Readiness).ActiveDTCCount != 2 {
+		t.Errorf("readiness = %+v, ожидается DM5 от SA 0x17", r)
+	}
+}
+
+func TestBusDTCDeduplication(t *testing.T) {
+	bus, source := newTestBus(t)
+	// Окно отключено, чтобы повтор подавляло только хранилище
+	bus.frameProcessor.SetDTCWindow(0)
+	bus.frameProcessor.SetDTCStore(storage.NewMemoryDTCStore(0))
+	bus.Start()
+	defer bus.Stop()
+
+	source.receive(pgnDM1, 0x00, frameDM1Coolant)
+	dtc, ok := nextDTC(bus, time.Second)
+	if !ok || dtc.SPN != 110 || dtc.FMI != 3 || dtc.OC != 1 || dtc.MID != 0 {
+		t.Fatalf("первый DM1: %+v (%v), ожидается SPN 110, FMI 3, OC 1 от SA 0", dtc, ok)
+	}
+
+	// DM1 повторяется раз в секунду: тот же код не публикуется, новый - публикуется
+	source.receive(pgnDM1, 0x00, frameDM1Coolant)
+	source.receive(pgnDM1, 0x00, frameDM1CoolantOil)
+	dtc, ok = nextDTC(bus, time.Second)
+	if !ok || dtc.SPN != 100 || dtc.FMI != 1 {
+		t.Fatalf("после повтора получен %+v (%v), ожидается SPN 100, FMI 1", dtc, ok)
+	}
+
+	// Неисправность прошла и появилась снова - код публикуется повторно
+	source.receive(pgnDM1, 0x00, frameDM1NoDTC)
+	source.receive(pgnDM1, 0x00, frameDM1Coolant)
+	dtc, ok = nextDTC(bus, time.Second)
+	if !ok || dtc.SPN != 110 || dtc.FMI != 3 {
+		t.Fatalf("после DM1 без кодов получен %+v (%v), ожидается SPN 110, FMI 3", dtc, ok)
+	}
+	if dtc, ok := nextDTC(bus, 50*time.Millisecond); ok {
+		t.Errorf("лишний DTC: %+v", dtc)
+	}
+
+	// Для каждого нового кода у блока запрашивается стоп-кадр DM4
+	waitFor(t, "запросы DM4", func() bool {
+		n := 0
+		for _, s := range source.Sent() {
+			if s.PGN == pgnRQST && s.DestAddr == 0x00 && string(s.Data) == string(requestData(pgnDM4)) {
+				n++
+			}
+		}
+		return n == 3
+	})
+}
+
+func TestBusStop(t *testing.T) {
+	bus, source := newTestBus(t)
+	bus.Start()
+	source.receive(pgnEEC1, 0x00, frameEEC1)
+	waitFor(t, "обработка кадра", func() bool { return bus.FramesReceived() == 1 })
+
+	done := make(chan struct{})
+	go func() {
+		bus.Stop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Stop не завершился")
+	}
+
+	// Горутина обработки закрывает канал DTC, горутина чтения - канал кадров
+	select {
+	case _, ok := <-bus.GetDTCChannel():
+		if ok {
+			t.Error("после Stop из канала DTC получен код")
+		}
+	case <-time.After(time.Second):
+		t.Error("канал DTC не закрыт после Stop")
+	}
+	select {
+	case <-bus.framesCh:
+	case <-time.After(time.Second):
+		t.Error("канал кадров не закрыт после Stop")
+	}
+	select {
+	case <-source.closed:
+	default:
+		t.Error("источник кадров не закрыт после Stop")
+	}
+	// Повторный Stop безопасен
+	bus.Stop()
+}
+
+func TestBusDropsFramesWhenQueueFull(t *testing.T) {
+	bus, source := newTestBus(t)
+	// Обработка не запущена: очередь кадров заполняется и лишние кадры пропускаются
+	bus.startReader(bus.readFrames)
+	defer bus.Stop()
+
+	total := cap(bus.framesCh) + 5
+	go func() {
+		for i := 0; i < total; i++ {
+			source.receive(pgnEEC1, 0x00, frameEEC1)
+		}
+	}()
+	waitFor(t, "прием кадров", func() bool { return bus.FramesReceived() == uint64(total) })
+	if n := bus.FramesDropped(); n != 5 {
+		t.Errorf("FramesDropped = %d, ожидается 5", n)
+	}
+}
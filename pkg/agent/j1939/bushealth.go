@@ -0,0 +1,196 @@
+package j1939
+
+import (
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// Состояния CAN-контроллера по кадрам ошибок (ISO 11898).
+const (
+	busStateActive  = "error-active"  // Нормальная работа
+	busStateWarning = "error-warning" // Счетчик ошибок превысил 96
+	busStatePassive = "error-passive" // Счетчик ошибок превысил 127: узел не сообщает об ошибках на шину
+	busStateBusOff  = "bus-off"       // Счетчик ошибок передачи превысил 255: контроллер отключен от шины
+)
+
+// Классы кадров ошибок SocketCAN (биты CAN ID, linux/can/error.h).
+const (
+	canErrCrtl      = 0x00000004 // Состояние контроллера, подробности в data[1]
+	canErrBusOff    = 0x00000040
+	canErrRestarted = 0x00000100 // Контроллер перезапущен после bus-off
+)
+
+// Состояние контроллера в data[1] кадра ошибки класса canErrCrtl.
+const (
+	canErrCrtlRxWarning = 0x04
+	canErrCrtlTxWarning = 0x08
+	canErrCrtlRxPassive = 0x10
+	canErrCrtlTxPassive = 0x20
+	canErrCrtlActive    = 0x40
+)
+
+// errorFrameSource - источник кадров ошибок CAN-контроллера.
+// Реализация для SocketCAN находится в canerr.go.
+type errorFrameSource interface {
+	// RecvError блокируется до получения кадра ошибки и возвращает его класс
+	// (биты CAN ID) и данные. По истечении времени ожидания возвращает errRecvTimeout,
+	// после Close - ошибку, удовлетворяющую errors.Is(err, net.ErrClosed).
+	RecvError() (class uint32, data [8]byte, err error)
+	Close() error
+}
+
+// BusHealth - состояние CAN-шины, публикуемое в метрике bus_health и в heartbeat.
+type BusHealth struct {
+	State string `json:"state"`
+	// ErrorFrames - число кадров ошибок, полученных с момента запуска.
+	ErrorFrames uint64 `json:"error_frames"`
+	// BusOffCount - сколько раз контроллер переходил в bus-off.
+	BusOffCount uint64 `json:"bus_off_count"`
+	// ChangedAt - время последней смены состояния.
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// busHealth отслеживает состояние контроллера по кадрам ошибок.
+type busHealth struct {
+	mutex  sync.Mutex
+	health BusHealth
+}
+
+// newBusHealth создает отслеживание состояния. До первого кадра ошибки шина считается
+// работающей нормально: сокет привязан к включенному интерфейсу.
+func newBusHealth(now time.Time) *busHealth {
+	return &busHealth{health: BusHealth{State: busStateActive, ChangedAt: now}}
+}
+
+// update учитывает кадр ошибки и возвращает true, если состояние изменилось.
+func (h *busHealth) update(class uint32, data [8]byte, now time.Time) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.health.ErrorFrames++
+
+	state := h.health.State
+	switch {
+	case class&canErrBusOff != 0:
+		state = busStateBusOff
+	case class&canErrRestarted != 0:
+		state = busStateActive
+	case class&canErrCrtl != 0:
+		ctrl := data[1]
+		switch {
+		case ctrl&canErrCrtlActive != 0:
+			state = busStateActive
+		case ctrl&(canErrCrtlRxPassive|canErrCrtlTxPassive) != 0:
+			state = busStatePassive
+		case ctrl&(canErrCrtlRxWarning|canErrCrtlTxWarning) != 0:
+			state = busStateWarning
+		}
+	}
+	if state == h.health.State {
+		return false
+	}
+	if state == busStateBusOff {
+		h.health.BusOffCount++
+	}
+	h.health.State = state
+	h.health.ChangedAt = now
+	return true
+}
+
+// snapshot возвращает текущее состояние.
+func (h *busHealth) snapshot() BusHealth {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.health
+}
+
+// BusHealth возвращает состояние CAN-шины; ok = false, если кадры ошибок не отслеживаются.
+func (p *Bus) BusHealth() (health BusHealth, ok bool) {
+	if !p.healthMonitored.Load() {
+		return BusHealth{}, false
+	}
+	return p.health.snapshot(), true
+}
+
+// MonitorBusHealth открывает прием кадров ошибок CAN-интерфейса и отслеживает состояние
+// контроллера. При смене состояния оно записывается в метрику bus_health и вызывается
+// onChange. Если контроллер остается в bus-off дольше recovery, агент перезапускает его
+// (recovery 0 - не перезапускать, например если в ядре настроен restart-ms).
+// Вызывается после Start, до Stop.
+func (p *Bus) MonitorBusHealth(recovery time.Duration, onChange func(BusHealth)) {
+	if p.canInterfaceName == "" {
+		return
+	}
+	source, err := openErrorMonitor(p.canInterfaceName, p.recvTimeout)
+	if err != nil {
+		log.Printf("Кадры ошибок CAN недоступны (%v), состояние шины не отслеживается", err)
+		return
+	}
+	p.errorSource = source
+	p.healthMonitored.Store(true)
+	p.data.Set("bus_health", p.health.snapshot())
+	p.startReader(func() { p.readErrorFrames(recovery, onChange) })
+}
+
+// readErrorFrames читает кадры ошибок до остановки шины.
+func (p *Bus) readErrorFrames(recovery time.Duration, onChange func(BusHealth)) {
+	for {
+		class, data, err := p.errorSource.RecvError()
+		if errors.Is(err, errRecvTimeout) {
+			select {
+			case <-p.stopChan:
+				return
+			default:
+				continue
+			}
+		}
+		if err != nil {
+			select {
+			case <-p.stopChan:
+				return
+			default:
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("Ошибка чтения кадров ошибок CAN: %v", err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		if !p.health.update(class, data, time.Now()) {
+			continue
+		}
+		health := p.health.snapshot()
+		if health.State == busStateActive {
+			log.Printf("CAN-шина %s: состояние %s", p.canInterfaceName, health.State)
+		} else {
+			log.Printf("CAN-шина %s: состояние %s (кадров ошибок: %d), данные шины могут быть устаревшими", p.canInterfaceName, health.State, health.ErrorFrames)
+		}
+		p.data.Set("bus_health", health)
+		if onChange != nil {
+			onChange(health)
+		}
+		if health.State == busStateBusOff && recovery > 0 {
+			go p.recoverBusOff(recovery, health.BusOffCount)
+		}
+	}
+}
+
+// recoverBusOff перезапускает контроллер, если через delay он все еще находится
+// в том же эпизоде bus-off (busOffCount не изменился) и не был перезапущен ядром.
+func (p *Bus) recoverBusOff(delay time.Duration, busOffCount uint64) {
+	select {
+	case <-p.stopChan:
+		return
+	case <-time.After(delay):
+	}
+	if health := p.health.snapshot(); health.State != busStateBusOff || health.BusOffCount != busOffCount {
+		return
+	}
+	log.Printf("CAN-шина %s в состоянии bus-off дольше %v, перезапуск контроллера...", p.canInterfaceName, delay)
+	if err := restartCAN(p.canInterfaceName); err != nil {
+		log.Printf("Не удалось перезапустить CAN-контроллер: %v. Настройте автоматический перезапуск: ip link set %s type can restart-ms 100", err, p.canInterfaceName)
+	}
+}
@@ -0,0 +1,81 @@
+//go:build linux
+
+package j1939
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// canErrorSocket принимает кадры ошибок через отдельный сокет CAN_RAW: обычные кадры
+// отфильтрованы, поэтому сокет работает одинаково в режимах CAN_J1939 и CAN_RAW.
+type canErrorSocket struct {
+	fd        int
+	buffer    []byte
+	closeOnce sync.Once
+}
+
+// openErrorMonitor открывает прием кадров ошибок (CAN_ERR_MASK) интерфейса canInterface.
+// recvTimeout > 0 ограничивает ожидание кадра в RecvError.
+func openErrorMonitor(canInterface string, recvTimeout time.Duration) (errorFrameSource, error) {
+	fd, _, err := openRawSocket(canInterface)
+	if err != nil {
+		return nil, err
+	}
+	if err := setRecvTimeout(fd, recvTimeout); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	// Пустой фильтр - обычные кадры не принимаются
+	if err := unix.SetsockoptCanRawFilter(fd, unix.SOL_CAN_RAW, unix.CAN_RAW_FILTER, nil); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("не удалось отключить прием кадров данных: %w", err)
+	}
+	if err := unix.SetsockoptInt(fd, unix.SOL_CAN_RAW, unix.CAN_RAW_ERR_FILTER, unix.CAN_ERR_MASK); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("не удалось включить прием кадров ошибок: %w", err)
+	}
+	return &canErrorSocket{fd: fd, buffer: make([]byte, canFrameSize)}, nil
+}
+
+// RecvError блокируется до получения кадра ошибки или до истечения времени ожидания (errRecvTimeout).
+func (s *canErrorSocket) RecvError() (class uint32, data [8]byte, err error) {
+	for {
+		n, err := unix.Read(s.fd, s.buffer)
+		if err != nil {
+			if errors.Is(err, unix.EAGAIN) {
+				return 0, data, errRecvTimeout
+			}
+			if errors.Is(err, unix.EBADF) {
+				return 0, data, fmt.Errorf("Read: %w", net.ErrClosed)
+			}
+			return 0, data, err
+		}
+		if n < canFrameSize {
+			continue
+		}
+		id := binary.NativeEndian.Uint32(s.buffer[0:4])
+		if id&unix.CAN_ERR_FLAG == 0 {
+			continue
+		}
+		copy(data[:], s.buffer[8:16])
+		return id & unix.CAN_ERR_MASK, data, nil
+	}
+}
+
+// Close закрывает сокет и прерывает блокирующий RecvError.
+func (s *canErrorSocket) Close() error {
+	err := net.ErrClosed
+	s.closeOnce.Do(func() {
+		log.Printf("Закрытие сокета кадров ошибок CAN (fd %d)...", s.fd)
+		err = unix.Close(s.fd)
+	})
+	return err
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package j1939
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/serebryakov7/j1708-stats/common"
+)
+
+// openErrorMonitor недоступен вне Linux.
+func openErrorMonitor(canInterface string, recvTimeout time.Duration) (errorFrameSource, error) {
+	return nil, fmt.Errorf("%w: кадры ошибок CAN поддерживаются только в Linux", common.ErrSocketUnavailable)
+}
@@ -0,0 +1,160 @@
+//go:build linux
+
+package j1939
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/serebryakov7/j1708-stats/common"
+)
+
+// bringUpCAN включает CAN-интерфейс name через netlink (как ip link set name up type can
+// bitrate bitrate), если он выключен. bitrate 0 - скорость не задается. Требует CAP_NET_ADMIN;
+// без прав возвращает ошибку, соответствующую common.ErrPermissionDenied.
+func bringUpCAN(name string, bitrate uint32) error {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return fmt.Errorf("%w: %q: %w", common.ErrInterfaceNotFound, name, err)
+	}
+	if iface.Flags&net.FlagUp != 0 {
+		return nil
+	}
+
+	fd, err := openRouteSocket()
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	// Скорость задается только у выключенного интерфейса, поэтому до включения
+	if bitrate > 0 {
+		timing := make([]byte, unsafe.Sizeof(unix.CANBitTiming{}))
+		binary.NativeEndian.PutUint32(timing, bitrate) // Bitrate - первое поле can_bittiming
+		err := netlinkRequest(fd, 1, ifInfo(iface.Index, 0, 0), canLinkInfo(unix.IFLA_CAN_BITTIMING, timing))
+		if err != nil {
+			if err == syscall.EPERM || err == syscall.EACCES {
+				return fmt.Errorf("нет прав на настройку %s (нужна CAP_NET_ADMIN): %w", name, err)
+			}
+			// Например, vcan не поддерживает настройку скорости: включаем без нее
+			log.Printf("Не удалось задать скорость %d бит/с для %s: %v", bitrate, name, err)
+		}
+	}
+
+	if err := netlinkRequest(fd, 2, ifInfo(iface.Index, unix.IFF_UP, unix.IFF_UP), nil); err != nil {
+		if err == syscall.EPERM || err == syscall.EACCES {
+			return fmt.Errorf("нет прав на включение %s (нужна CAP_NET_ADMIN): %w", name, err)
+		}
+		return fmt.Errorf("не удалось включить %s: %w", name, err)
+	}
+	log.Printf("CAN-интерфейс %s включен (скорость %d бит/с)", name, bitrate)
+	return nil
+}
+
+// restartCAN перезапускает CAN-контроллер интерфейса name после bus-off
+// (как ip link set name type can restart). Ядро выполняет перезапуск, только если
+// интерфейс в состоянии bus-off и автоматический перезапуск (restart-ms) не настроен.
+// Требует CAP_NET_ADMIN.
+func restartCAN(name string) error {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return fmt.Errorf("%w: %q: %w", common.ErrInterfaceNotFound, name, err)
+	}
+	fd, err := openRouteSocket()
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	restart := make([]byte, 4)
+	binary.NativeEndian.PutUint32(restart, 1)
+	if err := netlinkRequest(fd, 1, ifInfo(iface.Index, 0, 0), canLinkInfo(unix.IFLA_CAN_RESTART, restart)); err != nil {
+		if err == syscall.EPERM || err == syscall.EACCES {
+			return fmt.Errorf("нет прав на перезапуск %s (нужна CAP_NET_ADMIN): %w", name, err)
+		}
+		return fmt.Errorf("не удалось перезапустить %s: %w", name, err)
+	}
+	return nil
+}
+
+// openRouteSocket открывает сокет netlink NETLINK_ROUTE для настройки интерфейсов.
+func openRouteSocket() (int, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+	if err != nil {
+		return -1, fmt.Errorf("не удалось создать сокет netlink: %w", err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		unix.Close(fd)
+		return -1, fmt.Errorf("не удалось привязать сокет netlink: %w", err)
+	}
+	return fd, nil
+}
+
+// canLinkInfo кодирует атрибут IFLA_LINKINFO типа "can" с одним параметром CAN (IFLA_CAN_*).
+func canLinkInfo(typ uint16, value []byte) []byte {
+	linkInfo := append(netlinkAttr(unix.IFLA_INFO_KIND, []byte("can\x00")),
+		netlinkAttr(unix.IFLA_INFO_DATA, netlinkAttr(typ, value))...)
+	return netlinkAttr(unix.IFLA_LINKINFO, linkInfo)
+}
+
+// ifInfo кодирует struct ifinfomsg для интерфейса index.
+func ifInfo(index int, flags, change uint32) []byte {
+	b := make([]byte, unix.SizeofIfInfomsg)
+	b[0] = unix.AF_UNSPEC
+	binary.NativeEndian.PutUint32(b[4:], uint32(index))
+	binary.NativeEndian.PutUint32(b[8:], flags)
+	binary.NativeEndian.PutUint32(b[12:], change)
+	return b
+}
+
+// netlinkAttr кодирует атрибут netlink (struct rtattr) с выравниванием до 4 байт.
+func netlinkAttr(typ uint16, payload []byte) []byte {
+	length := unix.SizeofRtAttr + len(payload)
+	b := make([]byte, (length+unix.RTA_ALIGNTO-1) & ^(unix.RTA_ALIGNTO-1))
+	binary.NativeEndian.PutUint16(b[0:], uint16(length))
+	binary.NativeEndian.PutUint16(b[2:], typ)
+	copy(b[unix.SizeofRtAttr:], payload)
+	return b
+}
+
+// netlinkRequest отправляет RTM_NEWLINK с телом ifinfo и атрибутами attrs
+// и ждет подтверждения ядра. Возвращает ошибку ядра (syscall.Errno), если она есть.
+func netlinkRequest(fd int, seq uint32, ifinfo, attrs []byte) error {
+	length := unix.SizeofNlMsghdr + len(ifinfo) + len(attrs)
+	msg := make([]byte, unix.SizeofNlMsghdr, length)
+	binary.NativeEndian.PutUint32(msg[0:], uint32(length))
+	binary.NativeEndian.PutUint16(msg[4:], unix.RTM_NEWLINK)
+	binary.NativeEndian.PutUint16(msg[6:], unix.NLM_F_REQUEST|unix.NLM_F_ACK)
+	binary.NativeEndian.PutUint32(msg[8:], seq)
+	msg = append(append(msg, ifinfo...), attrs...)
+
+	if err := unix.Sendto(fd, msg, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return err
+	}
+	buf := make([]byte, 4096)
+	for {
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return err
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return err
+		}
+		for _, m := range msgs {
+			if m.Header.Seq != seq || m.Header.Type != unix.NLMSG_ERROR || len(m.Data) < 4 {
+				continue
+			}
+			if errno := int32(binary.NativeEndian.Uint32(m.Data)); errno != 0 {
+				return syscall.Errno(-errno)
+			}
+			return nil
+		}
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package j1939
+
+import (
+	"fmt"
+
+	"github.com/serebryakov7/j1708-stats/common"
+)
+
+// bringUpCAN недоступен вне Linux: CAN-интерфейсы настраиваются через netlink Linux.
+func bringUpCAN(name string, bitrate uint32) error {
+	return fmt.Errorf("%w: включение CAN-интерфейса поддерживается только в Linux", common.ErrSocketUnavailable)
+}
+
+// restartCAN недоступен вне Linux.
+func restartCAN(name string) error {
+	return fmt.Errorf("%w: перезапуск CAN-интерфейса поддерживается только в Linux", common.ErrSocketUnavailable)
+}
@@ -0,0 +1,473 @@
+package j1939
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/serebryakov7/j1708-stats/common"
+	"github.com/serebryakov7/j1708-stats/pkg/j1939bits"
+	"github.com/serebryakov7/j1708-stats/pkg/storage"
+)
+
+// newTestProcessor создает обработчик кадров без базы с буферизованным каналом DTC.
+func newTestProcessor() *FrameProcessor {
+	return NewFrameProcessor(NewJ1939Data(), make(chan common.DTCCode, 64), nil)
+}
+
+// metricCase описывает ожидаемые после разбора кадра метрики: значения valid,
+// недоступные (nil) unavailable и не записанные absent.
+type metricCase struct {
+	name        string
+	data        []byte
+	valid       map[string]float64
+	unavailable []string
+	absent      []string
+}
+
+func checkMetrics(t *testing.T, fp *FrameProcessor, tt metricCase) {
+	t.Helper()
+	for key, want := range tt.valid {
+		got, ok := fp.data.GetFloat64(key)
+		if !ok || math.Abs(got-want) > 1e-9 {
+			t.Errorf("%s = %v (%v), ожидается %v", key, got, ok, want)
+		}
+	}
+	for _, key := range tt.unavailable {
+		if v, ok := fp.data.Get(key); !ok || v != nil {
+			t.Errorf("%s = %v (%v), ожидается недоступное значение", key, v, ok)
+		}
+	}
+	for _, key := range tt.absent {
+		if v, ok := fp.data.Get(key); ok {
+			t.Errorf("%s = %v, ожидается отсутствие метрики", key, v)
+		}
+	}
+}
+
+func TestProcessFrameIC1(t *testing.T) {
+	tests := []metricCase{
+		{
+			name: "все параметры",
+			// Наддув 250 кПа, впускной коллектор 40 °C, выхлоп 192 °C
+			data:  []byte{0xFF, 0x7D, 0x50, 0xFF, 0xFF, 0x20, 0x3A, 0xFF},
+			valid: map[string]float64{"boost_pressure": 250, "intake_manifold_temp": 40, "exhaust_gas_temp": 192},
+		},
+		{
+			name:  "границы диапазона",
+			data:  []byte{0xFF, 0x00, 0xFA, 0xFF, 0xFF, 0x00, 0x00, 0xFF},
+			valid: map[string]float64{"boost_pressure": 0, "intake_manifold_temp": 210, "exhaust_gas_temp": -273},
+		},
+		{
+			name:        "недоступно",
+			data:        []byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF},
+			unavailable: []string{"boost_pressure", "intake_manifold_temp", "exhaust_gas_temp"},
+		},
+		{
+			name:        "индикатор ошибки",
+			data:        []byte{0xFF, 0xFE, 0xFE, 0xFF, 0xFF, 0x00, 0xFE, 0xFF},
+			unavailable: []string{"boost_pressure", "intake_manifold_temp", "exhaust_gas_temp"},
+		},
+		{
+			name:   "без температуры выхлопа",
+			data:   []byte{0xFF, 0x64, 0x3C},
+			valid:  map[string]float64{"boost_pressure": 200, "intake_manifold_temp": 20},
+			absent: []string{"exhaust_gas_temp"},
+		},
+		{
+			name:   "короткий кадр",
+			data:   []byte{0xFF, 0x64},
+			absent: []string{"boost_pressure", "intake_manifold_temp", "exhaust_gas_temp"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fp := newTestProcessor()
+			fp.ProcessFrame(pgnIC1, 0x00, tt.data, time.Now())
+			checkMetrics(t, fp, tt)
+		})
+	}
+}
+
+func TestProcessFrameIC1SensorErrors(t *testing.T) {
+	fp := newTestProcessor()
+	fp.ProcessFrame(pgnIC1, 0x00, []byte{0xFF, 0xFE, 0x50, 0xFF, 0xFF, 0x00, 0xFE, 0xFF}, time.Now())
+	got, _ := fp.data.Get("sensor_errors")
+	want := []string{"boost_pressure", "exhaust_gas_temp"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sensor_errors = %v, ожидается %v", got, want)
+	}
+
+	// Блок снова передает значения - индикаторы ошибок снимаются
+	fp.ProcessFrame(pgnIC1, 0x00, []byte{0xFF, 0x7D, 0x50, 0xFF, 0xFF, 0x20, 0x3A, 0xFF}, time.Now())
+	if v, _ := fp.data.Get("sensor_errors"); v != nil {
+		t.Errorf("sensor_errors = %v после верных значений, ожидается nil", v)
+	}
+}
+
+func TestProcessFrameIC1Malformed(t *testing.T) {
+	fp := newTestProcessor()
+	fp.ProcessFrame(pgnIC1, 0x00, []byte{0xFF, 0x64}, time.Now())
+	if n := fp.MalformedFrames(); n != 1 {
+		t.Errorf("MalformedFrames = %d, ожидается 1", n)
+	}
+}
+
+func TestProcessFrameDPFC1(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      []byte
+		active    any
+		inhibited any
+	}{
+		// Байт 2: SPN 3700 в битах 1-2, SPN 3701 (DPF Status) в битах 3-5
+		{"регенерация активна, DPF Status 2", []byte{0x00, 0x09, 0x00, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}, true, false},
+		{"регенерация не активна, DPF Status 1", []byte{0x00, 0x04, 0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}, false, true},
+		{"требуется регенерация", []byte{0x00, 0x02, 0x00, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}, false, false},
+		{"not available", []byte{0x00, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fp := newTestProcessor()
+			fp.ProcessFrame(pgnDPFC, 0x00, tt.data, time.Now())
+			for key, want := range map[string]any{"dpf_regen_active": tt.active, "dpf_regen_inhibited": tt.inhibited} {
+				if got, ok := fp.data.Get(key); !ok || got != want {
+					t.Errorf("%s = %v (%v), ожидается %v", key, got, ok, want)
+				}
+			}
+		})
+	}
+}
+
+func TestProcessFrameDM5(t *testing.T) {
+	// 3 активных и 1 ранее активный код, OBD-II (0x13); непрерывные мониторы 0-2 поддерживаются,
+	// монитор 1 не завершен; периодические 0x016D поддерживаются, 0x0041 не завершены
+	data := []byte{0x03, 0x01, 0x13, 0x27, 0x6D, 0x01, 0x41, 0x00}
+	want := common.Readiness{
+		SourceAddress:            0x00,
+		ActiveDTCCount:           3,
+		PreviouslyActiveDTCCount: 1,
+		OBDCompliance:            0x13,
+		ContinuousSupported:      0x07,
+		ContinuousCompleted:      0x05,
+		NonContinuousSupported:   0x016D,
+		NonContinuousCompleted:   0x012C,
+	}
+
+	fp := newTestProcessor()
+	// DM5 от блока, отличного от блока OBD, не публикуется
+	fp.ProcessFrame(pgnDM5, 0x03, data, time.Now())
+	if got, ok := fp.data.Get("readiness"); ok {
+		t.Fatalf("readiness = %+v по DM5 от SA 0x03, ожидается отсутствие", got)
+	}
+	fp.ProcessFrame(pgnDM5, 0x00, data, time.Now())
+	if got, ok := fp.data.Get("readiness"); !ok || got != want {
+		t.Errorf("readiness = %+v (%v), ожидается %+v", got, ok, want)
+	}
+
+	fp.SetReadinessSource(0x03)
+	fp.ProcessFrame(pgnDM5, 0x03, data, time.Now())
+	want.SourceAddress = 0x03
+	if got, _ := fp.data.Get("readiness"); got != want {
+		t.Errorf("readiness = %+v после SetReadinessSource(0x03), ожидается %+v", got, want)
+	}
+}
+
+// sentDTCs возвращает коды, уже отправленные обработчиком в канал DTC.
+func sentDTCs(fp *FrameProcessor) []common.DTCCode {
+	var codes []common.DTCCode
+	for {
+		select {
+		case dtc := <-fp.dtcChan:
+			codes = append(codes, dtc)
+		default:
+			return codes
+		}
+	}
+}
+
+func TestProcessFrameDTCLengths(t *testing.T) {
+	// Байты 0-1 - лампы, затем коды по 4 байта: SPN 110/FMI 3/OC 1 и SPN 100/FMI 1/OC 2
+	tests := []struct {
+		name      string
+		data      []byte
+		spns      []int
+		malformed bool
+	}{
+		{"5 байт: неполный код", []byte{0x04, 0xFF, 0x6E, 0x00, 0x03}, nil, true},
+		{"5 байт: заполнитель 0xFF", []byte{0x04, 0xFF, 0xFF, 0xFF, 0xFF}, nil, false},
+		{"6 байт: один код", []byte{0x04, 0xFF, 0x6E, 0x00, 0x03, 0x01}, []int{110}, false},
+		{"7 байт: код и заполнитель 0xFF", []byte{0x04, 0xFF, 0x6E, 0x00, 0x03, 0x01, 0xFF}, []int{110}, false},
+		{"7 байт: код и лишний байт", []byte{0x04, 0xFF, 0x6E, 0x00, 0x03, 0x01, 0x64}, []int{110}, true},
+		{"9 байт: код и неполный код", []byte{0x04, 0xFF, 0x6E, 0x00, 0x03, 0x01, 0x64, 0x00, 0x01}, []int{110}, true},
+		{"10 байт: два кода", []byte{0x04, 0xFF, 0x6E, 0x00, 0x03, 0x01, 0x64, 0x00, 0x01, 0x02}, []int{110, 100}, false},
+		// Заполнители "нет кодов" с SPN 0 не публикуются
+		{"8 байт: SPN 0, FMI 0, OC 0", []byte{0x00, 0xFF, 0x00, 0x00, 0x00, 0x00, 0xFF, 0xFF}, nil, false},
+		{"8 байт: SPN 0, FMI 31, OC 0x7F", []byte{0x00, 0xFF, 0x00, 0x00, 0x1F, 0x7F, 0xFF, 0xFF}, nil, false},
+		{"10 байт: код и SPN 0, FMI 31", []byte{0x04, 0xFF, 0x6E, 0x00, 0x03, 0x01, 0x00, 0x00, 0x1F, 0x00}, []int{110}, false},
+	}
+	for _, pgn := range []uint32{pgnDM1, pgnDM2} {
+		for _, tt := range tests {
+			t.Run(fmt.Sprintf("PGN 0x%X/%s", pgn, tt.name), func(t *testing.T) {
+				fp := newTestProcessor()
+				fp.ProcessFrame(pgn, 0x00, tt.data, time.Now())
+
+				var spns []int
+				for _, dtc := range sentDTCs(fp) {
+					spns = append(spns, dtc.SPN)
+				}
+				if !reflect.DeepEqual(spns, tt.spns) {
+					t.Errorf("коды SPN %v, ожидается %v", spns, tt.spns)
+				}
+				if got := fp.MalformedFrames() == 1; got != tt.malformed {
+					t.Errorf("MalformedFrames = %d, некорректный кадр: %v", fp.MalformedFrames(), tt.malformed)
+				}
+			})
+		}
+	}
+}
+
+func TestProcessFrameDM1AllClear(t *testing.T) {
+	fp := newTestProcessor()
+	var requested []uint8
+	fp.SetPGNRequester(func(pgn uint32, destAddr uint8) error {
+		requested = append(requested, destAddr)
+		return nil
+	})
+	fp.SetDTCStore(storage.NewMemoryDTCStore(0))
+
+	fp.ProcessFrame(pgnDM1, 0x00, []byte{0x04, 0xFF, 0x6E, 0x00, 0x03, 0x01, 0xFF, 0xFF}, time.Now())
+	if codes := sentDTCs(fp); len(codes) != 1 || len(requested) != 1 {
+		t.Fatalf("опубликовано %+v, запросов DM4 %d, ожидается SPN 110 и один запрос", codes, len(requested))
+	}
+
+	// Вариант "нет кодов" с FMI 31 и OC 0x7F снимает активный код и не запрашивает DM4
+	requested = nil
+	fp.ProcessFrame(pgnDM1, 0x00, []byte{0x00, 0xFF, 0x00, 0x00, 0x1F, 0x7F, 0xFF, 0xFF}, time.Now().Add(time.Second))
+	if codes := sentDTCs(fp); len(codes) != 0 || len(requested) != 0 {
+		t.Errorf("опубликовано %+v, запросов DM4 %d, ожидается ни одного", codes, len(requested))
+	}
+	if active := fp.ActiveDTCs(); len(active) != 0 {
+		t.Errorf("активные коды %+v, ожидается пусто", active)
+	}
+
+	// Снятый код публикуется при повторном появлении (окно повтора -dtc-window прошло)
+	fp.ProcessFrame(pgnDM1, 0x00, []byte{0x04, 0xFF, 0x6E, 0x00, 0x03, 0x01, 0xFF, 0xFF}, time.Now().Add(time.Minute))
+	if codes := sentDTCs(fp); len(codes) != 1 || codes[0].SPN != 110 {
+		t.Errorf("после повторного появления опубликовано %+v, ожидается SPN 110", codes)
+	}
+}
+
+func TestProcessFrameLegacyDTC(t *testing.T) {
+	// Коды с CM = 1 разбираются по версии, заданной SetLegacySPNVersion; version 0 -
+	// версия по умолчанию. Код с CM = 0 в том же кадре всегда разбирается по версии 4.
+	modern := []byte{0x64, 0x00, 0x01, 0x02} // SPN 100, FMI 1, OC 2
+	tests := []struct {
+		name    string
+		version int
+		code    []byte
+		spn     int
+		fmi     int
+		oc      int
+	}{
+		{"по умолчанию (версия 1)", 0, []byte{0x00, 0x0D, 0xC3, 0x81}, 110, 3, 1},
+		{"версия 1", j1939bits.SPNVersion1, []byte{0x00, 0x0D, 0xC3, 0x81}, 110, 3, 1},
+		{"версия 2", j1939bits.SPNVersion2, []byte{0x0D, 0x00, 0xC3, 0x81}, 110, 3, 1},
+		{"версия 3", j1939bits.SPNVersion3, []byte{0x6E, 0x00, 0x03, 0x81}, 110, 3, 1},
+		{"версия 1, большой SPN", j1939bits.SPNVersion1, []byte{0xFE, 0x01, 0x05, 0x82}, 520200, 5, 2},
+		{"версия 2, большой SPN", j1939bits.SPNVersion2, []byte{0x01, 0xFE, 0x05, 0x82}, 520200, 5, 2},
+	}
+	frames := []struct {
+		name string
+		pgn  uint32
+		data func(code []byte) []byte
+	}{
+		{"DM1", pgnDM1, func(code []byte) []byte {
+			return append(append([]byte{0x04, 0xFF}, code...), modern...)
+		}},
+		{"DM2", pgnDM2, func(code []byte) []byte {
+			return append(append([]byte{0x04, 0xFF}, code...), modern...)
+		}},
+		// Два стоп-кадра длиной 4 без параметров
+		{"DM4", pgnDM4, func(code []byte) []byte {
+			return append(append(append([]byte{0x04}, code...), 0x04), modern...)
+		}},
+	}
+	for _, frame := range frames {
+		for _, tt := range tests {
+			t.Run(frame.name+"/"+tt.name, func(t *testing.T) {
+				fp := newTestProcessor()
+				if tt.version != 0 {
+					fp.SetLegacySPNVersion(tt.version)
+				}
+				fp.ProcessFrame(frame.pgn, 0x00, frame.data(tt.code), time.Now())
+
+				codes := sentDTCs(fp)
+				if len(codes) != 2 {
+					t.Fatalf("получено DTC %d, ожидается 2: %+v", len(codes), codes)
+				}
+				if c := codes[0]; c.SPN != tt.spn || c.FMI != tt.fmi || c.OC != tt.oc {
+					t.Errorf("код CM = 1: SPN %d, FMI %d, OC %d; ожидается SPN %d, FMI %d, OC %d",
+						c.SPN, c.FMI, c.OC, tt.spn, tt.fmi, tt.oc)
+				}
+				if c := codes[1]; c.SPN != 100 || c.FMI != 1 || c.OC != 2 {
+					t.Errorf("код CM = 0: SPN %d, FMI %d, OC %d; ожидается SPN 100, FMI 1, OC 2", c.SPN, c.FMI, c.OC)
+				}
+			})
+		}
+	}
+}
+
+// dm1Data возвращает данные DM1 с n разными кодами (SPN 100, 101, ...; FMI 3, OC 1)
+// и включенной лампой AWL. При n > 1 сообщение передается через TP.
+func dm1Data(n int) []byte {
+	data := []byte{0x04, 0xFF}
+	for i := 0; i < n; i++ {
+		spn := 100 + i
+		data = append(data, byte(spn), byte(spn>>8), byte(spn>>16)<<5|0x03, 0x01)
+	}
+	if len(data) < 8 {
+		data = append(data, 0xFF, 0xFF)
+	}
+	return data
+}
+
+// tpFrames разбивает сообщение на кадры TP: объявление BAM и пакеты TP.DT.
+func tpFrames(pgn uint32, message []byte) [][]byte {
+	packets := (len(message) + tpDTPayload - 1) / tpDTPayload
+	frames := [][]byte{{tpCMBAM, byte(len(message)), byte(len(message) >> 8), byte(packets), 0xFF, byte(pgn), byte(pgn >> 8), byte(pgn >> 16)}}
+	for seq := 1; seq <= packets; seq++ {
+		packet := []byte{byte(seq), 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
+		copy(packet[1:], message[(seq-1)*tpDTPayload:])
+		frames = append(frames, packet)
+	}
+	return frames
+}
+
+// newDrainedProcessor создает обработчик, DTC которого вычитываются в фоне,
+// чтобы отправка в канал не блокировала разбор при любом числе кодов.
+func newDrainedProcessor(tb testing.TB) *FrameProcessor {
+	dtcChan := make(chan common.DTCCode, 64)
+	done := make(chan struct{})
+	go func() {
+		for range dtcChan {
+		}
+		close(done)
+	}()
+	tb.Cleanup(func() {
+		close(dtcChan)
+		<-done
+	})
+	return NewFrameProcessor(NewJ1939Data(), dtcChan, nil)
+}
+
+func BenchmarkProcessFrame(b *testing.B) {
+	now := time.Now()
+	b.Run("EEC1", func(b *testing.B) {
+		fp := newDrainedProcessor(b)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			fp.ProcessFrame(pgnEEC1, 0x00, frameEEC1, now)
+		}
+	})
+	// DM1 повторяется раз в секунду с теми же кодами: после первого кадра коды
+	// подавляются окном дедупликации, как при работе на шине
+	for _, n := range []int{1, 5, 20} {
+		data := dm1Data(n)
+		b.Run(fmt.Sprintf("DM1/%d кодов", n), func(b *testing.B) {
+			fp := newDrainedProcessor(b)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				fp.ProcessFrame(pgnDM1, 0x00, data, now)
+			}
+		})
+	}
+	// Сборка DM1 с 5 кодами из TP (BAM и 4 пакета TP.DT) в режиме CAN_RAW и разбор
+	b.Run("TP/DM1 5 кодов", func(b *testing.B) {
+		fp := newDrainedProcessor(b)
+		tp := newTPReassembler()
+		frames := tpFrames(pgnDM1, dm1Data(5))
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, ok := tp.handle(pgnTPCM, 0x00, 0xFF, frames[0], now); ok {
+				b.Fatal("сообщение собрано по объявлению")
+			}
+			for _, packet := range frames[1:] {
+				if msg, ok := tp.handle(pgnTPDT, 0x00, 0xFF, packet, now); ok {
+					fp.ProcessFrame(msg.PGN, msg.SA, msg.Data, msg.Timestamp)
+				}
+			}
+		}
+	})
+}
+
+// fuzzPGNs - PGN, разборщики которых проверяются на произвольных данных.
+var fuzzPGNs = []uint32{
+	pgnEEC1, pgnEEC2, pgnERC1, pgnEBC1, pgnETC2, pgnEBC2, pgnCVW, pgnGPS, pgnVDHR, pgnLFE,
+	pgnET1, pgnAmb, pgnIC1, pgnTC1, pgnET, pgnAT1T, pgnAT1S, pgnDPFC, pgnVI,
+	pgnDM1, pgnDM2, pgnDM4, pgnDM5,
+}
+
+// joinFrames склеивает кадры TP в один вход для FuzzProcessFrame.
+func joinFrames(frames [][]byte) []byte {
+	var data []byte
+	for _, frame := range frames {
+		data = append(data, frame...)
+	}
+	return data
+}
+
+// FuzzProcessFrame передает данные всем разборщикам PGN напрямую, минуя recover
+// в ProcessFrame, чтобы паника разбора завершала тест. Те же данные разбиваются на
+// кадры по 8 байт и передаются сборке TP: первый кадр - TP.CM, остальные - TP.DT.
+func FuzzProcessFrame(f *testing.F) {
+	// DM4: длина стоп-кадра 12, код SPN 110/FMI 3/OC 1 и параметры: режим момента 0,
+	// наддув 200 кПа, 1500 об/мин, нагрузка 50 %, 50 °C, 80 км/ч
+	dm4 := []byte{0x0C, 0x6E, 0x00, 0x03, 0x01, 0x00, 0x64, 0xE0, 0x2E, 0x32, 0x5A, 0x00, 0x50}
+	seeds := [][]byte{
+		nil,
+		{0x04},
+		frameEEC1,
+		frameDM1Coolant,
+		frameDM1CoolantOil,
+		frameDM1NoDTC,
+		// DM1 с кодом CM = 1 (SPN 110 в раскладке версии 1)
+		{0x04, 0xFF, 0x00, 0x0D, 0xC3, 0x81, 0xFF, 0xFF},
+		// DM1 с неполным последним кодом
+		{0x04, 0xFF, 0x6E, 0x00, 0x03, 0x01, 0x64, 0x00, 0x01},
+		dm4,
+		// DM4 с длиной стоп-кадра больше данных
+		{0x0C, 0x6E, 0x00, 0x03},
+		[]byte("1FUJGLDR12LM12345*"),
+		joinFrames(tpFrames(pgnDM1, dm1Data(5))),
+		joinFrames(tpFrames(pgnDM4, dm4)),
+		joinFrames(tpFrames(pgnVI, []byte("1FUJGLDR12LM12345*"))),
+		// Объявление BAM на 1785 байт (255 пакетов) с одним пакетом TP.DT
+		{tpCMBAM, 0xF9, 0x06, 0xFF, 0xFF, 0xCA, 0xFE, 0x00, 0x01, 0x04, 0xFF, 0x6E, 0x00, 0x03, 0x01, 0xFF},
+	}
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		now := time.Now()
+		fp := newDrainedProcessor(t)
+		for _, pgn := range fuzzPGNs {
+			fp.parsePGN(pgn, 0x00, data, now)
+		}
+
+		if len(data) < 8 {
+			return
+		}
+		tp := newTPReassembler()
+		tp.handle(pgnTPCM, 0x00, 0xFF, data[:8], now)
+		for rest := data[8:]; len(rest) > 0; {
+			n := min(len(rest), 8)
+			if msg, ok := tp.handle(pgnTPDT, 0x00, 0xFF, rest[:n], now); ok {
+				fp.parsePGN(msg.PGN, msg.SA, msg.Data, msg.Timestamp)
+			}
+			rest = rest[n:]
+		}
+	})
+}
@@ -0,0 +1,972 @@
+package j1939
+
+import (
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/serebryakov7/j1708-stats/common"
+	"github.com/serebryakov7/j1708-stats/pkg/analytics"
+	"github.com/serebryakov7/j1708-stats/pkg/envflag"
+	"github.com/serebryakov7/j1708-stats/pkg/filter"
+	"github.com/serebryakov7/j1708-stats/pkg/httpapi"
+	"github.com/serebryakov7/j1708-stats/pkg/j1939bits"
+	"github.com/serebryakov7/j1708-stats/pkg/logging"
+	"github.com/serebryakov7/j1708-stats/pkg/mqtt"
+	"github.com/serebryakov7/j1708-stats/pkg/profiling"
+	"github.com/serebryakov7/j1708-stats/pkg/retry"
+	"github.com/serebryakov7/j1708-stats/pkg/selftest"
+	"github.com/serebryakov7/j1708-stats/pkg/sink"
+	"github.com/serebryakov7/j1708-stats/pkg/storage" // Добавлен импорт для storage
+	bolt "go.etcd.io/bbolt"
+)
+
+// Настройки по умолчанию
+const (
+	defaultMqttBroker     = "tcp://localhost:1883"
+	defaultMqttTopic      = "vehicle/data/j1939"
+	defaultMqttDTCTopic   = "vehicle/dtc/j1939"
+	defaultUpdateInterval = 10 * time.Second
+	defaultHeartbeatTopic = "vehicle/heartbeat/j1939"
+	defaultCommandTopic   = "vehicle/command/j1939"
+	defaultCanInterface   = "can0"
+	defaultDbPath         = "j1939_dtc.db" // Путь к файлу БД для DTC J1939
+)
+
+// flags - параметры агента. Собственный набор вместо flag.CommandLine позволяет
+// собрать оба агента в одну программу (cmd/j1708-stats).
+var flags = flag.NewFlagSet("j1939", flag.ExitOnError)
+
+var (
+	mqttBroker        = flags.String("broker", defaultMqttBroker, "MQTT брокер")
+	mqttTopic         = flags.String("topic", defaultMqttTopic, "MQTT топик для основных данных")
+	mqttDTCTopic      = flags.String("dtc_topic", defaultMqttDTCTopic, "MQTT топик для кодов неисправностей (DTC)")
+	updateInterval    = flags.Duration("interval", defaultUpdateInterval, "Интервал обновления MQTT в секундах")
+	clientID          = flags.String("client-id", "", "Идентификатор клиента MQTT (пусто - постоянный, производный от VIN или имени хоста и интерфейса)")
+	randomClientID    = flags.Bool("random-client-id", false, "Добавлять к идентификатору клиента MQTT случайный суффикс (сессия брокера не сохраняется между запусками)")
+	mqttKeepAlive     = flags.Duration("keepalive", mqtt.DefaultKeepAlive, "Интервал keepalive MQTT")
+	mqttTimeout       = flags.Duration("mqtt-timeout", mqtt.DefaultWaitTimeout, "Максимальное время ожидания подключения к брокеру и подтверждения публикации; по истечении публикация считается неудачной")
+	mqttQoS           = flags.Int("qos", 0, "QoS публикации данных, DTC и heartbeat: 0 - без подтверждения, 1 или 2 - брокер подтверждает доставку (PUBACK/PUBCOMP), подтвержденные публикации учитываются в heartbeat")
+	cleanSession      = flags.Bool("clean-session", true, "Начинать MQTT-сессию заново при каждом подключении (false - брокер хранит сессию и команды QoS 1)")
+	publishJitter     = flags.Float64("jitter", 0, "Доля случайного отклонения интервала публикации MQTT, например 0.2 - ±20% (0 - строго по интервалу)")
+	dtcStorm          = flags.Int("dtc-storm", 0, "Число DTC за -dtc-storm-window, при превышении которого коды публикуются сводкой (0 - выключено)")
+	dtcStormWindow    = flags.Duration("dtc-storm-window", 10*time.Second, "Окно подсчета DTC для -dtc-storm")
+	dtcStormDetail    = flags.Duration("dtc-storm-detail", 5*time.Second, "Во время шторма DTC отдельные коды публикуются не чаще раза в этот интервал (0 - только сводка)")
+	statsInterval     = flags.Duration("stats-interval", 5*time.Minute, "Интервал вывода в лог скорости отправки данных (0 - не выводить)")
+	maxPayload        = flags.Int("max-payload", 0, "Максимальный размер сообщения MQTT в байтах; более крупные снимки сокращаются удалением наименее важных полей (0 - без ограничения)")
+	retainData        = flags.Bool("retain", false, "Публиковать снимок данных с флагом retain: новый подписчик сразу получает последнее значение (DTC не сохраняются)")
+	mqttCommandTopic  = flags.String("command_topic", defaultCommandTopic, "MQTT топик для команд")
+	mqttAckTopic      = flags.String("ack_topic", "", "MQTT топик результатов выполнения команд (пусто - топик команд + /ack)")
+	rawTopic          = flags.String("raw_topic", "", "MQTT топик для публикации каждого принятого кадра в исходном виде для отладки (пусто - не публиковать)")
+	rawCANID          = flags.Bool("raw-can-id", false, "Добавлять в кадры -raw_topic составляющие CAN ID: приоритет, PGN, PF/PS, SA (приоритет - только в режиме -can-mode raw)")
+	dtcCANID          = flags.Bool("dtc-can-id", false, "Добавлять в DTC и отчеты DTC составляющие CAN ID кадра DM1/DM2/DM4 (приоритет - только в режиме -can-mode raw)")
+	heartbeatTopic    = flags.String("heartbeat_topic", defaultHeartbeatTopic, "MQTT топик для heartbeat")
+	heartbeatInterval = flags.Duration("heartbeat-interval", time.Minute, "Интервал публикации heartbeat (0 - не публиковать)")
+	canInterface      = flags.String("can-if", defaultCanInterface, "CAN interface name (e.g., can0, vcan0)")
+	canBringup        = flags.Bool("can-bringup", false, "Включать выключенный CAN-интерфейс при запуске со скоростью -can-bitrate через netlink (требует CAP_NET_ADMIN)")
+	canBitrate        = flags.Uint("can-bitrate", 250000, "Скорость CAN-шины в бит/с, задаваемая при -can-bringup (0 - не менять)")
+	busOffRecovery    = flags.Duration("bus-off-recovery", 5*time.Second, "Через какое время перезапускать CAN-контроллер, оставшийся в состоянии bus-off (требует CAP_NET_ADMIN; 0 - не перезапускать, например при настроенном restart-ms)")
+	ackTimeout        = flags.Duration("ack-timeout", DefaultAckTimeout, "Время ожидания подтверждения (ACK/NACK, PGN 0xE800) команды, отправленной блоку")
+	recvTimeout       = flags.Duration("recv-timeout", 500*time.Millisecond, "Время ожидания приема из сокета CAN, после которого чтение проверяет сигнал остановки (0 - без ограничения, остановка закрытием сокета)")
+	canMode           = flags.String("can-mode", canModeJ1939, "Режим сокета CAN: j1939 (CAN_J1939 ядра), raw (CAN_RAW с разбором TP в агенте) или auto")
+	dbPath            = flags.String("dbpath", defaultDbPath, "Path to the bbolt database file for J1939 DTCs")
+	dtcStore          = flags.String("dtc-store", storage.DTCStoreBolt, "Хранилище DTC: bolt (база -dbpath), memory (в памяти, без базы) или none (повтор DTC подавляется только -dtc-window); без базы состояние не сохраняется")
+	readinessSA       = flags.Uint("readiness-sa", 0x00, "Адрес блока OBD (обычно двигатель, 0x00), у которого запрашивается DM5; готовность мониторов (readiness) публикуется только по его ответу")
+	readinessInterval = flags.Duration("readiness-interval", time.Minute, "Интервал повторного запроса DM5 у блока -readiness-sa (0 - только при запуске)")
+	dtcSources        = flags.String("dtc-sa", "", "Адреса источников через запятую, DM1/DM2 от которых принимаются, например 0,0x03 (пусто - от всех)")
+	positionDeadband  = flags.Float64("position-deadband", 0, "Зона нечувствительности GPS, м: координаты обновляются только при смещении дальше этого расстояния (0 - отключено)")
+	dtcMaxKeys        = flags.Int("dtc-max-keys", 0, "Максимальное число кодов в хранилище DTC; при превышении удаляются самые давно зарегистрированные (0 - без ограничения)")
+	dtcGroup          = flags.Bool("dtc-group", false, "Публиковать DM1/DM2 одним сообщением на блок (адрес, лампы, список кодов) вместо отдельных DTC")
+	sensorErrors      = flags.Bool("sensor-errors", true, "Публиковать список sensor_errors - метрик, для которых блок передает индикатор ошибки (0xFE, 0xFExx)")
+	dtcCMVersion      = flags.Int("dtc-cm-version", j1939bits.SPNVersion1, "Версия J1939-73 (1, 2 или 3), по которой разбирается SPN в кодах DM1/DM2/DM4 с битом CM = 1 от старых блоков")
+	dtcOCReporting    = flags.Bool("dtc-oc", false, "Публиковать DTC повторно, когда его счетчик срабатываний (OC) становится больше последнего опубликованного")
+	dtcWindow         = flags.Duration("dtc-window", storage.DefaultDTCWindow, "Окно, в течение которого один и тот же DTC (SPN:FMI) не публикуется повторно независимо от bbolt (0 - отключено)")
+	allowTx           = flags.Bool("allow-tx", false, "Разрешить периодическую отправку собственных PGN на шину (-tx)")
+	txSpec            = flags.String("tx", "", "Периодическая отправка PGN всем узлам: PGN@интервал=данные в hex через запятую, например 0xFF10@1s=0102030405060708 (требует -allow-tx)")
+	vehicleID         = flags.String("vehicle-id", "", "Идентификатор автомобиля для блоков, не передающих VIN: публикуется в vehicle_id и подставляется в {vehicle_id} в топиках; полученный с шины VIN имеет приоритет")
+	jsonNaming        = flags.String("json-naming", string(common.JSONNamingSnake), "Стиль имен полей в публикуемом JSON: snake (engine_rpm) или camel (engineRpm)")
+	jsonAlias         = flags.String("json-alias", "", "Псевдонимы полей публикуемого JSON (снимки данных и DTC): поле=псевдоним через запятую, например engine_rpm=rpm,spn=SPN")
+	tripOffDelay      = flags.Duration("trip-off-delay", analytics.DefaultOffDelay, "Время без работающего двигателя, после которого поездка считается завершенной")
+	stdoutMode        = flags.Bool("stdout", false, "Печатать данные и DTC в stdout в виде JSON-строк вместо отправки в MQTT")
+	csvPath           = flags.String("csv", "", "Путь к CSV-файлу для записи снимков данных (пусто - не писать)")
+	sqlitePath        = flags.String("sqlite", "", "Путь к базе SQLite для локального хранения метрик и DTC (пусто - не писать, требует сборки с -tags sqlite)")
+	sqliteRetain      = flags.Duration("sqlite-retention", 30*24*time.Hour, "Срок хранения записей в SQLite (0 - бессрочно)")
+	smoothing         = flags.String("smooth", "", "Сглаживание метрик скользящим средним: ключ=окно через запятую (например, fuel_level=5,coolant_temp=10)")
+	hysteresis        = flags.String("hysteresis", "", "Публикация по изменению с гистерезисом: ключ=порог[:время] через запятую (например, coolant_temp=1:5s,fuel_level=0.5); изменение, превысившее порог и продержавшееся заданное время, публикуется сразу, не дожидаясь -interval")
+	onChangeInterval  = flags.Duration("on-change-interval", time.Second, "Минимальный интервал между внеочередными публикациями по изменению метрик -hysteresis")
+	strictKeys        = flags.Bool("strict-keys", false, "Отклонять метрики с именами вне реестра известных метрик (иначе только предупреждение в логе)")
+	onceMode          = flags.Bool("once", false, "Собрать один снимок данных, опубликовать его и завершить работу")
+	nullKeys          = flags.String("null-keys", "", "Метрики через запятую, которые всегда включаются в снимок: без значения - как null (по умолчанию отсутствующие метрики опускаются)")
+	onceKeys          = flags.String("once-keys", "engine_rpm,engine_load,fuel_consumption", "Метрики через запятую, которые в режиме -once должны получить значения до публикации")
+	onceTimeout       = flags.Duration("once-timeout", 30*time.Second, "Максимальное время ожидания метрик в режиме -once")
+	selftestMode      = flags.Bool("selftest", false, "Самопроверка установки: прочитать шину в течение -selftest-duration без отправки кадров, вывести число кадров и наблюдавшиеся PGN, проверить подключение к MQTT и завершиться с кодом 0 (пройдена) или 1")
+	selftestDuration  = flags.Duration("selftest-duration", selftest.DefaultDuration, "Время чтения шины при самопроверке (-selftest)")
+	openAttempts      = flags.Int("open-attempts", 10, "Максимальное число попыток открыть CAN-интерфейс при запуске (интерфейс может быть еще не готов после загрузки), 0 - без ограничения")
+	openTimeout       = flags.Duration("open-timeout", time.Minute, "Время, в течение которого повторяются попытки открыть CAN-интерфейс при запуске, 0 - без ограничения")
+	logLevel          = flags.String("log-level", "info", "Уровень логирования: info или debug (меняется во время работы сигналами SIGUSR1/SIGUSR2)")
+	httpAddr          = flags.String("http-addr", "", "Адрес HTTP API для команд сервера, например 0.0.0.0:8080 (пусто - выключен)")
+	httpToken         = flags.String("http-token", "", "Токен HTTP API: запросы принимаются с заголовком Authorization: Bearer <токен>")
+	httpUser          = flags.String("http-user", "", "Имя пользователя Basic-аутентификации HTTP API (пароль - -http-password)")
+	httpPassword      = flags.String("http-password", "", "Пароль Basic-аутентификации HTTP API")
+	httpAuthHealth    = flags.Bool("http-auth-health", false, "Требовать аутентификацию и для /healthz")
+	sequence          = flags.Bool("seq", false, "Добавлять в снимки данных и DTC общий возрастающий номер публикации seq")
+	pprofAddr         = flags.String("pprof-addr", "", "Адрес HTTP-сервера pprof, например 127.0.0.1:6060 (пусто - выключен)")
+	drainTimeout      = flags.Duration("drain-timeout", 5*time.Second, "Сколько при завершении ждать отправки DTC, оставшихся в очереди, 0 - не ждать")
+)
+
+// Main запускает агент J1939 с параметрами командной строки args (без имени программы).
+// Работает до сигнала завершения; ошибки параметров и результат -once и -selftest завершают процесс.
+func Main(args []string) {
+	flags.Parse(args)
+	fromEnv, err := envflag.Apply(flags, envflag.Prefix)
+	if err != nil {
+		log.Fatalf("Ошибка разбора параметров: %v", err)
+	}
+	log.SetOutput(os.Stdout)
+	if *stdoutMode {
+		// stdout занят JSON-строками с данными, поэтому логи пишем в stderr
+		log.SetOutput(os.Stderr)
+	}
+	log.SetFlags(log.LstdFlags | log.Lmicroseconds)
+	log.Printf("Запуск агента J1939 на интерфейсе %s...", *canInterface)
+	if len(fromEnv) > 0 {
+		log.Printf("Параметры из переменных окружения: %s", strings.Join(fromEnv, ", "))
+	}
+
+	level, err := logging.ParseLevel(*logLevel)
+	if err != nil {
+		log.Fatalf("Ошибка разбора параметра -log-level: %v", err)
+	}
+	logging.SetLevel(level)
+	logging.WatchSignals()
+
+	if *pprofAddr != "" {
+		profiling.Serve(*pprofAddr)
+	}
+
+	smoothingWindows, err := filter.ParseWindows(*smoothing)
+	if err != nil {
+		log.Fatalf("Ошибка разбора параметра -smooth: %v", err)
+	}
+	hysteresisConfigs, err := filter.ParseHysteresis(*hysteresis)
+	if err != nil {
+		log.Fatalf("Ошибка разбора параметра -hysteresis: %v", err)
+	}
+	if len(hysteresisConfigs) > 0 && *onChangeInterval <= 0 {
+		log.Fatalf("Параметр -on-change-interval должен быть больше 0: %v", *onChangeInterval)
+	}
+
+	if *mqttQoS < 0 || *mqttQoS > 2 {
+		log.Fatalf("Параметр -qos должен быть 0, 1 или 2: %d", *mqttQoS)
+	}
+	if *publishJitter < 0 || *publishJitter >= 1 {
+		log.Fatalf("Параметр -jitter должен быть в диапазоне [0, 1): %v", *publishJitter)
+	}
+	if *dtcStorm > 0 && *dtcStormWindow <= 0 {
+		log.Fatalf("Параметр -dtc-storm-window должен быть больше 0: %v", *dtcStormWindow)
+	}
+	if *sqlitePath != "" && !sink.SQLiteAvailable() {
+		log.Fatalf("Параметр -sqlite недоступен: агент собран без драйвера SQLite, соберите его с тегом sqlite (go build -tags sqlite)")
+	}
+
+	naming, err := common.ParseJSONNaming(*jsonNaming)
+	if err != nil {
+		log.Fatalf("Ошибка разбора параметра -json-naming: %v", err)
+	}
+	aliases, err := common.ParseFieldAliases(*jsonAlias)
+	if err != nil {
+		log.Fatalf("Ошибка разбора параметра -json-alias: %v", err)
+	}
+
+	dtcSourceList, err := parseAddressList(*dtcSources)
+	if err != nil {
+		log.Fatalf("Ошибка разбора параметра -dtc-sa: %v", err)
+	}
+
+	// 0xFE (нулевой) и 0xFF (глобальный) не адресуют конкретный блок
+	if *readinessSA > 0xFD {
+		log.Fatalf("Параметр -readiness-sa должен быть адресом блока 0x00-0xFD: 0x%X", *readinessSA)
+	}
+
+	if *dtcCMVersion < j1939bits.SPNVersion1 || *dtcCMVersion > j1939bits.SPNVersion3 {
+		log.Fatalf("Параметр -dtc-cm-version должен быть 1, 2 или 3: %d", *dtcCMVersion)
+	}
+
+	broadcasts, err := parseBroadcasts(*txSpec)
+	if err != nil {
+		log.Fatalf("Ошибка разбора параметра -tx: %v", err)
+	}
+	if len(broadcasts) > 0 && !*allowTx {
+		log.Fatalf("Параметр -tx требует явного разрешения отправки на шину: -allow-tx")
+	}
+
+	if *selftestMode {
+		// База не открывается: самопроверка не должна зависеть от состояния агента
+		os.Exit(runSelftest())
+	}
+
+	storeMode, err := storage.ParseDTCStore(*dtcStore)
+	if err != nil {
+		log.Fatalf("Ошибка разбора параметра -dtc-store: %v", err)
+	}
+
+	// Инициализация хранилища DTC; без bbolt (-dtc-store memory/none) db остается nil
+	db, store, errDbOpen := storage.OpenDTCStore(storeMode, *dbPath, *dtcMaxKeys)
+	if errors.Is(errDbOpen, storage.ErrDBLocked) {
+		log.Fatalf("Ошибка открытия bbolt DB: %v. Вероятно, уже запущен другой агент с тем же -dbpath: укажите другой путь или запустите с -dtc-store memory", errDbOpen)
+	}
+	if errDbOpen != nil {
+		log.Fatalf("Ошибка открытия/создания bbolt DB по пути %s: %v", *dbPath, errDbOpen)
+	}
+	switch storeMode {
+	case storage.DTCStoreBolt:
+		log.Printf("Bbolt DB для J1939 DTC инициализирована: %s", *dbPath)
+	case storage.DTCStoreMemory:
+		log.Println("Хранилище DTC в памяти (-dtc-store memory): коды публикуются повторно после перезапуска, состояние не сохраняется")
+	case storage.DTCStoreNone:
+		log.Println("Хранилище DTC отключено (-dtc-store none): дедупликация только окном -dtc-window, состояние не сохраняется")
+	}
+	defer func() {
+		if db != nil { // Проверяем, что db не nil перед закрытием
+			if err := db.Close(); err != nil {
+				log.Printf("Ошибка закрытия bbolt DB: %v", err)
+			}
+		}
+	}()
+
+	// Init CAN bus
+	// Передаем db в NewBus, который затем передаст его в NewFrameProcessor
+	var bus *Bus
+	openPolicy := retry.Policy{MaxAttempts: *openAttempts, Timeout: *openTimeout}
+	err = retry.Do("Открытие CAN-интерфейса "+*canInterface, openPolicy, isTransientCANError, func() (err error) {
+		bringUpCANInterface()
+		bus, err = NewBus(*canInterface, *canMode, *recvTimeout, db)
+		return err
+	})
+	if err != nil {
+		log.Fatalf("Ошибка инициализации шины J1939: %v (%s)", err, canErrorHint(err))
+	}
+
+	log.Printf("Адрес агента на шине J1939: 0x%02X", bus.LocalSA())
+
+	bus.SetAckTimeout(*ackTimeout)
+	bus.SetReadinessRequest(uint8(*readinessSA), *readinessInterval)
+	bus.frameProcessor.SetDTCStore(store)
+	bus.frameProcessor.SetDTCSources(dtcSourceList)
+	bus.frameProcessor.SetDTCWindow(*dtcWindow)
+	bus.frameProcessor.SetOCReporting(*dtcOCReporting)
+	bus.frameProcessor.SetLegacySPNVersion(*dtcCMVersion)
+	bus.frameProcessor.SetSensorErrorReporting(*sensorErrors)
+	bus.frameProcessor.SetDTCCANID(*dtcCANID)
+	if *dtcGroup {
+		bus.EnableDTCGrouping()
+	}
+	bus.frameProcessor.SetPositionDeadband(*positionDeadband)
+	if len(dtcSourceList) > 0 {
+		log.Printf("DM1/DM2 принимаются только от адресов: %v", dtcSourceList)
+	}
+
+	bus.data.SetKnownKeys(metricKeys, *strictKeys)
+	bus.data.SetJSONNaming(naming)
+	bus.data.SetFieldAliases(aliases)
+	if err := bus.data.SetNullKeys(splitKeys(*nullKeys)); err != nil {
+		log.Fatalf("Ошибка разбора параметра -null-keys: %v", err)
+	}
+	bus.data.SetFallbackVehicleID(*vehicleID)
+	restoreAllowedKeys(bus, db)
+	bus.frameProcessor.RestoreVIN()
+	if len(smoothingWindows) > 0 {
+		bus.data.EnableSmoothing(smoothingWindows)
+		log.Printf("Сглаживание включено для метрик: %v", smoothingWindows)
+	}
+	if len(hysteresisConfigs) > 0 {
+		bus.data.EnableHysteresis(hysteresisConfigs)
+		log.Printf("Публикация по изменению включена для метрик: %v (не чаще раза в %v)", hysteresisConfigs, *onChangeInterval)
+	}
+
+	// Обработка кадров начинается до подключения к MQTT: кадры публикуются, когда клиент создан
+	var rawPublisher atomic.Pointer[mqtt.MQTTClient]
+	if *rawTopic != "" && !*stdoutMode {
+		bus.SetFrameObserver(func(frame J1939FrameInfo) {
+			if c := rawPublisher.Load(); c != nil {
+				c.PublishRawFrame(rawFrame(frame, *rawCANID))
+			}
+		})
+	}
+
+	bus.Start()
+	for _, b := range broadcasts {
+		bus.StartBroadcast(b.pgn, b.interval, 0xFF, b.payload)
+	}
+
+	// Init MQTT
+	// Все получатели сериализуют снимки через meter, чтобы учитывался весь объем отправки
+	meter := sink.NewMeter(*statsInterval)
+	dataSource := meter.Source(bus.GetData)
+
+	var publisher sink.Publisher
+	// commandHandler выполняет команды сервера из MQTT и HTTP API; nil в режиме stdout
+	var commandHandler func(cmd common.ServerCommand) error
+	if *stdoutMode {
+		log.Println("Режим stdout: данные печатаются в stdout, MQTT не используется.")
+		if *rawTopic != "" {
+			log.Println("Параметр -raw_topic в режиме stdout не используется")
+		}
+		stdout := sink.NewStdout(*updateInterval, dataSource)
+		stdout.SetFieldAliases(aliases)
+		publisher = stdout
+	} else {
+		mqttConfig := mqtt.MQTTConfig{
+			Broker:            *mqttBroker,
+			ClientID:          mqttClientID(bus.data.VehicleID(), *canInterface),
+			Topic:             *mqttTopic,
+			DTCTopic:          *mqttDTCTopic,
+			CommandTopic:      *mqttCommandTopic,
+			AckTopic:          *mqttAckTopic,
+			RawTopic:          *rawTopic,
+			UpdateInterval:    *updateInterval,
+			KeepAlive:         *mqttKeepAlive,
+			WaitTimeout:       *mqttTimeout,
+			QoS:               byte(*mqttQoS),
+			CleanSession:      *cleanSession,
+			Protocol:          "j1939",
+			HeartbeatTopic:    *heartbeatTopic,
+			HeartbeatInterval: *heartbeatInterval,
+			RetainData:        *retainData,
+			PublishJitter:     *publishJitter,
+			MaxPayloadBytes:   *maxPayload,
+			PrunePriority:     aliases.Keys(naming.Keys(prunePriority)),
+			FieldAliases:      aliases,
+			Sequence:          *sequence,
+		}
+		applyOverrides(bus, &mqttConfig)
+
+		var mqttClient *mqtt.MQTTClient
+		commandHandler = func(cmd common.ServerCommand) error {
+			return handleMQTTCommand(bus, mqttClient, publisher, cmd)
+		}
+		mqttClient = mqtt.NewClient(mqttConfig, dataSource, commandHandler)
+		mqttClient.SetFramesCounter(bus.FramesReceived)
+		mqttClient.SetVINSource(func() string {
+			vin, _ := bus.data.GetString("vin")
+			return vin
+		})
+		mqttClient.SetVehicleIDSource(bus.data.VehicleID)
+		rawPublisher.Store(mqttClient)
+		mqttClient.SetHeartbeatInfo(func() map[string]any {
+			info := map[string]any{
+				"can_interface":  *canInterface,
+				"local_sa":       bus.LocalSA(),
+				"frames_dropped": bus.FramesDropped(),
+				"throughput":     meter.Throughput(),
+			}
+			if health, ok := bus.BusHealth(); ok {
+				info["bus_state"] = health.State
+				info["error_frames"] = health.ErrorFrames
+				info["bus_off_count"] = health.BusOffCount
+			}
+			addStorageInfo(info, db, store)
+			return info
+		})
+		publisher = mqttClient
+	}
+
+	if *csvPath != "" {
+		publisher = sink.Multi{publisher, sink.NewCSV(*csvPath, aliases.Keys(naming.Keys(outputColumns(smoothingWindows))), *updateInterval, dataSource)}
+	}
+
+	if *sqlitePath != "" {
+		publisher = sink.Multi{publisher, sink.NewSQLite(*sqlitePath, *sqliteRetain, *updateInterval, dataSource)}
+	}
+
+	publisher = meter.Wrap(publisher)
+
+	if *dtcStorm > 0 {
+		guard := sink.NewStormGuard(sink.StormConfig{Limit: *dtcStorm, Window: *dtcStormWindow, DetailInterval: *dtcStormDetail}, func(summary sink.StormSummary) {
+			bus.data.Set("dtc_storm", summary)
+			publisher.PublishNow()
+		})
+		publisher = guard.Wrap(publisher)
+	}
+
+	if err := publisher.Connect(); err != nil {
+		log.Fatalf("Ошибка подключения получателей данных: %v", err)
+	}
+	// defer mqttClient.Disconnect() вызывается после выхода из main
+
+	if !*onceMode {
+		publisher.StartPublishing() // Запускаем публикацию основных данных
+		startTripTracking(bus, db, *tripOffDelay, publisher)
+		if len(hysteresisConfigs) > 0 {
+			startChangePublishing(bus, hysteresisConfigs, *onChangeInterval, publisher)
+		}
+		// Смена состояния шины публикуется сразу, не дожидаясь очередного снимка
+		bus.MonitorBusHealth(*busOffRecovery, func(BusHealth) { publisher.PublishNow() })
+	}
+
+	var api *httpapi.Server
+	if *httpAddr != "" && !*onceMode {
+		api = httpapi.New(*httpAddr, httpAuth(), commandHandler)
+		api.SetHealthInfo(func() map[string]any {
+			info := map[string]any{
+				"can_interface":   *canInterface,
+				"local_sa":        bus.LocalSA(),
+				"frames_received": bus.FramesReceived(),
+				"frames_dropped":  bus.FramesDropped(),
+			}
+			if health, ok := bus.BusHealth(); ok {
+				info["bus_state"] = health.State
+				info["bus_off_count"] = health.BusOffCount
+			}
+			return info
+		})
+		api.Start()
+	}
+
+	// Канал для координации завершения горутин
+	done := make(chan struct{})
+
+	// Запуск горутины для отправки DTC по MQTT
+	publishDTC := func(dtc common.DTCCode) {
+		dtc.VehicleID = bus.data.VehicleID()
+		publisher.PublishDTC(dtc)
+	}
+	publishReport := func(report common.DTCReport) {
+		report.VehicleID = bus.data.VehicleID()
+		publisher.PublishDTCReport(report)
+	}
+	dtcDone := make(chan struct{})
+	go func() {
+		defer close(dtcDone)
+		defer func() { log.Println("Горутина отправки DTC завершена.") }()
+		log.Println("Горутина отправки DTC запущена.")
+		for {
+			select {
+			case dtc, ok := <-bus.GetDTCChannel():
+				if !ok {
+					log.Println("Канал DTC закрыт, выход из горутины отправки DTC.")
+					return
+				}
+				publishDTC(dtc)
+			case report, ok := <-bus.GetDTCReportChannel():
+				if !ok {
+					return
+				}
+				publishReport(report)
+			case <-done: // Сигнал для завершения этой горутины
+				log.Println("Получен сигнал 'done', выход из горутины отправки DTC.")
+				deadline := time.Now().Add(*drainTimeout)
+				drainDTCs(bus.GetDTCChannel(), deadline, publishDTC)
+				drainDTCs(bus.GetDTCReportChannel(), deadline, publishReport)
+				return
+			}
+		}
+	}()
+
+	if *onceMode {
+		publishOnce(bus.data, publisher)
+	} else {
+		log.Println("Агент J1939 запущен. Нажмите Ctrl+C для выхода.")
+		// Ожидание сигнала завершения
+		sigChan := make(chan os.Signal, 1)
+		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+
+		// Блокируемся здесь до получения сигнала
+		sig := <-sigChan
+		log.Printf("Получен сигнал %s. Завершение работы...", sig)
+	}
+
+	if api != nil {
+		if err := api.Close(); err != nil {
+			log.Printf("Ошибка остановки HTTP API: %v", err)
+		}
+	}
+
+	// Сигнализируем горутинам о завершении
+	log.Println("Отправка сигнала 'done' в горутины...")
+	close(done)
+
+	// DTC, оставшиеся в очереди, отправляются до отключения от брокера
+	select {
+	case <-dtcDone:
+	case <-time.After(*drainTimeout + time.Second):
+		log.Println("Отправка оставшихся DTC не завершилась вовремя.")
+	}
+
+	// Останавливаем MQTT клиент
+	log.Println("Остановка MQTT клиента...")
+	if !*onceMode {
+		publisher.StopPublishing() // Останавливаем периодическую публикацию
+	}
+	publisher.Disconnect()
+	log.Println("MQTT клиент остановлен.")
+
+	// Останавливаем шину CAN
+	log.Println("Остановка шины J1939...")
+	if err := bus.Stop(); err != nil {
+		log.Printf("Ошибка при остановке шины J1939: %v", err)
+	}
+	log.Println("Шина J1939 остановлена.")
+
+	log.Println("Агент J1939 завершил работу.")
+}
+
+// handleMQTTCommand обрабатывает команды сервера: clear_dtc (DM11 блоку target_mid, по умолчанию 0x00),
+// set_interval, set_topic, resync, set_metrics, request_pgn и reset_config.
+func handleMQTTCommand(bus *Bus, mqttClient *mqtt.MQTTClient, publisher sink.Publisher, cmd common.ServerCommand) error {
+	log.Printf("Получена команда: %+v", cmd)
+
+	switch cmd.Type {
+	case "clear_dtc":
+		var destAddr uint8 = 0x00 // Адрес по умолчанию - двигатель №1
+		if cmd.Params.TargetMID != nil {
+			destAddr = *cmd.Params.TargetMID
+		}
+		if err := bus.ClearActiveDTCs(destAddr); err != nil {
+			return fmt.Errorf("ошибка сброса DTC для SA 0x%02X: %w", destAddr, err)
+		}
+		log.Printf("Команда сброса DTC (DM11) для SA 0x%02X выполнена", destAddr)
+		if store := bus.frameProcessor.dtcStore; store != nil {
+			if err := store.ClearAll(); err != nil {
+				log.Printf("Ошибка очистки хранилища DTC: %v", err)
+			}
+		}
+		return nil
+	case common.CommandTypeSetInterval:
+		if cmd.Params.Interval == nil {
+			return fmt.Errorf("команда %s: не указан параметр interval", cmd.Type)
+		}
+		interval, err := time.ParseDuration(*cmd.Params.Interval)
+		if err != nil {
+			return fmt.Errorf("команда %s: некорректный интервал %q: %w", cmd.Type, *cmd.Params.Interval, err)
+		}
+		if err := mqttClient.SetInterval(interval); err != nil {
+			return fmt.Errorf("команда %s: %w", cmd.Type, err)
+		}
+		log.Printf("Интервал публикации изменен на %v", interval)
+		return saveOverrides(bus, func(o *storage.Overrides) { o.Interval = interval })
+	case common.CommandTypeSetTopic:
+		var topics mqtt.Topics
+		if p := cmd.Params.Topic; p != nil {
+			if *p == "" {
+				return fmt.Errorf("команда %s: пустой параметр topic", cmd.Type)
+			}
+			topics.Topic = *p
+		}
+		if p := cmd.Params.DTCTopic; p != nil {
+			if *p == "" {
+				return fmt.Errorf("команда %s: пустой параметр dtc_topic", cmd.Type)
+			}
+			topics.DTCTopic = *p
+		}
+		if p := cmd.Params.CommandTopic; p != nil {
+			if *p == "" {
+				return fmt.Errorf("команда %s: пустой параметр command_topic", cmd.Type)
+			}
+			topics.CommandTopic = *p
+		}
+		if err := mqttClient.SetTopics(topics); err != nil {
+			return fmt.Errorf("команда %s: %w", cmd.Type, err)
+		}
+		return saveOverrides(bus, func(o *storage.Overrides) {
+			if topics.Topic != "" {
+				o.Topic = topics.Topic
+			}
+			if topics.DTCTopic != "" {
+				o.DTCTopic = topics.DTCTopic
+			}
+			if topics.CommandTopic != "" {
+				o.CommandTopic = topics.CommandTopic
+			}
+		})
+	case common.CommandTypeResync:
+		resync(publisher, bus.frameProcessor.ActiveDTCs(), bus.data.VehicleID())
+		return nil
+	case common.CommandTypeSetMetrics:
+		if cmd.Params.Metrics == nil {
+			return fmt.Errorf("команда %s: не указан параметр metrics", cmd.Type)
+		}
+		keys := *cmd.Params.Metrics
+		if err := bus.data.SetAllowedKeys(keys); err != nil {
+			return fmt.Errorf("команда %s: %w", cmd.Type, err)
+		}
+		logAllowedKeys(keys)
+		return saveOverrides(bus, func(o *storage.Overrides) { o.Metrics = keys })
+	case common.CommandTypeRequestPGN:
+		pgn, dest, err := cmd.Params.RequestPGN()
+		if err != nil {
+			return fmt.Errorf("команда %s: %w", cmd.Type, err)
+		}
+		if err := bus.RequestPGN(pgn, dest); err != nil {
+			return fmt.Errorf("ошибка запроса PGN 0x%X у SA 0x%02X: %w", pgn, dest, err)
+		}
+		log.Printf("Запрос PGN 0x%X отправлен на адрес 0x%02X", pgn, dest)
+		return nil
+	case common.CommandTypeResetConfig:
+		if db := bus.frameProcessor.db; db == nil {
+			log.Println("База не используется (-dtc-store), сохраненных настроек нет")
+		} else if err := storage.ClearOverrides(db); err != nil {
+			return fmt.Errorf("команда %s: ошибка удаления сохраненных настроек: %w", cmd.Type, err)
+		}
+		// Возвращаем значения из флагов
+		_ = bus.data.SetAllowedKeys(nil)
+		if err := mqttClient.SetInterval(*updateInterval); err != nil {
+			return fmt.Errorf("команда %s: %w", cmd.Type, err)
+		}
+		if err := mqttClient.SetTopics(mqtt.Topics{Topic: *mqttTopic, DTCTopic: *mqttDTCTopic, CommandTopic: *mqttCommandTopic}); err != nil {
+			return fmt.Errorf("команда %s: %w", cmd.Type, err)
+		}
+		log.Println("Сохраненные настройки сброшены, действуют значения из флагов")
+		return nil
+	default:
+		return fmt.Errorf("команда %s не поддерживается агентом J1939", cmd.Type)
+	}
+}
+
+// resync публикует текущий снимок данных и активные DTC вне расписания (команда resync).
+// Публикация идет через publisher, как обычная: снимок и коды учитываются в объеме
+// отправки, ограничиваются при шторме DTC и пишутся в CSV/SQLite. Хранилище DTC
+// не проверяется: коды уже могли быть отправлены ранее.
+func resync(publisher sink.Publisher, active []common.DTCCode, vehicleID string) {
+	publisher.PublishNow()
+	for _, dtc := range active {
+		dtc.VehicleID = vehicleID
+		publisher.PublishDTC(dtc)
+	}
+	log.Printf("Resync: опубликованы снимок данных и %d активных DTC", len(active))
+}
+
+// isTransientCANError сообщает, может ли ошибка открытия CAN-интерфейса исчезнуть сама:
+// при загрузке интерфейс может еще не появиться или не быть включен.
+func isTransientCANError(err error) bool {
+	return errors.Is(err, common.ErrInterfaceNotFound) || errors.Is(err, common.ErrSocketBindFailed)
+}
+
+// bringUpCANInterface включает CAN-интерфейс при -can-bringup. Ошибки только выводятся в лог:
+// последующее открытие интерфейса сообщит о проблеме и повторит попытку, если интерфейс еще не появился.
+func bringUpCANInterface() {
+	if !*canBringup {
+		return
+	}
+	err := bringUpCAN(*canInterface, uint32(*canBitrate))
+	switch {
+	case err == nil:
+	case errors.Is(err, common.ErrPermissionDenied):
+		log.Printf("Не удалось включить CAN-интерфейс %s: недостаточно прав (%v). Запустите агент с CAP_NET_ADMIN (например, AmbientCapabilities=CAP_NET_ADMIN в юните systemd) или включите интерфейс заранее: ip link set %s up type can bitrate %d", *canInterface, err, *canInterface, *canBitrate)
+	default:
+		log.Printf("Не удалось включить CAN-интерфейс %s: %v", *canInterface, err)
+	}
+}
+
+// canErrorHint возвращает подсказку по устранению ошибки открытия CAN-интерфейса.
+func canErrorHint(err error) string {
+	switch {
+	case errors.Is(err, common.ErrInterfaceNotFound):
+		return "проверьте параметр -can-if и наличие интерфейса в ip link"
+	case errors.Is(err, common.ErrPermissionDenied):
+		return "запустите агент с правами CAP_NET_RAW или от root"
+	case errors.Is(err, common.ErrSocketBindFailed):
+		return "проверьте, что интерфейс включен: ip link set <интерфейс> up type can bitrate 250000"
+	case errors.Is(err, common.ErrSocketUnavailable):
+		return "для CAN_J1939 нужен модуль ядра can-j1939, иначе используйте -can-mode raw"
+	default:
+		return "проверьте параметры -can-if и -can-mode"
+	}
+}
+
+// restoreAllowedKeys применяет сохраненный командой set_metrics список метрик.
+func restoreAllowedKeys(bus *Bus, db *bolt.DB) {
+	if db == nil {
+		return
+	}
+	o, err := storage.LoadOverrides(db)
+	if err != nil {
+		log.Printf("Ошибка чтения сохраненных настроек: %v", err)
+		return
+	}
+	if len(o.Metrics) == 0 {
+		return
+	}
+	if err := bus.data.SetAllowedKeys(o.Metrics); err != nil {
+		log.Printf("Сохраненный список метрик не применен: %v", err)
+		return
+	}
+	logAllowedKeys(o.Metrics)
+}
+
+// applyOverrides накладывает сохраненные командами сервера интервал и топики поверх флагов.
+// Список метрик применяется отдельно (restoreAllowedKeys), так как нужен и без MQTT.
+func applyOverrides(bus *Bus, config *mqtt.MQTTConfig) {
+	db := bus.frameProcessor.db
+	if db == nil {
+		return
+	}
+	o, err := storage.LoadOverrides(db)
+	if err != nil {
+		log.Printf("Ошибка чтения сохраненных настроек, используются флаги: %v", err)
+		return
+	}
+	if o.Interval == 0 && o.Topic == "" && o.DTCTopic == "" && o.CommandTopic == "" {
+		return
+	}
+	if o.Interval > 0 {
+		config.UpdateInterval = o.Interval
+	}
+	if o.Topic != "" {
+		config.Topic = o.Topic
+	}
+	if o.DTCTopic != "" {
+		config.DTCTopic = o.DTCTopic
+	}
+	if o.CommandTopic != "" {
+		config.CommandTopic = o.CommandTopic
+	}
+	log.Printf("Применены сохраненные настройки: интервал %v, топики %q, %q, %q", config.UpdateInterval, config.Topic, config.DTCTopic, config.CommandTopic)
+}
+
+// saveOverrides сохраняет изменение настроек, чтобы оно пережило перезапуск агента.
+func saveOverrides(bus *Bus, update func(o *storage.Overrides)) error {
+	db := bus.frameProcessor.db
+	if db == nil {
+		return fmt.Errorf("настройка применена, но не сохранена: база не используется (-dtc-store)")
+	}
+	if err := storage.UpdateOverrides(db, update); err != nil {
+		return fmt.Errorf("настройка применена, но не сохранена: %w", err)
+	}
+	return nil
+}
+
+// logAllowedKeys выводит в лог список метрик, заданный командой set_metrics.
+func logAllowedKeys(keys []string) {
+	if len(keys) == 0 {
+		log.Println("Ограничение списка метрик снято, публикуются все метрики")
+		return
+	}
+	log.Printf("Публикуются только метрики: %v", keys)
+}
+
+// drainDTCs отправляет DTC или отчеты DTC, уже находящиеся в очереди ch, до deadline.
+// Вызывается при завершении, чтобы не терять последние неисправности сеанса;
+// новых DTC не ждет.
+func drainDTCs[T any](ch <-chan T, deadline time.Time, publish func(T)) {
+	sent := 0
+	defer func() {
+		if sent > 0 {
+			log.Printf("При завершении отправлено оставшихся в очереди DTC: %d", sent)
+		}
+	}()
+	for time.Now().Before(deadline) {
+		select {
+		case item, ok := <-ch:
+			if !ok {
+				return
+			}
+			publish(item)
+			sent++
+		default:
+			return
+		}
+	}
+	if left := len(ch); left > 0 {
+		log.Printf("Время отправки при завершении истекло, не отправлено DTC: %d", left)
+	}
+}
+
+// rawFrame преобразует кадр для публикации в -raw_topic; withID добавляет составляющие CAN ID.
+func rawFrame(frame J1939FrameInfo, withID bool) common.RawFrame {
+	raw := common.RawFrame{
+		PGN:       int(frame.PGN),
+		SA:        int(frame.SA),
+		Data:      hex.EncodeToString(frame.Data),
+		Timestamp: frame.Timestamp.UnixNano(),
+	}
+	if withID {
+		id := frame.IDComponents()
+		raw.CANID = &id
+	}
+	return raw
+}
+
+// splitKeys разбирает список имен метрик через запятую, пропуская пустые.
+func splitKeys(list string) []string {
+	var keys []string
+	for _, key := range strings.Split(list, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// publishOnce ждет значений метрик -once-keys не дольше -once-timeout
+// и публикует один снимок данных. Если метрики не получены, публикуется неполный снимок.
+func publishOnce(data *ProtectedData, publisher sink.Publisher) {
+	keys := splitKeys(*onceKeys)
+
+	log.Printf("Режим -once: ожидание метрик %v (не дольше %v)...", keys, *onceTimeout)
+	if missing := data.WaitForKeys(keys, *onceTimeout); len(missing) > 0 {
+		log.Printf("Режим -once: метрики %v не получены, публикуется неполный снимок", missing)
+	}
+	publisher.PublishNow()
+	log.Println("Режим -once: снимок данных опубликован")
+}
+
+// mqttClientID возвращает идентификатор клиента MQTT: значение -client-id или
+// постоянный идентификатор из VIN (если он известен при запуске, например сохранен ранее),
+// иначе из имени хоста, и интерфейса шины.
+func mqttClientID(vehicleID, iface string) string {
+	if *clientID != "" {
+		return *clientID
+	}
+	if vehicleID == "" {
+		vehicleID, _ = os.Hostname()
+	}
+	return mqtt.DeriveClientID("j1939-agent", *randomClientID, vehicleID, iface)
+}
+
+// httpAuth возвращает параметры аутентификации HTTP API из флагов.
+func httpAuth() httpapi.Auth {
+	return httpapi.Auth{Token: *httpToken, User: *httpUser, Password: *httpPassword, ProtectHealth: *httpAuthHealth}
+}
+
+// addStorageInfo добавляет в сведения heartbeat размер базы bbolt (db_size_bytes)
+// и число кодов в хранилище DTC (dtc_store_keys), если они используются.
+func addStorageInfo(info map[string]any, db *bolt.DB, store storage.DTCStore) {
+	if db != nil {
+		if size, err := storage.DBSize(db); err == nil {
+			info["db_size_bytes"] = size
+		}
+	}
+	if store != nil {
+		if n, err := store.Len(); err == nil {
+			info["dtc_store_keys"] = n
+		}
+	}
+}
+
+// tripSample возвращает текущие значения метрик для трекера поездок.
+func tripSample(bus *Bus) analytics.Sample {
+	rpm, rpmOK := bus.data.GetFloat64("engine_rpm")
+	odometer, odometerOK := bus.data.GetFloat64("total_distance")
+	speed, speedOK := bus.data.GetFloat64("front_axle_speed")
+	return analytics.Sample{
+		RPM:        rpm,
+		RPMOK:      rpmOK,
+		Odometer:   odometer,
+		OdometerOK: odometerOK,
+		Speed:      speed,
+		SpeedOK:    speedOK,
+		Frames:     bus.FramesReceived(),
+	}
+}
+
+// startChangePublishing публикует снимок вне расписания, когда подтверждено изменение
+// одной из метрик -hysteresis. Изменения проверяются раз в interval, поэтому
+// внеочередные публикации происходят не чаще.
+func startChangePublishing(bus *Bus, keys map[string]filter.HysteresisConfig, interval time.Duration, publisher sink.Publisher) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-bus.stopChan:
+				return
+			case <-ticker.C:
+				for _, key := range bus.data.TakeChanged() {
+					if _, ok := keys[key]; ok {
+						logging.Debugf("Подтверждено изменение %s, внеочередная публикация", key)
+						publisher.PublishNow()
+						break
+					}
+				}
+			}
+		}
+	}()
+}
+
+// startTripTracking запускает трекер поездок. Показатели текущей поездки обновляются
+// в метрике trip, итоги завершенной сохраняются в last_trip и сразу публикуются,
+// не дожидаясь интервала.
+func startTripTracking(bus *Bus, db *bolt.DB, offDelay time.Duration, publisher sink.Publisher) {
+	tracker := analytics.NewTripTracker(db, offDelay)
+	sample := func() analytics.Sample { return tripSample(bus) }
+	onSample := func(current *common.TripSummary) {
+		if current == nil {
+			bus.data.Set("trip", nil)
+			return
+		}
+		bus.data.Set("trip", *current)
+	}
+	onEnd := func(summary common.TripSummary) {
+		bus.data.Set("last_trip", summary)
+		publisher.PublishNow()
+	}
+	go tracker.Run(bus.stopChan, sample, onSample, onEnd)
+}
+
+// parseAddressList разбирает список адресов J1939 через запятую (десятичных или 0x...).
+func parseAddressList(spec string) ([]uint8, error) {
+	var addrs []uint8
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		v, err := strconv.ParseUint(part, 0, 8)
+		if err != nil {
+			return nil, fmt.Errorf("некорректный адрес %q: %w", part, err)
+		}
+		addrs = append(addrs, uint8(v))
+	}
+	return addrs, nil
+}
+
+// broadcast - периодическая отправка PGN с постоянными данными (флаг -tx).
+type broadcast struct {
+	pgn      uint32
+	interval time.Duration
+	data     []byte
+}
+
+func (b broadcast) payload() ([]byte, bool) {
+	return b.data, true
+}
+
+// parseBroadcasts разбирает список "PGN@интервал=данные" через запятую.
+func parseBroadcasts(spec string) ([]broadcast, error) {
+	var result []broadcast
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		pgnPart, rest, ok1 := strings.Cut(part, "@")
+		intervalPart, dataPart, ok2 := strings.Cut(rest, "=")
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("%q: ожидается PGN@интервал=данные", part)
+		}
+		pgn, err := strconv.ParseUint(pgnPart, 0, 18)
+		if err != nil {
+			return nil, fmt.Errorf("%q: некорректный PGN: %w", part, err)
+		}
+		interval, err := time.ParseDuration(intervalPart)
+		if err != nil || interval <= 0 {
+			return nil, fmt.Errorf("%q: некорректный интервал %q", part, intervalPart)
+		}
+		data, err := hex.DecodeString(dataPart)
+		if err != nil {
+			return nil, fmt.Errorf("%q: некорректные данные: %w", part, err)
+		}
+		result = append(result, broadcast{pgn: uint32(pgn), interval: interval, data: data})
+	}
+	return result, nil
+}
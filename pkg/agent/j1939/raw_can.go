@@ -0,0 +1,108 @@
+//go:build linux
+
+package j1939
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/serebryakov7/j1708-stats/common"
+)
+
+const (
+	canFrameSize  = 16 // sizeof(struct can_frame)
+	canMaxDataLen = 8  // Байт данных в классическом кадре CAN
+
+	// rawModeSA - адрес источника агента в режиме CAN_RAW, где ядро не выполняет
+	// назначение адреса. 0xF9 - Off-board Diagnostic-Service Tool #1.
+	rawModeSA uint8 = 0xF9
+	// rawModePriority - приоритет отправляемых кадров.
+	rawModePriority = 6
+)
+
+// openRawSocket открывает сокет CAN_RAW на интерфейсе canInterface.
+func openRawSocket(canInterface string) (int, int, error) {
+	fd, err := unix.Socket(unix.AF_CAN, unix.SOCK_RAW, unix.CAN_RAW)
+	if err != nil {
+		return -1, 0, fmt.Errorf("%w: не удалось создать сокет CAN_RAW: %w", common.ErrSocketUnavailable, err)
+	}
+
+	iface, err := net.InterfaceByName(canInterface)
+	if err != nil {
+		unix.Close(fd)
+		return -1, 0, fmt.Errorf("%w: %q: %w", common.ErrInterfaceNotFound, canInterface, err)
+	}
+
+	if err := unix.Bind(fd, &unix.SockaddrCAN{Ifindex: iface.Index}); err != nil {
+		unix.Close(fd)
+		return -1, 0, fmt.Errorf("%w: CAN_RAW к %s: %w", common.ErrSocketBindFailed, canInterface, err)
+	}
+	return fd, iface.Index, nil
+}
+
+// setRecvTimeout ограничивает время ожидания данных в сокете fd (SO_RCVTIMEO):
+// чтение возвращает EAGAIN, если за timeout ничего не принято. 0 - ждать без ограничения.
+func setRecvTimeout(fd int, timeout time.Duration) error {
+	if timeout <= 0 {
+		return nil
+	}
+	tv := unix.NsecToTimeval(timeout.Nanoseconds())
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+		return fmt.Errorf("не удалось задать время ожидания приема: %w", err)
+	}
+	return nil
+}
+
+// parseCANID разбирает 29-битный идентификатор J1939 на PGN, адрес источника
+// и адрес назначения. Для PDU1 (PF < 240) поле PS - адрес назначения и в PGN не входит,
+// для PDU2 адрес назначения - глобальный (0xFF).
+func parseCANID(id uint32) (pgn uint32, sa uint8, da uint8) {
+	sa = uint8(id)
+	pgn = (id >> 8) & 0x3FFFF
+	pf := uint8(pgn >> 8)
+	da = 0xFF
+	if pf < 240 {
+		da = uint8(pgn)
+		pgn &= 0x3FF00
+	}
+	return pgn, sa, da
+}
+
+// buildCANID собирает 29-битный идентификатор J1939.
+func buildCANID(priority uint8, pgn uint32, sa uint8, da uint8) uint32 {
+	id := uint32(priority&0x07)<<26 | (pgn&0x3FFFF)<<8 | uint32(sa)
+	if uint8(pgn>>8) < 240 {
+		id = id&^0xFF00 | uint32(da)<<8
+	}
+	return id
+}
+
+// decodeCANFrame разбирает struct can_frame. Возвращает false для кадров,
+// не относящихся к J1939 (стандартный 11-битный ID, RTR, кадры ошибок).
+func decodeCANFrame(buf []byte) (id uint32, data []byte, ok bool) {
+	if len(buf) < canFrameSize {
+		return 0, nil, false
+	}
+	rawID := binary.NativeEndian.Uint32(buf[0:4])
+	if rawID&unix.CAN_EFF_FLAG == 0 || rawID&(unix.CAN_RTR_FLAG|unix.CAN_ERR_FLAG) != 0 {
+		return 0, nil, false
+	}
+	dlc := int(buf[4])
+	if dlc > canMaxDataLen {
+		dlc = canMaxDataLen
+	}
+	return rawID & unix.CAN_EFF_MASK, buf[8 : 8+dlc], true
+}
+
+// encodeCANFrame формирует struct can_frame с расширенным идентификатором.
+func encodeCANFrame(id uint32, data []byte) []byte {
+	buf := make([]byte, canFrameSize)
+	binary.NativeEndian.PutUint32(buf[0:4], id|unix.CAN_EFF_FLAG)
+	buf[4] = byte(len(data))
+	copy(buf[8:], data)
+	return buf
+}
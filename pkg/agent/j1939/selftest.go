@@ -0,0 +1,54 @@
+package j1939
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/serebryakov7/j1708-stats/pkg/selftest"
+)
+
+// runSelftest читает шину в течение -selftest-duration, проверяет подключение к MQTT
+// (кроме режима -stdout) и выводит отчет. Кадры на шину не отправляются (запросы DM5
+// и VIN, -tx). Возвращает код завершения: 0 - самопроверка пройдена, 1 - нет.
+func runSelftest() int {
+	report := selftest.NewReport("j1939", *canInterface, *selftestDuration)
+
+	bringUpCANInterface()
+	bus, err := NewBus(*canInterface, *canMode, *recvTimeout, nil)
+	if err != nil {
+		log.Printf("Самопроверка НЕ ПРОЙДЕНА: ошибка открытия CAN-интерфейса: %v (%s)", err, canErrorHint(err))
+		return 1
+	}
+	bus.SetFrameObserver(func(frame J1939FrameInfo) {
+		report.Observe(fmt.Sprintf("PGN 0x%04X", frame.PGN))
+	})
+
+	log.Printf("Самопроверка: чтение %s в течение %v...", *canInterface, *selftestDuration)
+	// Без Start: он отправляет запросы на шину
+	bus.startReader(bus.readFrames)
+	go bus.processFrames()
+	// DTC в самопроверке не публикуются
+	go func() {
+		for range bus.GetDTCChannel() {
+		}
+	}()
+	time.Sleep(*selftestDuration)
+	bus.Stop()
+	report.Frames = bus.FramesReceived()
+	if malformed := bus.frameProcessor.MalformedFrames(); malformed < report.Frames {
+		report.Valid = report.Frames - malformed
+	}
+
+	if !*stdoutMode {
+		log.Printf("Самопроверка: подключение к MQTT брокеру %s...", *mqttBroker)
+		clientID := mqttClientID("", *canInterface) + "-selftest"
+		report.SetMQTTResult(*mqttBroker, selftest.CheckMQTT(*mqttBroker, clientID))
+	}
+
+	report.Log()
+	if !report.Passed() {
+		return 1
+	}
+	return 0
+}
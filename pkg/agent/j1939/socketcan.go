@@ -0,0 +1,244 @@
+//go:build linux
+
+package j1939
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/serebryakov7/j1708-stats/common"
+)
+
+// socketCANSource - источник кадров J1939 на основе SocketCAN (CAN_J1939 или CAN_RAW).
+type socketCANSource struct {
+	fd         int // Сырой файловый дескриптор сокета
+	ifaceIndex int
+	localSA    uint8
+	rawMode    bool           // Сокет CAN_RAW вместо CAN_J1939
+	tp         *tpReassembler // Сборка TP в режиме CAN_RAW
+	buffer     []byte
+	oob        []byte
+	closeOnce  sync.Once
+}
+
+// openSocketCAN открывает сокет в выбранном режиме (см. canModeJ1939, canModeRaw, canModeAuto) и привязывает его.
+// recvTimeout > 0 ограничивает ожидание кадра в Recv (SO_RCVTIMEO).
+func openSocketCAN(canInterface string, canMode string, recvTimeout time.Duration) (frameSource, error) {
+	var (
+		s   *socketCANSource
+		err error
+	)
+	switch canMode {
+	case canModeJ1939, canModeAuto:
+		s, err = openJ1939(canInterface)
+		if err != nil && canMode == canModeAuto {
+			log.Printf("Сокет CAN_J1939 недоступен (%v), переход в режим CAN_RAW", err)
+			s, err = openRaw(canInterface)
+		}
+	case canModeRaw:
+		s, err = openRaw(canInterface)
+	default:
+		return nil, fmt.Errorf("неизвестный режим CAN %q, ожидается %s, %s или %s", canMode, canModeJ1939, canModeRaw, canModeAuto)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := setRecvTimeout(s.fd, recvTimeout); err != nil {
+		s.Close()
+		return nil, err
+	}
+	s.buffer = make([]byte, 2048)   // Буфер для чтения данных кадра J1939 (макс. размер TP пакета ~1785 байт)
+	s.oob = make([]byte, rxOOBSize) // Буфер для метки времени приема
+	if err := enableRxTimestamps(s.fd); err != nil {
+		log.Printf("Метки времени приема ядра недоступны (%v), используется время чтения из сокета", err)
+	}
+	return s, nil
+}
+
+// openJ1939 открывает J1939 SOCK_DGRAM сокет. Адресацию и TP выполняет ядро.
+func openJ1939(canInterface string) (*socketCANSource, error) {
+	fd, err := unix.Socket(unix.AF_CAN, unix.SOCK_DGRAM, unix.CAN_J1939)
+	if err != nil {
+		return nil, fmt.Errorf("%w: не удалось создать сокет J1939: %w", common.ErrSocketUnavailable, err)
+	}
+
+	iface, err := net.InterfaceByName(canInterface)
+	if err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("%w: %q: %w", common.ErrInterfaceNotFound, canInterface, err)
+	}
+
+	// J1939_NO_ADDR (обычно 0) используется для динамического назначения адреса ядром
+	// J1939_NO_NAME (0) и J1939_NO_PGN (0) для wildcard привязки
+	sa := &unix.SockaddrCANJ1939{
+		Ifindex: iface.Index,
+		Name:    0, // J1939_NO_NAME
+		PGN:     0, // J1939_NO_PGN (wildcard PGN for reception)
+		Addr:    0, // Заменяем unix.J1939_NO_ADDR на 0 для динамического назначения адреса
+	}
+
+	if err := unix.Bind(fd, sa); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("%w: J1939 к %s: %w", common.ErrSocketBindFailed, canInterface, err)
+	}
+
+	// Получаем назначенный адрес источника (SA)
+	localSockAddr, err := unix.Getsockname(fd)
+	if err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("не удалось получить имя сокета J1939: %w", err)
+	}
+
+	j1939LocalAddr, ok := localSockAddr.(*unix.SockaddrCANJ1939)
+	if !ok {
+		unix.Close(fd)
+		return nil, fmt.Errorf("неожиданный тип адреса сокета после привязки: %T", localSockAddr)
+	}
+	log.Printf("Сокет J1939 привязан, назначенный SA: 0x%02X (%d) на интерфейсе %s (ifindex %d)", j1939LocalAddr.Addr, j1939LocalAddr.Addr, canInterface, iface.Index)
+
+	return &socketCANSource{
+		fd:         fd,
+		ifaceIndex: iface.Index,
+		localSA:    j1939LocalAddr.Addr,
+	}, nil
+}
+
+// openRaw открывает сокет CAN_RAW. PGN и адреса извлекаются из CAN ID,
+// многопакетные сообщения собираются tpReassembler.
+func openRaw(canInterface string) (*socketCANSource, error) {
+	fd, ifindex, err := openRawSocket(canInterface)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Сокет CAN_RAW привязан на интерфейсе %s (ifindex %d), SA агента: 0x%02X", canInterface, ifindex, rawModeSA)
+
+	return &socketCANSource{
+		fd:         fd,
+		ifaceIndex: ifindex,
+		localSA:    rawModeSA,
+		rawMode:    true,
+		tp:         newTPReassembler(),
+	}, nil
+}
+
+// LocalSA возвращает адрес источника агента на шине.
+func (s *socketCANSource) LocalSA() uint8 {
+	return s.localSA
+}
+
+// Recv блокируется до получения очередного кадра J1939 или до истечения времени ожидания
+// (errRecvTimeout). После Close возвращает ошибку, удовлетворяющую errors.Is(err, net.ErrClosed).
+func (s *socketCANSource) Recv() (J1939FrameInfo, error) {
+	for {
+		n, oobn, _, from, err := unix.Recvmsg(s.fd, s.buffer, s.oob, 0)
+		if err != nil {
+			if errors.Is(err, unix.EAGAIN) {
+				return J1939FrameInfo{}, errRecvTimeout
+			}
+			// Ошибка syscall.EBADF (Bad file descriptor) означает, что сокет был закрыт.
+			if errors.Is(err, unix.EBADF) {
+				return J1939FrameInfo{}, fmt.Errorf("Recvmsg: %w", net.ErrClosed)
+			}
+			return J1939FrameInfo{}, err
+		}
+
+		if n == 0 { // Нет данных, или отправитель закрыл соединение (не типично для DGRAM)
+			continue
+		}
+
+		rxTime, ok := rxTimestamp(s.oob[:oobn])
+		if !ok {
+			rxTime = time.Now()
+		}
+
+		if frameInfo, ok := s.decodeFrame(s.buffer[:n], from, rxTime); ok {
+			return frameInfo, nil
+		}
+	}
+}
+
+// decodeFrame преобразует принятые из сокета данные в J1939FrameInfo.
+// В режиме CAN_RAW кадры TP не передаются дальше, пока сообщение не собрано целиком.
+func (s *socketCANSource) decodeFrame(buf []byte, from unix.Sockaddr, rxTime time.Time) (J1939FrameInfo, bool) {
+	if s.rawMode {
+		id, data, ok := decodeCANFrame(buf)
+		if !ok {
+			return J1939FrameInfo{}, false
+		}
+		pgn, sa, da := parseCANID(id)
+		if pgn == pgnTPCM || pgn == pgnTPDT {
+			return s.tp.handle(pgn, sa, da, data, rxTime)
+		}
+		// Копируем данные, так как buffer будет перезаписан
+		return J1939FrameInfo{PGN: pgn, SA: sa, Data: append([]byte(nil), data...), Timestamp: rxTime, CANID: id, HasCANID: true}, true
+	}
+
+	sockAddr, ok := from.(*unix.SockaddrCANJ1939)
+	if !ok {
+		log.Printf("Получен кадр от неизвестного типа адреса: %T", from)
+		return J1939FrameInfo{}, false
+	}
+
+	// Копируем данные, так как buffer будет перезаписан
+	frameData := make([]byte, len(buf))
+	copy(frameData, buf)
+
+	return J1939FrameInfo{
+		PGN:       sockAddr.PGN,
+		SA:        sockAddr.Addr, // Адрес источника
+		Data:      frameData,
+		Timestamp: rxTime,
+	}, true
+}
+
+// MaxPayload возвращает максимальный размер отправляемого сообщения.
+// В режиме CAN_J1939 сообщения длиннее 8 байт ядро передает через TP,
+// в режиме CAN_RAW отправляется только одиночный кадр.
+func (s *socketCANSource) MaxPayload() int {
+	if s.rawMode {
+		return canMaxDataLen
+	}
+	return tpMaxSize
+}
+
+// Send отправляет сообщение с указанным PGN на адрес destAddr.
+func (s *socketCANSource) Send(pgn uint32, data []byte, destAddr uint8) error {
+	if s.rawMode {
+		id := buildCANID(rawModePriority, pgn, s.localSA, destAddr)
+		if _, err := unix.Write(s.fd, encodeCANFrame(id, data)); err != nil {
+			return fmt.Errorf("ошибка отправки J1939 команды через CAN_RAW: %w", err)
+		}
+		return nil
+	}
+
+	// Адрес назначения для SockaddrCANJ1939
+	destSockAddr := &unix.SockaddrCANJ1939{
+		Ifindex: s.ifaceIndex, // Используем сохраненный индекс интерфейса
+		Name:    0,            // J1939_NO_NAME
+		PGN:     pgn,          // PGN для отправки
+		Addr:    destAddr,     // Адрес назначения
+	}
+
+	// Флаги для Sendto обычно 0 для J1939
+	if err := unix.Sendto(s.fd, data, 0, destSockAddr); err != nil {
+		return fmt.Errorf("ошибка отправки J1939 команды через unix.Sendto: %w", err)
+	}
+	return nil
+}
+
+// Close закрывает сокет. Без времени ожидания закрытие прерывает блокирующий Recv.
+func (s *socketCANSource) Close() error {
+	err := net.ErrClosed
+	s.closeOnce.Do(func() {
+		log.Printf("Закрытие J1939 сокета (fd %d)...", s.fd)
+		err = unix.Close(s.fd)
+	})
+	return err
+}
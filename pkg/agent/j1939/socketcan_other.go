@@ -0,0 +1,15 @@
+//go:build !linux
+
+package j1939
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/serebryakov7/j1708-stats/common"
+)
+
+// openSocketCAN недоступен вне Linux: SocketCAN есть только в ядре Linux.
+func openSocketCAN(canInterface string, canMode string, recvTimeout time.Duration) (frameSource, error) {
+	return nil, fmt.Errorf("%w: SocketCAN поддерживается только в Linux", common.ErrSocketUnavailable)
+}
@@ -0,0 +1,49 @@
+//go:build linux
+
+package j1939
+
+import (
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// rxTimestampFlags запрашивает у ядра программную метку времени приема, которую
+// драйвер ставит по часам реального времени. Аппаратная не запрашивается: у многих
+// CAN-контроллеров это счетчик от включения, а не время, и по нему нельзя
+// восстановить момент приема.
+const rxTimestampFlags = unix.SOF_TIMESTAMPING_RX_SOFTWARE |
+	unix.SOF_TIMESTAMPING_SOFTWARE
+
+// rxOOBSize - размер буфера вспомогательных данных для struct scm_timestamping.
+var rxOOBSize = unix.CmsgSpace(3 * int(unsafe.Sizeof(unix.Timespec{})))
+
+// enableRxTimestamps включает доставку меток времени приема через recvmsg.
+func enableRxTimestamps(fd int) error {
+	return unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_TIMESTAMPING, rxTimestampFlags)
+}
+
+// rxTimestamp извлекает метку времени приема из вспомогательных данных recvmsg.
+// struct scm_timestamping содержит три timespec: [0] - программная метка,
+// [2] - аппаратная. Используется только программная (см. rxTimestampFlags).
+func rxTimestamp(oob []byte) (time.Time, bool) {
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return time.Time{}, false
+	}
+	for _, msg := range msgs {
+		if msg.Header.Level != unix.SOL_SOCKET || msg.Header.Type != unix.SO_TIMESTAMPING {
+			continue
+		}
+		tsSize := int(unsafe.Sizeof(unix.Timespec{}))
+		if len(msg.Data) < 3*tsSize {
+			continue
+		}
+		stamps := (*[3]unix.Timespec)(unsafe.Pointer(&msg.Data[0]))
+		if stamps[0].Sec != 0 || stamps[0].Nsec != 0 {
+			return time.Unix(stamps[0].Unix()), true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,112 @@
+package j1939
+
+import (
+	"log"
+	"time"
+)
+
+// Транспортный протокол J1939 (J1939-21) для сообщений длиннее 8 байт.
+// В режиме CAN_J1939 сборку выполняет ядро, в режиме CAN_RAW - tpReassembler.
+const (
+	pgnTPCM uint32 = 0xEC00 // TP.CM - управление соединением
+	pgnTPDT uint32 = 0xEB00 // TP.DT - передача данных
+
+	tpCMRTS   = 16  // Request To Send (соединение точка-точка)
+	tpCMBAM   = 32  // Broadcast Announce Message
+	tpCMAbort = 255 // Connection Abort
+
+	tpMaxSize   = 1785                    // Максимальный размер сообщения TP
+	tpTimeout   = 1250 * time.Millisecond // Таймаут T1/T4 между пакетами
+	tpDTPayload = 7                       // Байт данных в одном пакете TP.DT
+)
+
+// tpSession - состояние сборки одного многопакетного сообщения.
+type tpSession struct {
+	pgn      uint32
+	size     int
+	packets  int
+	nextSeq  int
+	data     []byte
+	lastSeen time.Time
+}
+
+// tpReassembler пассивно собирает сообщения TP (BAM и RTS/CTS), наблюдая за шиной.
+// Сессии различаются парой адресов источника и назначения.
+type tpReassembler struct {
+	sessions map[uint16]*tpSession
+}
+
+func newTPReassembler() *tpReassembler {
+	return &tpReassembler{sessions: make(map[uint16]*tpSession)}
+}
+
+// handle обрабатывает кадр TP.CM или TP.DT. Возвращает собранное сообщение и true,
+// когда получен последний пакет.
+func (r *tpReassembler) handle(pgn uint32, sa, da uint8, data []byte, now time.Time) (J1939FrameInfo, bool) {
+	key := uint16(sa)<<8 | uint16(da)
+	switch pgn {
+	case pgnTPCM:
+		r.handleCM(key, sa, data, now)
+	case pgnTPDT:
+		return r.handleDT(key, sa, data, now)
+	}
+	return J1939FrameInfo{}, false
+}
+
+func (r *tpReassembler) handleCM(key uint16, sa uint8, data []byte, now time.Time) {
+	if len(data) < 8 {
+		return
+	}
+	switch data[0] {
+	case tpCMRTS, tpCMBAM:
+		size := int(data[1]) | int(data[2])<<8
+		packets := int(data[3])
+		pgn := uint32(data[5]) | uint32(data[6])<<8 | uint32(data[7])<<16
+		if size < 9 || size > tpMaxSize || packets != (size+tpDTPayload-1)/tpDTPayload {
+			log.Printf("TP: некорректное объявление от SA 0x%02X: размер %d, пакетов %d", sa, size, packets)
+			delete(r.sessions, key)
+			return
+		}
+		r.sessions[key] = &tpSession{
+			pgn:      pgn,
+			size:     size,
+			packets:  packets,
+			nextSeq:  1,
+			data:     make([]byte, 0, packets*tpDTPayload),
+			lastSeen: now,
+		}
+	case tpCMAbort:
+		delete(r.sessions, key)
+	}
+}
+
+func (r *tpReassembler) handleDT(key uint16, sa uint8, data []byte, now time.Time) (J1939FrameInfo, bool) {
+	session, ok := r.sessions[key]
+	if !ok || len(data) < 2 {
+		return J1939FrameInfo{}, false
+	}
+	if now.Sub(session.lastSeen) > tpTimeout || int(data[0]) != session.nextSeq {
+		// Пропущенный пакет или таймаут - сообщение собрать не удастся
+		log.Printf("TP: сессия PGN 0x%X от SA 0x%02X прервана (ожидался пакет %d, получен %d)", session.pgn, sa, session.nextSeq, data[0])
+		delete(r.sessions, key)
+		return J1939FrameInfo{}, false
+	}
+	session.data = append(session.data, data[1:]...)
+	session.nextSeq++
+	session.lastSeen = now
+
+	if session.nextSeq <= session.packets {
+		return J1939FrameInfo{}, false
+	}
+	delete(r.sessions, key)
+	if len(session.data) < session.size {
+		return J1939FrameInfo{}, false
+	}
+	// Временем приема сообщения считается время приема последнего пакета
+	return J1939FrameInfo{
+		PGN:       session.pgn,
+		SA:        sa,
+		Data:      session.data[:session.size],
+		Timestamp: now,
+	}, true
+}
@@ -0,0 +1,240 @@
+// Package analytics вычисляет производные показатели (поездки, время работы на холостом ходу
+// и в движении) по снимкам данных агента.
+// Разборщики протоколов о нем не знают: трекер периодически опрашивает текущие значения метрик.
+package analytics
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/serebryakov7/j1708-stats/common"
+	"github.com/serebryakov7/j1708-stats/pkg/storage"
+)
+
+// DefaultOffDelay - время без работающего двигателя, после которого поездка считается завершенной.
+const DefaultOffDelay = time.Minute
+
+// sampleInterval - период опроса метрик трекером.
+const sampleInterval = time.Second
+
+// persistInterval - период сохранения накопленного времени холостого хода и движения.
+const persistInterval = time.Minute
+
+// movingSpeedKmh - скорость, начиная с которой автомобиль считается движущимся.
+// Меньшие значения (дрожание датчика на стоянке) считаются нулевой скоростью.
+const movingSpeedKmh = 1.0
+
+// Sample - текущие значения метрик, по которым определяется состояние поездки.
+type Sample struct {
+	// RPM - обороты двигателя; RPMOK = false, если значение недоступно.
+	RPM   float64
+	RPMOK bool
+	// Odometer - общий пробег, км; OdometerOK = false, если значение недоступно.
+	Odometer   float64
+	OdometerOK bool
+	// Speed - скорость автомобиля, км/ч; SpeedOK = false, если значение недоступно.
+	Speed   float64
+	SpeedOK bool
+	// Frames - число принятых с шины кадров. Если оно не растет, шина молчит
+	// (зажигание выключено), даже если последние значения метрик еще хранятся.
+	Frames uint64
+}
+
+// TripTracker определяет поездки по работе двигателя: поездка начинается при запуске
+// двигателя (обороты > 0 при активной шине) и завершается, когда двигатель не работает
+// дольше offDelay. За время поездки накапливается время работы двигателя на холостом ходу
+// (скорость около нуля) и в движении. Начало поездки и накопленное время сохраняются
+// в bbolt и переживают перезапуск агента.
+type TripTracker struct {
+	mutex    sync.Mutex
+	db       *bolt.DB
+	offDelay time.Duration
+
+	active      bool
+	start       time.Time
+	startOdo    *float64
+	lastOdo     *float64
+	lastRunning time.Time
+	lastFrames  uint64
+	// idle, moving - накопленное за поездку время холостого хода и движения.
+	idle, moving time.Duration
+	lastUpdate   time.Time
+	lastPersist  time.Time
+}
+
+// NewTripTracker создает трекер и восстанавливает незавершенную поездку из db.
+// db может быть nil - тогда начало поездки не сохраняется.
+func NewTripTracker(db *bolt.DB, offDelay time.Duration) *TripTracker {
+	if offDelay <= 0 {
+		offDelay = DefaultOffDelay
+	}
+	t := &TripTracker{db: db, offDelay: offDelay}
+	if db == nil {
+		return t
+	}
+	baseline, err := storage.LoadTripBaseline(db)
+	if err != nil {
+		log.Printf("Ошибка чтения сохраненной поездки: %v", err)
+		return t
+	}
+	if baseline != nil {
+		t.active = true
+		t.start = baseline.Start
+		t.startOdo = baseline.StartOdometer
+		t.idle = time.Duration(baseline.IdleS * float64(time.Second))
+		t.moving = time.Duration(baseline.MovingS * float64(time.Second))
+		t.lastRunning = time.Now()
+		log.Printf("Продолжение поездки, начатой %s", baseline.Start.Format(time.RFC3339))
+	}
+	return t
+}
+
+// Update обрабатывает очередной снимок метрик в момент now.
+// Возвращает итоги поездки и true, если поездка только что завершилась.
+func (t *TripTracker) Update(now time.Time, s Sample) (common.TripSummary, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	busActive := s.Frames != t.lastFrames
+	t.lastFrames = s.Frames
+	running := busActive && s.RPMOK && s.RPM > 0
+
+	if s.OdometerOK {
+		odo := s.Odometer
+		t.lastOdo = &odo
+	}
+
+	// Время с прошлого снимка относится к холостому ходу или движению
+	// по состоянию на текущий снимок; без данных о скорости не учитывается.
+	elapsed := now.Sub(t.lastUpdate)
+	if t.lastUpdate.IsZero() || elapsed < 0 || elapsed > 2*sampleInterval {
+		elapsed = 0
+	}
+	t.lastUpdate = now
+
+	if !t.active {
+		if !running {
+			return common.TripSummary{}, false
+		}
+		t.active = true
+		t.start = now
+		t.startOdo = nil
+		t.idle, t.moving = 0, 0
+		t.lastRunning = now
+		if s.OdometerOK {
+			odo := s.Odometer
+			t.startOdo = &odo
+		}
+		log.Printf("Начало поездки: %s", now.Format(time.RFC3339))
+		t.saveBaseline(now)
+		return common.TripSummary{}, false
+	}
+
+	if running {
+		t.lastRunning = now
+		if s.SpeedOK {
+			if s.Speed >= movingSpeedKmh {
+				t.moving += elapsed
+			} else {
+				t.idle += elapsed
+			}
+		}
+		if t.startOdo == nil && s.OdometerOK {
+			odo := s.Odometer
+			t.startOdo = &odo
+			t.saveBaseline(now)
+		} else if now.Sub(t.lastPersist) >= persistInterval {
+			t.saveBaseline(now)
+		}
+		return common.TripSummary{}, false
+	}
+
+	if now.Sub(t.lastRunning) < t.offDelay {
+		return common.TripSummary{}, false
+	}
+
+	summary := t.summary(t.lastRunning)
+	t.active = false
+	t.startOdo = nil
+	if t.db != nil {
+		if err := storage.ClearTripBaseline(t.db); err != nil {
+			log.Printf("Ошибка удаления сохраненной поездки: %v", err)
+		}
+	}
+	log.Printf("Поездка завершена: %s - %s (%.0f с)", summary.Start.Format(time.RFC3339), summary.End.Format(time.RFC3339), summary.DurationS)
+	return summary, true
+}
+
+// Current возвращает показатели незавершенной поездки на момент последнего снимка
+// и false, если поездки нет.
+func (t *TripTracker) Current() (common.TripSummary, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if !t.active {
+		return common.TripSummary{}, false
+	}
+	return t.summary(t.lastUpdate), true
+}
+
+// summary вычисляет показатели текущей поездки по состоянию на end. Вызывается под мьютексом.
+func (t *TripTracker) summary(end time.Time) common.TripSummary {
+	s := common.TripSummary{
+		Start:     t.start,
+		End:       end,
+		DurationS: end.Sub(t.start).Seconds(),
+		IdleS:     t.idle.Seconds(),
+		MovingS:   t.moving.Seconds(),
+	}
+	if t.startOdo != nil && t.lastOdo != nil && *t.lastOdo >= *t.startOdo {
+		distance := *t.lastOdo - *t.startOdo
+		s.DistanceKm = &distance
+		if hours := s.DurationS / 3600; hours > 0 {
+			speed := distance / hours
+			s.AvgSpeedKmh = &speed
+		}
+	}
+	return s
+}
+
+// saveBaseline сохраняет начало поездки и накопленное время. Вызывается под мьютексом.
+func (t *TripTracker) saveBaseline(now time.Time) {
+	t.lastPersist = now
+	if t.db == nil {
+		return
+	}
+	err := storage.SaveTripBaseline(t.db, storage.TripBaseline{
+		Start:         t.start,
+		StartOdometer: t.startOdo,
+		IdleS:         t.idle.Seconds(),
+		MovingS:       t.moving.Seconds(),
+	})
+	if err != nil {
+		log.Printf("Ошибка сохранения начала поездки: %v", err)
+	}
+}
+
+// Run опрашивает метрики через sample раз в секунду до закрытия stop.
+// onSample получает показатели текущей поездки после каждого снимка (nil - поездки нет),
+// onEnd - итоги каждой завершенной поездки.
+func (t *TripTracker) Run(stop <-chan struct{}, sample func() Sample, onSample func(current *common.TripSummary), onEnd func(common.TripSummary)) {
+	ticker := time.NewTicker(sampleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			if summary, ended := t.Update(now, sample()); ended {
+				onEnd(summary)
+			}
+			if current, ok := t.Current(); ok {
+				onSample(&current)
+			} else {
+				onSample(nil)
+			}
+		}
+	}
+}
@@ -0,0 +1,166 @@
+// Package clock задает источник времени для разбора кадров, данных и публикации.
+// В работе используется Real; Mock позволяет управлять временем вручную
+// и детерминированно проверять поведение, зависящее от времени (TTL, устаревание, поездки).
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock - источник текущего времени и таймеров.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+}
+
+// Timer - однократный таймер, аналог *time.Timer.
+type Timer interface {
+	C() <-chan time.Time
+	Reset(d time.Duration) bool
+	Stop() bool
+}
+
+// Ticker - периодический таймер, аналог *time.Ticker.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Real - системное время (пакет time).
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTimer(d time.Duration) Timer { return realTimer{time.NewTimer(d)} }
+
+func (realClock) NewTicker(d time.Duration) Ticker { return realTicker{time.NewTicker(d)} }
+
+type realTimer struct{ *time.Timer }
+
+func (t realTimer) C() <-chan time.Time { return t.Timer.C }
+
+type realTicker struct{ *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time { return t.Ticker.C }
+
+// Mock - время, которое меняется только вызовами Set и Advance. Таймеры и тикеры
+// срабатывают, когда время доходит до их срока. Безопасен для использования из горутин.
+type Mock struct {
+	mutex  sync.Mutex
+	now    time.Time
+	timers []*mockTimer
+}
+
+// NewMock создает Mock с текущим временем now.
+func NewMock(now time.Time) *Mock {
+	return &Mock{now: now}
+}
+
+// Now возвращает текущее время Mock.
+func (m *Mock) Now() time.Time {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.now
+}
+
+// Set устанавливает время now и запускает наступившие таймеры. Время не уменьшается.
+func (m *Mock) Set(now time.Time) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if now.After(m.now) {
+		m.now = now
+	}
+	m.fire()
+}
+
+// Advance сдвигает время на d и запускает наступившие таймеры.
+func (m *Mock) Advance(d time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if d > 0 {
+		m.now = m.now.Add(d)
+	}
+	m.fire()
+}
+
+// NewTimer создает таймер, срабатывающий через d по времени Mock.
+func (m *Mock) NewTimer(d time.Duration) Timer {
+	return m.add(d, 0)
+}
+
+// NewTicker создает тикер с периодом d по времени Mock.
+func (m *Mock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: неположительный период тикера")
+	}
+	return mockTicker{m.add(d, d)}
+}
+
+func (m *Mock) add(d, period time.Duration) *mockTimer {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	t := &mockTimer{mock: m, c: make(chan time.Time, 1), deadline: m.now.Add(d), period: period, active: true}
+	m.timers = append(m.timers, t)
+	m.fire()
+	return t
+}
+
+// fire отправляет время в каналы наступивших таймеров. Как и у пакета time,
+// срабатывание пропускается, если предыдущее значение еще не прочитано.
+// Вызывается под m.mutex.
+func (m *Mock) fire() {
+	for _, t := range m.timers {
+		if !t.active || t.deadline.After(m.now) {
+			continue
+		}
+		select {
+		case t.c <- m.now:
+		default:
+		}
+		if t.period == 0 {
+			t.active = false
+			continue
+		}
+		for !t.deadline.After(m.now) {
+			t.deadline = t.deadline.Add(t.period)
+		}
+	}
+}
+
+type mockTimer struct {
+	mock     *Mock
+	c        chan time.Time
+	deadline time.Time
+	period   time.Duration
+	active   bool
+}
+
+func (t *mockTimer) C() <-chan time.Time { return t.c }
+
+func (t *mockTimer) Reset(d time.Duration) bool {
+	t.mock.mutex.Lock()
+	defer t.mock.mutex.Unlock()
+	wasActive := t.active
+	t.deadline = t.mock.now.Add(d)
+	t.active = true
+	t.mock.fire()
+	return wasActive
+}
+
+func (t *mockTimer) Stop() bool {
+	t.mock.mutex.Lock()
+	defer t.mock.mutex.Unlock()
+	wasActive := t.active
+	t.active = false
+	return wasActive
+}
+
+type mockTicker struct{ timer *mockTimer }
+
+func (t mockTicker) C() <-chan time.Time { return t.timer.c }
+
+func (t mockTicker) Stop() { t.timer.Stop() }
@@ -0,0 +1,47 @@
+// Package envflag задает значения флагов командной строки из переменных окружения,
+// чтобы агенты можно было настраивать в контейнерах без собственной точки входа.
+package envflag
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Prefix - префикс переменных окружения агентов.
+const Prefix = "J1708_"
+
+// Name возвращает имя переменной окружения флага name: префикс и имя флага
+// в верхнем регистре с заменой '-' на '_' (-can-if -> J1708_CAN_IF).
+func Name(prefix, name string) string {
+	return prefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// Apply задает флагам fs, не указанным в командной строке, значения из переменных
+// окружения (см. Name). Вызывается после fs.Parse: приоритет - флаг, затем переменная
+// окружения, затем значение по умолчанию. Возвращает имена примененных переменных
+// или ошибку, если значение переменной не подходит флагу.
+func Apply(fs *flag.FlagSet, prefix string) ([]string, error) {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	var applied []string
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || set[f.Name] {
+			return
+		}
+		env := Name(prefix, f.Name)
+		value, ok := os.LookupEnv(env)
+		if !ok {
+			return
+		}
+		if setErr := fs.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("переменная окружения %s: %w", env, setErr)
+			return
+		}
+		applied = append(applied, env)
+	})
+	return applied, err
+}
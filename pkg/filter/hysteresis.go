@@ -0,0 +1,100 @@
+package filter
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HysteresisConfig - параметры гистерезиса одной метрики.
+type HysteresisConfig struct {
+	// Threshold - изменение, которое нужно превысить, чтобы значение считалось измененным.
+	Threshold float64
+	// Debounce - сколько измененное значение должно продержаться, прежде чем изменение
+	// будет подтверждено; 0 - подтверждается сразу.
+	Debounce time.Duration
+}
+
+// Hysteresis определяет, изменилось ли значение настолько, чтобы его стоило публиковать.
+// Сами значения не меняются: в отличие от MovingAverage, фильтр решает только, когда
+// считать метрику измененной, и подавляет «дребезг» между соседними сырыми значениями.
+type Hysteresis struct {
+	config HysteresisConfig
+	// reference - последнее подтвержденное значение.
+	reference    float64
+	hasReference bool
+	// pendingSince - момент, с которого значение непрерывно отличается от reference больше порога.
+	pendingSince time.Time
+}
+
+// NewHysteresis создает фильтр с параметрами config.
+func NewHysteresis(config HysteresisConfig) *Hysteresis {
+	return &Hysteresis{config: config}
+}
+
+// Update обрабатывает значение, полученное в момент now, и возвращает true,
+// если изменение подтверждено. Первое значение всегда считается изменением.
+func (h *Hysteresis) Update(value float64, now time.Time) bool {
+	if !h.hasReference {
+		h.reference, h.hasReference = value, true
+		h.pendingSince = time.Time{}
+		return true
+	}
+	if math.Abs(value-h.reference) <= h.config.Threshold {
+		// Значение вернулось в пределы порога - ожидание подтверждения начинается заново
+		h.pendingSince = time.Time{}
+		return false
+	}
+	if h.pendingSince.IsZero() {
+		h.pendingSince = now
+	}
+	if now.Sub(h.pendingSince) < h.config.Debounce {
+		return false
+	}
+	h.reference = value
+	h.pendingSince = time.Time{}
+	return true
+}
+
+// Reset сбрасывает подтвержденное значение, например, когда параметр стал недоступен.
+// Следующее значение будет считаться изменением.
+func (h *Hysteresis) Reset() {
+	h.hasReference = false
+	h.pendingSince = time.Time{}
+}
+
+// ParseHysteresis разбирает строку вида "coolant_temp=1:5s,fuel_level=0.5"
+// в карту имя метрики -> параметры гистерезиса. Время подтверждения необязательно.
+func ParseHysteresis(spec string) (map[string]HysteresisConfig, error) {
+	configs := make(map[string]HysteresisConfig)
+	if strings.TrimSpace(spec) == "" {
+		return configs, nil
+	}
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("некорректный элемент %q, ожидается ключ=порог[:время]", item)
+		}
+		key = strings.TrimSpace(key)
+		thresholdStr, debounceStr, hasDebounce := strings.Cut(strings.TrimSpace(value), ":")
+		threshold, err := strconv.ParseFloat(strings.TrimSpace(thresholdStr), 64)
+		if err != nil || threshold < 0 || math.IsNaN(threshold) || math.IsInf(threshold, 0) {
+			return nil, fmt.Errorf("некорректный порог для %q: %q", key, thresholdStr)
+		}
+		var debounce time.Duration
+		if hasDebounce {
+			debounce, err = time.ParseDuration(strings.TrimSpace(debounceStr))
+			if err != nil || debounce < 0 {
+				return nil, fmt.Errorf("некорректное время подтверждения для %q: %q", key, debounceStr)
+			}
+		}
+		configs[key] = HysteresisConfig{Threshold: threshold, Debounce: debounce}
+	}
+	return configs, nil
+}
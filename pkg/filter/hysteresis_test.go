@@ -0,0 +1,89 @@
+package filter
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// step - значение, переданное фильтру через offset после начала, и ожидаемый результат Update.
+type step struct {
+	offset  time.Duration
+	value   float64
+	changed bool
+}
+
+func runSteps(t *testing.T, h *Hysteresis, steps []step) {
+	t.Helper()
+	start := time.Unix(1700000000, 0)
+	for i, s := range steps {
+		if got := h.Update(s.value, start.Add(s.offset)); got != s.changed {
+			t.Errorf("шаг %d (%v, %v): Update = %v, ожидается %v", i, s.offset, s.value, got, s.changed)
+		}
+	}
+}
+
+func TestHysteresisThreshold(t *testing.T) {
+	h := NewHysteresis(HysteresisConfig{Threshold: 1})
+	runSteps(t, h, []step{
+		{0, 90, true}, // Первое значение всегда изменение
+		// Дребезг между соседними значениями в пределах порога
+		{time.Second, 90.5, false},
+		{2 * time.Second, 89, false},
+		{3 * time.Second, 91, false},
+		// Изменение больше порога подтверждается сразу, новое значение становится опорным
+		{4 * time.Second, 91.5, true},
+		{5 * time.Second, 92.5, false},
+		{6 * time.Second, 90, true},
+	})
+}
+
+func TestHysteresisDebounce(t *testing.T) {
+	h := NewHysteresis(HysteresisConfig{Threshold: 1, Debounce: 5 * time.Second})
+	runSteps(t, h, []step{
+		{0, 90, true},
+		// Выброс, вернувшийся в пределы порога раньше 5 с, не подтверждается
+		{time.Second, 95, false},
+		{3 * time.Second, 95, false},
+		{4 * time.Second, 90.5, false},
+		// Ожидание начинается заново: изменение держится с 5 с и подтверждается на 10 с
+		{5 * time.Second, 95, false},
+		{9 * time.Second, 96, false},
+		{10 * time.Second, 96, true},
+		// Опорное значение - 96
+		{11 * time.Second, 95.5, false},
+	})
+}
+
+func TestHysteresisReset(t *testing.T) {
+	h := NewHysteresis(HysteresisConfig{Threshold: 10, Debounce: time.Minute})
+	runSteps(t, h, []step{
+		{0, 90, true},
+		{time.Second, 150, false}, // ожидание подтверждения
+	})
+	// Параметр стал недоступен: следующее значение - изменение, ожидание сброшено
+	h.Reset()
+	runSteps(t, h, []step{
+		{2 * time.Second, 91, true},
+		{3 * time.Second, 95, false},
+	})
+}
+
+func TestParseHysteresis(t *testing.T) {
+	got, err := ParseHysteresis(" coolant_temp=1:5s, fuel_level=0.5 ,")
+	if err != nil {
+		t.Fatalf("ParseHysteresis: %v", err)
+	}
+	want := map[string]HysteresisConfig{
+		"coolant_temp": {Threshold: 1, Debounce: 5 * time.Second},
+		"fuel_level":   {Threshold: 0.5},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseHysteresis = %v, ожидается %v", got, want)
+	}
+	for _, spec := range []string{"coolant_temp", "coolant_temp=-1", "coolant_temp=NaN", "coolant_temp=1:soon", "coolant_temp=1:-5s"} {
+		if _, err := ParseHysteresis(spec); err == nil {
+			t.Errorf("ParseHysteresis(%q) без ошибки", spec)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package filter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MovingAverage реализует скользящее среднее по последним N значениям.
+type MovingAverage struct {
+	window []float64
+	size   int
+	next   int
+	count  int
+	sum    float64
+}
+
+// NewMovingAverage создает фильтр скользящего среднего с окном size.
+// Окно меньше 1 трактуется как 1 (фильтр пропускает значения без изменений).
+func NewMovingAverage(size int) *MovingAverage {
+	if size < 1 {
+		size = 1
+	}
+	return &MovingAverage{
+		window: make([]float64, size),
+		size:   size,
+	}
+}
+
+// Add добавляет новое значение и возвращает текущее сглаженное значение.
+func (m *MovingAverage) Add(value float64) float64 {
+	if m.count == m.size {
+		m.sum -= m.window[m.next]
+	} else {
+		m.count++
+	}
+	m.window[m.next] = value
+	m.sum += value
+	m.next = (m.next + 1) % m.size
+	return m.sum / float64(m.count)
+}
+
+// Reset сбрасывает накопленные значения, например, когда параметр стал недоступен.
+func (m *MovingAverage) Reset() {
+	m.next = 0
+	m.count = 0
+	m.sum = 0
+}
+
+// ParseWindows разбирает строку вида "fuel_level=5,coolant_temp=10"
+// в карту имя метрики -> размер окна.
+func ParseWindows(spec string) (map[string]int, error) {
+	windows := make(map[string]int)
+	if strings.TrimSpace(spec) == "" {
+		return windows, nil
+	}
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		key, sizeStr, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("некорректный элемент %q, ожидается ключ=окно", item)
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(sizeStr))
+		if err != nil || size < 1 {
+			return nil, fmt.Errorf("некорректный размер окна для %q: %q", key, sizeStr)
+		}
+		windows[strings.TrimSpace(key)] = size
+	}
+	return windows, nil
+}
@@ -0,0 +1,81 @@
+package httpapi
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Auth - параметры проверки доступа к HTTP API. Если заданы и токен, и пароль,
+// принимается любой из способов.
+type Auth struct {
+	// Token - токен для заголовка "Authorization: Bearer <токен>".
+	Token string
+	// User и Password - учетные данные Basic-аутентификации.
+	User     string
+	Password string
+	// ProtectHealth - требовать аутентификацию и для /healthz (по умолчанию он открыт
+	// для проверок работоспособности без учетных данных).
+	ProtectHealth bool
+}
+
+// Enabled сообщает, задан ли хотя бы один способ аутентификации.
+func (a Auth) Enabled() bool {
+	return a.Token != "" || a.User != ""
+}
+
+// check сообщает, предъявлены ли в запросе верные учетные данные.
+// Без настроенной аутентификации пропускает все запросы.
+func (a Auth) check(r *http.Request) bool {
+	if !a.Enabled() {
+		return true
+	}
+	if a.Token != "" {
+		if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && secureEqual(token, a.Token) {
+			return true
+		}
+	}
+	if a.User != "" {
+		if user, password, ok := r.BasicAuth(); ok && secureEqual(user, a.User) && secureEqual(password, a.Password) {
+			return true
+		}
+	}
+	return false
+}
+
+// Middleware пропускает к next только запросы с верными учетными данными,
+// остальным отвечает 401.
+func (a Auth) Middleware(next http.Handler) http.Handler {
+	if !a.Enabled() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.check(r) {
+			if a.User != "" {
+				w.Header().Set("WWW-Authenticate", `Basic realm="j1708-stats"`)
+			}
+			writeError(w, http.StatusUnauthorized, errUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// secureEqual сравнивает строки за время, не зависящее от совпадающего префикса.
+func secureEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// isLoopback сообщает, что адрес addr ("host:port") доступен только с этого устройства.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
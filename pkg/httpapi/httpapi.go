@@ -0,0 +1,153 @@
+// Package httpapi - HTTP API агентов для развертываний без маршрутизации команд через MQTT:
+// те же команды сервера, что и в топике команд, и проверка работоспособности.
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/serebryakov7/j1708-stats/common"
+)
+
+// maxCommandSize - максимальный размер тела запроса с командой.
+const maxCommandSize = 64 << 10
+
+// shutdownTimeout - сколько Close ждет завершения выполняющихся запросов.
+const shutdownTimeout = 5 * time.Second
+
+// errUnauthorized - запрос без верных учетных данных.
+var errUnauthorized = errors.New("требуется аутентификация: Authorization: Bearer <токен> или Basic")
+
+// errCommandsUnprotected - команды отключены: API слушает сетевой адрес без аутентификации.
+var errCommandsUnprotected = errors.New("команды по HTTP без аутентификации принимаются только на localhost, задайте токен или пароль")
+
+// Server - HTTP-сервер API агента.
+//
+//	POST /api/v1/command - команда сервера в формате common.ServerCommand (как в топике команд)
+//	GET  /healthz        - проверка работоспособности, всегда 200; кроме status содержит
+//	                       сведения агента (см. SetHealthInfo)
+//
+// Все обработчики, кроме /healthz (если не задан Auth.ProtectHealth), требуют аутентификации
+// (см. Auth). Обработчики данных регистрируются через Handle и защищаются так же.
+type Server struct {
+	addr string
+	auth Auth
+	// handler выполняет команду; nil - команды недоступны (например, в режиме -stdout).
+	handler func(cmd common.ServerCommand) error
+	// healthInfo возвращает дополнительные поля ответа /healthz; nil - только status.
+	healthInfo func() map[string]any
+	mux        *http.ServeMux
+	server  *http.Server
+}
+
+// New создает сервер на адресе addr. Команды выполняет handler - тот же обработчик,
+// что и для команд из MQTT, поэтому поведение не зависит от способа доставки.
+// Без аутентификации команды принимаются только на loopback-адресе.
+func New(addr string, auth Auth, handler func(cmd common.ServerCommand) error) *Server {
+	s := &Server{addr: addr, auth: auth, handler: handler, mux: http.NewServeMux()}
+	s.Handle("POST /api/v1/command", http.HandlerFunc(s.handleCommand))
+	if auth.ProtectHealth {
+		s.Handle("GET /healthz", http.HandlerFunc(s.handleHealth))
+	} else {
+		s.mux.HandleFunc("GET /healthz", s.handleHealth)
+	}
+	s.server = &http.Server{Addr: addr, Handler: s.mux, ReadHeaderTimeout: 10 * time.Second}
+	return s
+}
+
+// Handle регистрирует обработчик pattern (в формате http.ServeMux), доступный только
+// после аутентификации. Вызывается до Start.
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, s.auth.Middleware(handler))
+}
+
+// SetHealthInfo задает источник дополнительных полей ответа /healthz (например, адрес
+// агента на шине и состояние шины). Вызывается до Start.
+func (s *Server) SetHealthInfo(info func() map[string]any) {
+	s.healthInfo = info
+}
+
+// Start запускает сервер в фоне.
+func (s *Server) Start() {
+	if !s.auth.Enabled() {
+		if isLoopback(s.addr) {
+			log.Printf("HTTP API на %s работает без аутентификации (доступен только с этого устройства)", s.addr)
+		} else {
+			log.Printf("HTTP API на %s работает без аутентификации: команды отключены, задайте токен или пароль", s.addr)
+		}
+	}
+	go func() {
+		log.Printf("HTTP API доступен на http://%s/api/v1/command", s.addr)
+		if err := s.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("Ошибка HTTP API на %s: %v", s.addr, err)
+		}
+	}()
+}
+
+// Close останавливает сервер, дожидаясь выполняющихся запросов не дольше shutdownTimeout.
+func (s *Server) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return s.server.Shutdown(ctx)
+}
+
+// handleCommand выполняет команду из тела запроса.
+func (s *Server) handleCommand(w http.ResponseWriter, r *http.Request) {
+	if !s.auth.Enabled() && !isLoopback(s.addr) {
+		writeError(w, http.StatusForbidden, errCommandsUnprotected)
+		return
+	}
+	if s.handler == nil {
+		writeError(w, http.StatusServiceUnavailable, errors.New("команды недоступны в текущем режиме агента"))
+		return
+	}
+	var cmd common.ServerCommand
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxCommandSize)).Decode(&cmd); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("некорректная команда: %w", err))
+		return
+	}
+	if cmd.Type == "" {
+		writeError(w, http.StatusBadRequest, errors.New("не указан тип команды (type)"))
+		return
+	}
+	log.Printf("Получена команда по HTTP от %s: %s", r.RemoteAddr, cmd.Type)
+	if err := s.handler(cmd); err != nil {
+		log.Printf("Ошибка обработки команды %s: %v", cmd.Type, err)
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// handleHealth отвечает, что агент работает, и добавляет сведения healthInfo.
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	health := map[string]any{}
+	if s.healthInfo != nil {
+		for key, value := range s.healthInfo() {
+			health[key] = value
+		}
+	}
+	health["status"] = "ok"
+	writeJSON(w, http.StatusOK, health)
+}
+
+// writeError отправляет ошибку в формате {"error": "..."}.
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// writeJSON отправляет value в формате JSON.
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(value); err != nil {
+		log.Printf("Ошибка отправки ответа HTTP API: %v", err)
+	}
+}
@@ -0,0 +1,168 @@
+// Package j1939bits извлекает параметры (SPN) из данных кадров J1939.
+//
+// Нумерация битов соответствует J1939-71: бит 0 - младший бит байта 0,
+// многобайтовые параметры передаются младшим байтом вперед (little-endian),
+// поэтому параметр, занимающий несколько байтов, - это непрерывный диапазон битов.
+package j1939bits
+
+// ExtractUnsigned возвращает numBits битов data, начиная с бита startBit.
+// ok = false, если диапазон выходит за пределы data или numBits вне 1..64.
+func ExtractUnsigned(data []byte, startBit, numBits int) (uint64, bool) {
+	if startBit < 0 || numBits < 1 || numBits > 64 || startBit+numBits > len(data)*8 {
+		return 0, false
+	}
+	var v uint64
+	for i := 0; i < numBits; i++ {
+		bit := startBit + i
+		if data[bit/8]&(1<<(bit%8)) != 0 {
+			v |= 1 << i
+		}
+	}
+	return v, true
+}
+
+// State - вид значения параметра по J1939-71.
+type State int
+
+const (
+	// Valid - значение является данными.
+	Valid State = iota
+	// Reserved - значения 0xFB-0xFD старшего байта: зарезервированы или имеют смысл,
+	// определенный для конкретного параметра (например, 0xFB - Park у передачи).
+	Reserved
+	// Error - индикатор ошибки: блок знает параметр, но датчик или расчет неисправен.
+	Error
+	// NotAvailable - параметр не передается блоком или отсутствует в data.
+	NotAvailable
+)
+
+// String возвращает название вида значения для логов.
+func (s State) String() string {
+	switch s {
+	case Valid:
+		return "valid"
+	case Reserved:
+		return "reserved"
+	case Error:
+		return "error"
+	default:
+		return "not available"
+	}
+}
+
+// Classify определяет вид значения raw параметра длиной numBits по J1939-71:
+//   - параметры короче байта (например, двухбитовые состояния): старшее значение -
+//     not available, предшествующее - ошибка (для 2 битов: 10 - ошибка, 11 - not available;
+//     для 4 битов: 1110 и 1111);
+//   - параметры из целых байтов определяются по старшему байту: 0x00-0xFA - данные,
+//     0xFB-0xFD - зарезервированы, 0xFE - ошибка, 0xFF - not available
+//     (для 2 байтов: данные 0-0xFAFF, ошибка 0xFE00-0xFEFF, not available 0xFF00-0xFFFF).
+func Classify(raw uint64, numBits int) State {
+	if numBits < 8 {
+		switch ones := uint64(1)<<numBits - 1; raw {
+		case ones:
+			return NotAvailable
+		case ones - 1:
+			return Error
+		default:
+			return Valid
+		}
+	}
+	switch top := raw >> (numBits - 8) & 0xFF; {
+	case top <= 0xFA:
+		return Valid
+	case top == 0xFE:
+		return Error
+	case top == 0xFF:
+		return NotAvailable
+	default:
+		return Reserved
+	}
+}
+
+// IsValid сообщает, что значение raw параметра длиной numBits является данными,
+// а не индикатором ошибки, not available или зарезервированным значением (см. Classify).
+func IsValid(raw uint64, numBits int) bool {
+	return Classify(raw, numBits) == Valid
+}
+
+// Scaled извлекает параметр и переводит его в физическую величину:
+// raw*resolution + offset. ok = false, если параметра нет в data
+// или его значение - не данные (см. IsValid).
+func Scaled(data []byte, startBit, numBits int, resolution, offset float64) (float64, bool) {
+	value, state := ScaledState(data, startBit, numBits, resolution, offset)
+	return value, state == Valid
+}
+
+// ScaledState извлекает параметр как Scaled и возвращает вид его значения.
+// Физическая величина вычисляется только для Valid; параметр, которого нет в data, - NotAvailable.
+func ScaledState(data []byte, startBit, numBits int, resolution, offset float64) (float64, State) {
+	raw, ok := ExtractUnsigned(data, startBit, numBits)
+	if !ok {
+		return 0, NotAvailable
+	}
+	if state := Classify(raw, numBits); state != Valid {
+		return 0, state
+	}
+	return float64(raw)*resolution + offset, Valid
+}
+
+// DTC - код неисправности в 4-байтовом формате DM1/DM2 (J1939-73, версия 4).
+type DTC struct {
+	SPN uint32
+	FMI uint8
+	OC  uint8
+	// CM - SPN Conversion Method; 0 - формат версии 4, 1 - одна из версий 1-3 (см. DecodeDTCVersion).
+	CM uint8
+}
+
+// Версии расположения битов SPN для кодов с CM = 1 (J1939-73). Бит CM не различает
+// версии 1-3, поэтому версия для таких блоков задается настройкой. FMI и OC во всех
+// версиях расположены так же, как в версии 4.
+const (
+	// SPNVersion1 - SPN старшими битами вперед: байт 0 - биты SPN 18-11,
+	// байт 1 - биты 10-3, биты 21-23 - биты 2-0.
+	SPNVersion1 = 1
+	// SPNVersion2 - байт 0 - биты SPN 10-3, байт 1 - биты 18-11, биты 21-23 - биты 2-0.
+	SPNVersion2 = 2
+	// SPNVersion3 - расположение версии 4 (биты 0-15 и 21-23), но с CM = 1.
+	SPNVersion3 = 3
+)
+
+// DecodeDTC разбирает 4 байта кода неисправности, начиная с data[0]:
+// биты 0-15 и 21-23 - SPN (19 бит), биты 16-20 - FMI, биты 24-30 - OC, бит 31 - CM.
+// ok = false, если data короче 4 байт.
+func DecodeDTC(data []byte) (DTC, bool) {
+	if len(data) < 4 {
+		return DTC{}, false
+	}
+	spnLow, _ := ExtractUnsigned(data, 0, 16)
+	spnHigh, _ := ExtractUnsigned(data, 21, 3)
+	fmi, _ := ExtractUnsigned(data, 16, 5)
+	oc, _ := ExtractUnsigned(data, 24, 7)
+	cm, _ := ExtractUnsigned(data, 31, 1)
+	return DTC{
+		SPN: uint32(spnLow) | uint32(spnHigh)<<16,
+		FMI: uint8(fmi),
+		OC:  uint8(oc),
+		CM:  uint8(cm),
+	}, true
+}
+
+// DecodeDTCVersion разбирает код как DecodeDTC, но SPN кода с CM = 1 извлекается
+// в расположении версии legacyVersion (SPNVersion1-SPNVersion3). Для других значений
+// legacyVersion SPN разбирается как в версии 4.
+func DecodeDTCVersion(data []byte, legacyVersion int) (DTC, bool) {
+	dtc, ok := DecodeDTC(data)
+	if !ok || dtc.CM == 0 {
+		return dtc, ok
+	}
+	low := uint32(data[2] >> 5) // Биты 21-23
+	switch legacyVersion {
+	case SPNVersion1:
+		dtc.SPN = uint32(data[0])<<11 | uint32(data[1])<<3 | low
+	case SPNVersion2:
+		dtc.SPN = uint32(data[1])<<11 | uint32(data[0])<<3 | low
+	}
+	return dtc, true
+}
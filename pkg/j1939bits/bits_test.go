@@ -0,0 +1,172 @@
+package j1939bits
+
+import (
+	"math"
+	"testing"
+)
+
+func TestExtractUnsigned(t *testing.T) {
+	// eec1 - EEC1 (PGN 0xF004): 1500 об/мин в битах 24-39
+	eec1 := []byte{0xF0, 0x7D, 0x7D, 0xE0, 0x2E, 0xFF, 0xFF, 0xFF}
+	tests := []struct {
+		name     string
+		data     []byte
+		startBit int
+		numBits  int
+		want     uint64
+		ok       bool
+	}{
+		{"обороты EEC1", eec1, 24, 16, 0x2EE0, true},
+		{"крутящий момент EEC1", eec1, 16, 8, 0x7D, true},
+		{"младшие 4 бита", eec1, 0, 4, 0x0, true},
+		{"старшие 4 бита", eec1, 4, 4, 0xF, true},
+		{"два бита в середине байта", []byte{0x34}, 2, 2, 0x1, true},
+		{"два бита в старшей части байта", []byte{0x34}, 4, 2, 0x3, true},
+		{"через границу байтов", []byte{0xF0, 0x0F}, 4, 8, 0xFF, true},
+		{"старшие биты SPN кода DTC", []byte{0x6E, 0x00, 0xE3, 0x01}, 21, 3, 0x7, true},
+		{"64 бита", []byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}, 0, 64, math.MaxUint64, true},
+		{"последний бит", []byte{0x00, 0x80}, 15, 1, 1, true},
+		{"за пределами данных", []byte{0x00, 0x00}, 8, 16, 0, false},
+		{"пустые данные", nil, 0, 8, 0, false},
+		{"0 битов", eec1, 0, 0, 0, false},
+		{"больше 64 битов", make([]byte, 9), 0, 65, 0, false},
+		{"отрицательный бит", eec1, -1, 8, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ExtractUnsigned(tt.data, tt.startBit, tt.numBits)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("ExtractUnsigned(% X, %d, %d) = 0x%X, %v; ожидается 0x%X, %v",
+					tt.data, tt.startBit, tt.numBits, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		raw     uint64
+		numBits int
+		want    State
+	}{
+		// Двухбитовые состояния: 00, 01 - данные, 10 - ошибка, 11 - not available
+		{0x0, 2, Valid},
+		{0x1, 2, Valid},
+		{0x2, 2, Error},
+		{0x3, 2, NotAvailable},
+		{0xD, 4, Valid},
+		{0xE, 4, Error},
+		{0xF, 4, NotAvailable},
+		// Параметры из целых байтов определяются по старшему байту
+		{0x00, 8, Valid},
+		{0xFA, 8, Valid},
+		{0xFB, 8, Reserved},
+		{0xFD, 8, Reserved},
+		{0xFE, 8, Error},
+		{0xFF, 8, NotAvailable},
+		{0xFAFF, 16, Valid},
+		{0xFB00, 16, Reserved},
+		{0xFE00, 16, Error},
+		{0xFEFF, 16, Error},
+		{0xFF00, 16, NotAvailable},
+		{0xFFFF, 16, NotAvailable},
+		{0xFAFFFFFF, 32, Valid},
+		{0xFE000000, 32, Error},
+		{0xFFFFFFFF, 32, NotAvailable},
+	}
+	for _, tt := range tests {
+		if got := Classify(tt.raw, tt.numBits); got != tt.want {
+			t.Errorf("Classify(0x%X, %d) = %v, ожидается %v", tt.raw, tt.numBits, got, tt.want)
+		}
+		if got := IsValid(tt.raw, tt.numBits); got != (tt.want == Valid) {
+			t.Errorf("IsValid(0x%X, %d) = %v", tt.raw, tt.numBits, got)
+		}
+	}
+}
+
+func TestScaledState(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       []byte
+		startBit   int
+		numBits    int
+		resolution float64
+		offset     float64
+		want       float64
+		state      State
+	}{
+		{"обороты", []byte{0xE0, 0x2E}, 0, 16, 0.125, 0, 1500, Valid},
+		{"температура со смещением", []byte{0x5A}, 0, 8, 1, -40, 50, Valid},
+		{"крутящий момент -125 %", []byte{0x00}, 0, 8, 1, -125, -125, Valid},
+		{"ошибка датчика", []byte{0xFE}, 0, 8, 1, -40, 0, Error},
+		{"not available", []byte{0xFF, 0xFF}, 0, 16, 0.125, 0, 0, NotAvailable},
+		{"зарезервировано", []byte{0xFB}, 0, 8, 1, 0, 0, Reserved},
+		{"нет в кадре", []byte{0x10}, 8, 8, 1, 0, 0, NotAvailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, state := ScaledState(tt.data, tt.startBit, tt.numBits, tt.resolution, tt.offset)
+			if got != tt.want || state != tt.state {
+				t.Errorf("ScaledState = %v, %v; ожидается %v, %v", got, state, tt.want, tt.state)
+			}
+			if _, ok := Scaled(tt.data, tt.startBit, tt.numBits, tt.resolution, tt.offset); ok != (tt.state == Valid) {
+				t.Errorf("Scaled ok = %v", ok)
+			}
+		})
+	}
+}
+
+func TestDecodeDTC(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want DTC
+		ok   bool
+	}{
+		{"SPN 110, FMI 3, OC 1", []byte{0x6E, 0x00, 0x03, 0x01}, DTC{SPN: 110, FMI: 3, OC: 1}, true},
+		// Старшие 3 бита SPN находятся в битах 21-23 вместе с FMI
+		{"SPN 520200, FMI 31", []byte{0x08, 0xF0, 0xFF, 0x7E}, DTC{SPN: 520200, FMI: 31, OC: 126}, true},
+		{"OC не передается", []byte{0x64, 0x00, 0x01, 0x7F}, DTC{SPN: 100, FMI: 1, OC: 0x7F}, true},
+		{"CM = 1", []byte{0x6E, 0x00, 0x03, 0x81}, DTC{SPN: 110, FMI: 3, OC: 1, CM: 1}, true},
+		{"короче 4 байт", []byte{0x6E, 0x00, 0x03}, DTC{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := DecodeDTC(tt.data)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("DecodeDTC(% X) = %+v, %v; ожидается %+v, %v", tt.data, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestDecodeDTCVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		version int
+		want    DTC
+	}{
+		// SPN 110 = биты 18-11: 0x00, биты 10-3: 0x0D, биты 2-0: 6
+		{"версия 1", []byte{0x00, 0x0D, 0xC3, 0x81}, SPNVersion1, DTC{SPN: 110, FMI: 3, OC: 1, CM: 1}},
+		{"версия 2", []byte{0x0D, 0x00, 0xC3, 0x81}, SPNVersion2, DTC{SPN: 110, FMI: 3, OC: 1, CM: 1}},
+		{"версия 3", []byte{0x6E, 0x00, 0x03, 0x81}, SPNVersion3, DTC{SPN: 110, FMI: 3, OC: 1, CM: 1}},
+		// SPN 520200 = биты 18-11: 0xFE, биты 10-3: 0x01, биты 2-0: 0
+		{"версия 1, большой SPN", []byte{0xFE, 0x01, 0x05, 0x82}, SPNVersion1, DTC{SPN: 520200, FMI: 5, OC: 2, CM: 1}},
+		{"версия 2, большой SPN", []byte{0x01, 0xFE, 0x05, 0x82}, SPNVersion2, DTC{SPN: 520200, FMI: 5, OC: 2, CM: 1}},
+		// Код с CM = 0 всегда разбирается по версии 4
+		{"CM = 0 при версии 1", []byte{0x6E, 0x00, 0x03, 0x01}, SPNVersion1, DTC{SPN: 110, FMI: 3, OC: 1}},
+		{"неизвестная версия", []byte{0x6E, 0x00, 0x03, 0x81}, 7, DTC{SPN: 110, FMI: 3, OC: 1, CM: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := DecodeDTCVersion(tt.data, tt.version)
+			if !ok || got != tt.want {
+				t.Errorf("DecodeDTCVersion(% X, %d) = %+v, %v; ожидается %+v", tt.data, tt.version, got, ok, tt.want)
+			}
+		})
+	}
+	if _, ok := DecodeDTCVersion([]byte{0x00, 0x0D}, SPNVersion1); ok {
+		t.Error("DecodeDTCVersion разобрал код короче 4 байт")
+	}
+}
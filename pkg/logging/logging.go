@@ -0,0 +1,70 @@
+// Package logging управляет уровнем подробности логов агентов во время работы.
+// Сообщения по-прежнему выводятся стандартным пакетом log; уровень задается
+// через slog.Level, чтобы его можно было передать в slog-обработчик.
+package logging
+
+import (
+	"fmt"
+	"log"
+	"log/slog"
+	"strings"
+)
+
+// levelStep - шаг изменения уровня сигналами (расстояние между уровнями slog).
+const levelStep = slog.LevelInfo - slog.LevelDebug
+
+// Допустимые границы уровня. Сообщения уровня Info и выше выводятся всегда,
+// поэтому более тихие уровни не имеют смысла.
+const (
+	minLevel = slog.LevelDebug
+	maxLevel = slog.LevelInfo
+)
+
+var level slog.LevelVar // По умолчанию slog.LevelInfo
+
+// Level возвращает текущий уровень логирования.
+func Level() slog.Level {
+	return level.Level()
+}
+
+// SetLevel устанавливает уровень логирования, ограничивая его допустимым диапазоном.
+func SetLevel(l slog.Level) {
+	level.Set(min(max(l, minLevel), maxLevel))
+}
+
+// ParseLevel разбирает имя уровня ("debug", "info").
+func ParseLevel(name string) (slog.Level, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(strings.TrimSpace(name))); err != nil {
+		return 0, fmt.Errorf("неизвестный уровень логирования %q", name)
+	}
+	if l < minLevel || l > maxLevel {
+		return 0, fmt.Errorf("уровень логирования %q вне диапазона %v..%v", name, minLevel, maxLevel)
+	}
+	return l, nil
+}
+
+// DebugEnabled сообщает, выводятся ли отладочные сообщения.
+func DebugEnabled() bool {
+	return level.Level() <= slog.LevelDebug
+}
+
+// Debugf выводит отладочное сообщение, если включен уровень Debug.
+// Используется для сообщений на каждый кадр или публикацию.
+func Debugf(format string, args ...any) {
+	if DebugEnabled() {
+		log.Printf(format, args...)
+	}
+}
+
+// increase делает логи подробнее на один уровень.
+func increase() {
+	SetLevel(level.Level() - levelStep)
+	log.Printf("Уровень логирования: %v", level.Level())
+}
+
+// decrease делает логи тише на один уровень.
+func decrease() {
+	SetLevel(level.Level() + levelStep)
+	log.Printf("Уровень логирования: %v", level.Level())
+}
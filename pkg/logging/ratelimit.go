@@ -0,0 +1,36 @@
+package logging
+
+import (
+	"sync"
+	"time"
+)
+
+// Limiter ограничивает частоту повторяющегося сообщения: события учитываются все,
+// но сообщение выводится не чаще раза в interval с числом событий с предыдущего вывода.
+// Нужен для сообщений, которые при перегрузке повторяются на каждый кадр.
+type Limiter struct {
+	interval time.Duration
+	mutex    sync.Mutex
+	last     time.Time
+	pending  uint64
+}
+
+// NewLimiter создает ограничитель с интервалом вывода interval.
+func NewLimiter(interval time.Duration) *Limiter {
+	return &Limiter{interval: interval}
+}
+
+// Allow учитывает событие в момент now. ok = true - сообщение пора вывести;
+// count - число событий с предыдущего вывода, включая текущее.
+func (l *Limiter) Allow(now time.Time) (count uint64, ok bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.pending++
+	if !l.last.IsZero() && now.Sub(l.last) < l.interval {
+		return 0, false
+	}
+	count = l.pending
+	l.pending = 0
+	l.last = now
+	return count, true
+}
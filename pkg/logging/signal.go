@@ -0,0 +1,28 @@
+//go:build !windows
+
+package logging
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// WatchSignals запускает обработку сигналов изменения уровня логирования:
+// SIGUSR1 делает логи подробнее, SIGUSR2 - тише.
+func WatchSignals() {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		for sig := range sigChan {
+			switch sig {
+			case syscall.SIGUSR1:
+				increase()
+			case syscall.SIGUSR2:
+				decrease()
+			}
+		}
+	}()
+	log.Println("Уровень логирования меняется сигналами SIGUSR1 (подробнее) и SIGUSR2 (тише)")
+}
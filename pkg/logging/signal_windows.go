@@ -0,0 +1,4 @@
+package logging
+
+// WatchSignals ничего не делает: в Windows нет сигналов SIGUSR1/SIGUSR2.
+func WatchSignals() {}
@@ -0,0 +1,37 @@
+package mqtt
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strings"
+)
+
+// DeriveClientID возвращает постоянный между перезапусками идентификатор клиента MQTT:
+// prefix и непустые parts (например, VIN и имя интерфейса) через "-". Символы, кроме
+// латинских букв, цифр, "-" и "_", заменяются на "_". Постоянный идентификатор нужен,
+// чтобы брокер сохранял сессию (CleanSession = false) и сообщения QoS 1 между запусками.
+// При random добавляется случайный суффикс, и каждая сессия начинается заново.
+func DeriveClientID(prefix string, random bool, parts ...string) string {
+	fields := []string{sanitizeClientID(prefix)}
+	for _, part := range parts {
+		if part = sanitizeClientID(part); part != "" {
+			fields = append(fields, part)
+		}
+	}
+	if random {
+		fields = append(fields, fmt.Sprintf("%08x", rand.Uint32()))
+	}
+	return strings.Join(fields, "-")
+}
+
+// sanitizeClientID заменяет в s символы, недопустимые в идентификаторе клиента.
+func sanitizeClientID(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, strings.Trim(s, "/ "))
+}
@@ -1,13 +1,22 @@
 package mqtt
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"math/rand/v2"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 
 	"github.com/serebryakov7/j1708-stats/common"
+	"github.com/serebryakov7/j1708-stats/pkg/clock"
+	"github.com/serebryakov7/j1708-stats/pkg/logging"
 )
 
 const (
@@ -15,26 +24,144 @@ const (
 	DefaultBroker         = "tcp://localhost:1883"
 	DefaultClientID       = "vehicle-data-collector"
 	DefaultTopic          = "vehicle/data"
+	DefaultKeepAlive      = 30 * time.Second
+	// DefaultWaitTimeout - время ожидания подтверждения операции брокером по умолчанию.
+	DefaultWaitTimeout = 10 * time.Second
+	// MinUpdateInterval - минимальный интервал публикации, допустимый для изменения во время работы.
+	MinUpdateInterval = time.Second
+	// VINPlaceholder в имени топика заменяется на VIN автомобиля, например vehicle/{vin}/data.
+	// Пока VIN неизвестен, подставляется UnknownVIN.
+	VINPlaceholder = "{vin}"
+	UnknownVIN     = "unknown"
+	// VehicleIDPlaceholder заменяется на VIN, а если он не получен - на идентификатор
+	// автомобиля, заданный вручную (-vehicle-id). Если неизвестны оба, подставляется UnknownVIN.
+	VehicleIDPlaceholder = "{vehicle_id}"
 )
 
+// ErrConnectFailed - не удалось подключиться к брокеру (недоступен, отказал в подключении).
+// Возвращаемая ошибка оборачивает и исходную ошибку paho.
+var ErrConnectFailed = errors.New("не удалось подключиться к MQTT брокеру")
+
+// ErrTimeout - брокер не подтвердил операцию за MQTTConfig.WaitTimeout.
+var ErrTimeout = errors.New("истекло время ожидания ответа MQTT брокера")
+
 // MQTTConfig содержит настройки для MQTT клиента
+// Топики могут содержать VINPlaceholder и VehicleIDPlaceholder.
 type MQTTConfig struct {
-	Broker         string
-	ClientID       string
-	Topic          string
-	DTCTopic       string // Топик для отправки DTC
-	CommandTopic   string // Топик для получения команд
+	Broker       string
+	ClientID     string
+	Topic        string
+	DTCTopic     string // Топик для отправки DTC
+	CommandTopic string // Топик для получения команд
+	// AckTopic - топик результатов выполнения команд (common.CommandAck). Пусто - CommandTopic + "/ack".
+	AckTopic string
+	// RawTopic - топик принятых кадров шины в исходном виде (common.RawFrame) для отладки.
+	// Пусто - кадры не публикуются.
+	RawTopic       string
 	UpdateInterval time.Duration
+	// KeepAlive - интервал keepalive MQTT. 0 - значение по умолчанию (DefaultKeepAlive).
+	// На нестабильных сотовых каналах имеет смысл увеличить, чтобы брокер реже рвал соединение.
+	KeepAlive time.Duration
+	// WaitTimeout - сколько ждать завершения подключения и публикации. 0 - DefaultWaitTimeout.
+	// Без ограничения зависшее соединение с брокером остановило бы горутину публикации;
+	// истечение времени считается ошибкой публикации, сообщение не повторяется.
+	WaitTimeout time.Duration
+	// CleanSession - начинать ли каждое подключение с чистой сессии.
+	// При false (и постоянном ClientID) брокер сохраняет подписку на топик команд
+	// и накапливает адресованные агенту сообщения QoS 1, пока агент не в сети.
+	// Сессия не влияет на публикации агента: они не повторяются после переподключения.
+	CleanSession bool
+	// QoS - уровень QoS публикации снимков данных, DTC и heartbeat (0, 1 или 2).
+	// При QoS 0 успешная публикация означает только передачу в сеть; при QoS 1 и 2
+	// публикация считается доставленной после подтверждения брокера (PUBACK или PUBCOMP).
+	QoS byte
+	// Protocol - протокол шины агента ("j1587", "j1939"), указывается в heartbeat.
+	Protocol string
+	// HeartbeatTopic - топик heartbeat. Пусто - Topic + "/heartbeat".
+	HeartbeatTopic string
+	// HeartbeatInterval - интервал публикации heartbeat. 0 - heartbeat отключен.
+	// Heartbeat публикуется независимо от наличия данных шины и позволяет отличить
+	// молчащую шину от неработающего агента.
+	HeartbeatInterval time.Duration
+	// RetainData - публиковать снимок данных с флагом retain, чтобы новый подписчик
+	// сразу получал последнее значение, не дожидаясь интервала публикации.
+	// DTC публикуются без retain, чтобы не показывать устаревшие неисправности.
+	RetainData bool
+	// PublishJitter - доля случайного отклонения интервала публикации данных (0.2 - ±20%).
+	// Разносит публикации агентов парка во времени, чтобы они не приходили на брокер
+	// одновременно. 0 - публикация строго по интервалу; допустимы значения меньше 1.
+	PublishJitter float64
+	// MaxPayloadBytes - максимальный размер сообщения, допустимый брокером. 0 - без ограничения.
+	// Более крупный снимок данных сокращается: удаляются поля из PrunePriority,
+	// затем самые крупные из оставшихся. У слишком крупного DTC отбрасывается стоп-кадр.
+	MaxPayloadBytes int
+	// PrunePriority - имена полей снимка (как в публикуемом JSON), удаляемых первыми,
+	// от наименее важного к более важному.
+	PrunePriority []string
+	// FieldAliases - псевдонимы полей DTC и отчетов DTC. Снимок данных переименовывается
+	// при сериализации (поле Aliases common.J1587Payload и common.J1939Payload), здесь он не меняется.
+	FieldAliases common.FieldAliases
+	// Sequence - добавлять в снимки данных и DTC поле seq: общий для них номер,
+	// возрастающий с каждой публикацией. Снимки и DTC публикуются независимо, и по seq
+	// получатель восстанавливает порядок событий. Нумерация начинается с 1 при запуске агента.
+	Sequence bool
+}
+
+// Heartbeat - сообщение о работоспособности агента.
+type Heartbeat struct {
+	Timestamp      string  `json:"timestamp"`
+	Protocol       string  `json:"protocol,omitempty"`
+	VIN            string  `json:"vin,omitempty"`
+	VehicleID      string  `json:"vehicle_id,omitempty"`
+	UptimeSeconds  float64 `json:"uptime_s"`
+	MQTTConnected  bool    `json:"mqtt_connected"`
+	MQTTReconnects uint64  `json:"mqtt_reconnects"`
+	// PublishAttempts - число публикаций снимков данных, DTC и heartbeat.
+	PublishAttempts uint64 `json:"publish_attempts"`
+	// PublishDelivered - из них подтвержденных брокером (только при QoS 1 и 2).
+	PublishDelivered uint64 `json:"publish_delivered"`
+	// PublishFailures - число публикаций, завершившихся ошибкой или не подтвержденных за WaitTimeout.
+	PublishFailures uint64 `json:"publish_failures"`
+	FramesReceived  uint64 `json:"frames_received"`
+	// Info - сведения, специфичные для агента (например, адрес J1939 на шине).
+	Info map[string]any `json:"info,omitempty"`
 }
 
 // MQTTClient представляет MQTT клиент для отправки данных и получения команд
 type MQTTClient struct {
-	config     MQTTConfig
-	client     mqtt.Client
-	stopChan   chan struct{}
-	dataSource func() json.Marshaler
+	config MQTTConfig
+	// configMutex защищает config (топики и интервал изменяются командами во время работы)
+	// и subscribedCommandTopic. Вне SetTopics и SetInterval config читается через currentConfig.
+	configMutex sync.RWMutex
+	client      mqtt.Client
+	stopChan    chan struct{}
+	// intervalChan передает новый интервал публикации горутине публикации.
+	intervalChan chan time.Duration
+	dataSource   func() json.Marshaler
 	// commandHandler - функция обратного вызова для обработки команд
 	commandHandler func(cmd common.ServerCommand) error
+	// framesCounter возвращает число принятых с шины кадров для heartbeat.
+	framesCounter func() uint64
+	// heartbeatInfo возвращает дополнительные сведения для heartbeat.
+	heartbeatInfo func() map[string]any
+	// vin возвращает VIN автомобиля для топиков и heartbeat; пустая строка - VIN неизвестен.
+	vin func() string
+	// vehicleID возвращает VIN или заданный вручную идентификатор автомобиля.
+	vehicleID func() string
+	// subscribedCommandTopic - топик команд, на который выполнена подписка (после подстановки VIN).
+	subscribedCommandTopic string
+	// clock - источник времени для расписания публикации и heartbeat (см. SetClock).
+	clock     clock.Clock
+	startTime time.Time
+	// connects - число успешных подключений к брокеру.
+	connects atomic.Uint64
+	// seq - номер последнего опубликованного снимка или DTC (см. MQTTConfig.Sequence).
+	seq atomic.Uint64
+	// attempts - число публикаций через publish, delivered - из них подтвержденных брокером,
+	// failures - неудачных, включая истечение WaitTimeout.
+	attempts  atomic.Uint64
+	delivered atomic.Uint64
+	failures  atomic.Uint64
 }
 
 // NewClient создает новый MQTT клиент
@@ -42,18 +169,97 @@ func NewClient(config MQTTConfig, dataSource func() json.Marshaler, cmdHandler f
 	return &MQTTClient{
 		config:         config,
 		stopChan:       make(chan struct{}),
+		intervalChan:   make(chan time.Duration, 1),
 		dataSource:     dataSource,
 		commandHandler: cmdHandler,
+		clock:          clock.Real,
+		startTime:      time.Now(),
+	}
+}
+
+// SetClock задает источник времени вместо системного, например clock.Mock для проверки
+// расписания публикации. Время работы в heartbeat отсчитывается от момента вызова.
+// Вызывается до StartPublishing.
+func (c *MQTTClient) SetClock(clk clock.Clock) {
+	c.clock = clk
+	c.startTime = clk.Now()
+}
+
+// SetFramesCounter задает источник счетчика принятых кадров для heartbeat.
+// Вызывается до StartPublishing.
+func (c *MQTTClient) SetFramesCounter(counter func() uint64) {
+	c.framesCounter = counter
+}
+
+// SetHeartbeatInfo задает источник дополнительных сведений heartbeat (поле info).
+// Вызывается до StartPublishing.
+func (c *MQTTClient) SetHeartbeatInfo(info func() map[string]any) {
+	c.heartbeatInfo = info
+}
+
+// SetVINSource задает источник VIN для подстановки в топики (VINPlaceholder) и heartbeat.
+// Вызывается до Connect.
+func (c *MQTTClient) SetVINSource(vin func() string) {
+	c.vin = vin
+}
+
+// SetVehicleIDSource задает источник идентификатора автомобиля для подстановки
+// в топики (VehicleIDPlaceholder) и heartbeat. Вызывается до Connect.
+func (c *MQTTClient) SetVehicleIDSource(vehicleID func() string) {
+	c.vehicleID = vehicleID
+}
+
+// currentVehicleID возвращает идентификатор автомобиля или пустую строку, если он неизвестен.
+func (c *MQTTClient) currentVehicleID() string {
+	if c.vehicleID == nil {
+		return c.currentVIN()
+	}
+	return c.vehicleID()
+}
+
+// currentVIN возвращает VIN автомобиля или пустую строку, если он неизвестен.
+func (c *MQTTClient) currentVIN() string {
+	if c.vin == nil {
+		return ""
 	}
+	return c.vin()
+}
+
+// expandTopic подставляет VIN и идентификатор автомобиля в шаблон топика.
+func (c *MQTTClient) expandTopic(topic string) string {
+	if !strings.Contains(topic, "{") {
+		return topic
+	}
+	orUnknown := func(s string) string {
+		if s == "" {
+			return UnknownVIN
+		}
+		return s
+	}
+	if strings.Contains(topic, VINPlaceholder) {
+		topic = strings.ReplaceAll(topic, VINPlaceholder, orUnknown(c.currentVIN()))
+	}
+	if strings.Contains(topic, VehicleIDPlaceholder) {
+		topic = strings.ReplaceAll(topic, VehicleIDPlaceholder, orUnknown(c.currentVehicleID()))
+	}
+	return topic
 }
 
 // Connect устанавливает соединение с MQTT брокером
 func (c *MQTTClient) Connect() error {
+	cfg := c.currentConfig()
 	opts := mqtt.NewClientOptions()
-	opts.AddBroker(c.config.Broker)
-	opts.SetClientID(c.config.ClientID)
+	opts.AddBroker(cfg.Broker)
+	opts.SetClientID(cfg.ClientID)
 	opts.SetAutoReconnect(true)
+	keepAlive := cfg.KeepAlive
+	if keepAlive <= 0 {
+		keepAlive = DefaultKeepAlive
+	}
+	opts.SetKeepAlive(keepAlive)
+	opts.SetCleanSession(cfg.CleanSession)
 	opts.SetOnConnectHandler(func(client mqtt.Client) {
+		c.connects.Add(1)
 		log.Println("Подключено к MQTT брокеру")
 		// Подписываемся на топик команд после успешного подключения
 		c.subscribeToCommands()
@@ -63,30 +269,258 @@ func (c *MQTTClient) Connect() error {
 	})
 
 	c.client = mqtt.NewClient(opts)
-	if token := c.client.Connect(); token.Wait() && token.Error() != nil {
-		return token.Error()
+	if err := c.wait(c.client.Connect()); err != nil {
+		return fmt.Errorf("%w %s: %w", ErrConnectFailed, cfg.Broker, err)
 	}
 
 	return nil
 }
 
+// currentConfig возвращает копию текущих настроек клиента.
+func (c *MQTTClient) currentConfig() MQTTConfig {
+	c.configMutex.RLock()
+	defer c.configMutex.RUnlock()
+	return c.config
+}
+
+// wait ожидает завершения операции token не дольше WaitTimeout и возвращает ее ошибку
+// или ErrTimeout.
+func (c *MQTTClient) wait(token mqtt.Token) error {
+	timeout := c.currentConfig().WaitTimeout
+	if timeout <= 0 {
+		timeout = DefaultWaitTimeout
+	}
+	if !token.WaitTimeout(timeout) {
+		return fmt.Errorf("%w (%v)", ErrTimeout, timeout)
+	}
+	return token.Error()
+}
+
+// publish публикует payload в topic с QoS из настроек и дожидается завершения операции.
+// Токен QoS 0 завершается после записи в сеть, QoS 1 и 2 - после подтверждения брокера,
+// поэтому доставленными считаются только публикации с QoS 1 и 2.
+func (c *MQTTClient) publish(topic string, retained bool, payload []byte) error {
+	qos := c.currentConfig().QoS
+	c.attempts.Add(1)
+	err := c.wait(c.client.Publish(topic, qos, retained, payload))
+	switch {
+	case err != nil:
+		c.failures.Add(1)
+	case qos > 0:
+		c.delivered.Add(1)
+	}
+	return err
+}
+
+// deliveryNote описывает для лога, что означает успешная публикация с уровнем qos.
+func deliveryNote(qos byte) string {
+	if qos > 0 {
+		return fmt.Sprintf("QoS %d, доставка подтверждена брокером", qos)
+	}
+	return "QoS 0, доставка не подтверждается"
+}
+
+// PublishStats - счетчики публикаций с момента запуска.
+type PublishStats struct {
+	Attempts  uint64
+	Delivered uint64 // Подтверждены брокером (QoS 1 и 2)
+	Failures  uint64 // Ошибка или нет подтверждения за WaitTimeout
+}
+
+// PublishStats возвращает счетчики публикаций снимков данных, DTC и heartbeat.
+func (c *MQTTClient) PublishStats() PublishStats {
+	return PublishStats{
+		Attempts:  c.attempts.Load(),
+		Delivered: c.delivered.Load(),
+		Failures:  c.failures.Load(),
+	}
+}
+
+// PublishFailures возвращает число неудачных публикаций с момента запуска.
+func (c *MQTTClient) PublishFailures() uint64 {
+	return c.failures.Load()
+}
+
 // StartPublishing начинает периодическую отправку данных
 func (c *MQTTClient) StartPublishing() {
-	ticker := time.NewTicker(c.config.UpdateInterval)
-	defer ticker.Stop()
-
-	log.Printf("Начало публикации данных в MQTT на топик %s с интервалом %v", c.config.Topic, c.config.UpdateInterval)
+	cfg := c.currentConfig()
+	log.Printf("Начало публикации данных в MQTT на топик %s с интервалом %v", c.topics().Topic, cfg.UpdateInterval)
 
 	go func() {
+		interval := cfg.UpdateInterval
+		timer := c.clock.NewTimer(c.jittered(interval))
+		defer timer.Stop()
+
 		for {
 			select {
 			case <-c.stopChan:
 				return
-			case <-ticker.C:
+			case interval = <-c.intervalChan:
+				timer.Reset(c.jittered(interval))
+				log.Printf("Интервал публикации данных изменен на %v", interval)
+			case <-timer.C():
+				c.resubscribeOnVINChange()
 				c.publishData()
+				timer.Reset(c.jittered(interval))
 			}
 		}
 	}()
+
+	if cfg.HeartbeatInterval > 0 {
+		go c.runHeartbeat()
+	}
+}
+
+// jittered возвращает интервал до следующей публикации со случайным отклонением
+// в пределах ±PublishJitter от interval.
+func (c *MQTTClient) jittered(interval time.Duration) time.Duration {
+	jitter := c.currentConfig().PublishJitter
+	if jitter <= 0 || jitter >= 1 {
+		return interval
+	}
+	return time.Duration(float64(interval) * (1 + jitter*(2*rand.Float64()-1)))
+}
+
+// runHeartbeat периодически публикует heartbeat до вызова StopPublishing.
+func (c *MQTTClient) runHeartbeat() {
+	cfg := c.currentConfig()
+	log.Printf("Публикация heartbeat на топик %s с интервалом %v", c.heartbeatTopic(), cfg.HeartbeatInterval)
+	// Первый heartbeat публикуется сразу и служит сообщением о запуске агента
+	c.publishHeartbeat(c.heartbeatTopic())
+
+	ticker := c.clock.NewTicker(cfg.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stopChan:
+			return
+		case <-ticker.C():
+			c.publishHeartbeat(c.heartbeatTopic())
+		}
+	}
+}
+
+// heartbeatTopic возвращает топик heartbeat с подставленным VIN.
+func (c *MQTTClient) heartbeatTopic() string {
+	cfg := c.currentConfig()
+	if cfg.HeartbeatTopic == "" {
+		return c.topics().Topic + "/heartbeat"
+	}
+	return c.expandTopic(cfg.HeartbeatTopic)
+}
+
+// publishHeartbeat публикует одно сообщение heartbeat.
+func (c *MQTTClient) publishHeartbeat(topic string) {
+	stats := c.PublishStats()
+	hb := Heartbeat{
+		Timestamp:        c.clock.Now().UTC().Format(time.RFC3339Nano),
+		Protocol:         c.currentConfig().Protocol,
+		VIN:              c.currentVIN(),
+		VehicleID:        c.currentVehicleID(),
+		UptimeSeconds:    c.clock.Now().Sub(c.startTime).Seconds(),
+		MQTTConnected:    c.client.IsConnected(),
+		PublishAttempts:  stats.Attempts,
+		PublishDelivered: stats.Delivered,
+		PublishFailures:  stats.Failures,
+	}
+	if n := c.connects.Load(); n > 1 {
+		hb.MQTTReconnects = n - 1
+	}
+	if c.framesCounter != nil {
+		hb.FramesReceived = c.framesCounter()
+	}
+	if c.heartbeatInfo != nil {
+		hb.Info = c.heartbeatInfo()
+	}
+
+	data, err := json.Marshal(hb)
+	if err != nil {
+		log.Printf("Ошибка сериализации heartbeat: %v", err)
+		return
+	}
+	if err := c.publish(topic, false, data); err != nil {
+		log.Printf("Ошибка отправки heartbeat в MQTT: %v", err)
+	}
+}
+
+// SetInterval изменяет интервал периодической публикации без переподключения к брокеру.
+// Сам клиент изменение не сохраняет; это делает вызывающий код, если нужно.
+func (c *MQTTClient) SetInterval(interval time.Duration) error {
+	if interval < MinUpdateInterval {
+		return fmt.Errorf("интервал публикации %v меньше минимального %v", interval, MinUpdateInterval)
+	}
+	select {
+	case c.intervalChan <- interval:
+		c.configMutex.Lock()
+		c.config.UpdateInterval = interval
+		c.configMutex.Unlock()
+		return nil
+	default:
+		return fmt.Errorf("предыдущее изменение интервала еще не применено")
+	}
+}
+
+// Topics содержит топики клиента, которые можно изменить во время работы.
+type Topics struct {
+	Topic        string
+	DTCTopic     string
+	CommandTopic string
+}
+
+// topics возвращает текущие топики клиента с подставленным VIN.
+func (c *MQTTClient) topics() Topics {
+	c.configMutex.RLock()
+	defer c.configMutex.RUnlock()
+	return Topics{
+		Topic:        c.expandTopic(c.config.Topic),
+		DTCTopic:     c.expandTopic(c.config.DTCTopic),
+		CommandTopic: c.expandTopic(c.config.CommandTopic),
+	}
+}
+
+// SetTopics заменяет топики клиента без переподключения к брокеру.
+// Пустые поля t оставляют соответствующий топик без изменений.
+// При смене топика данных текущий снимок сразу публикуется в новый топик,
+// при смене топика команд клиент переподписывается.
+// Сам клиент изменения не сохраняет; это делает вызывающий код, если нужно.
+func (c *MQTTClient) SetTopics(t Topics) error {
+	if t.Topic == "" && t.DTCTopic == "" && t.CommandTopic == "" {
+		return fmt.Errorf("не указан ни один топик")
+	}
+
+	c.configMutex.Lock()
+	old := Topics{Topic: c.config.Topic, DTCTopic: c.config.DTCTopic, CommandTopic: c.config.CommandTopic}
+	if t.Topic != "" {
+		c.config.Topic = t.Topic
+	}
+	if t.DTCTopic != "" {
+		c.config.DTCTopic = t.DTCTopic
+	}
+	if t.CommandTopic != "" {
+		c.config.CommandTopic = t.CommandTopic
+	}
+	c.configMutex.Unlock()
+
+	cur := c.topics()
+	log.Printf("Топики MQTT изменены: данные %q -> %q, DTC %q -> %q, команды %q -> %q",
+		old.Topic, cur.Topic, old.DTCTopic, cur.DTCTopic, old.CommandTopic, cur.CommandTopic)
+
+	if c.client == nil || !c.client.IsConnected() {
+		return nil
+	}
+	if t.CommandTopic != "" && t.CommandTopic != old.CommandTopic {
+		c.resubscribe()
+	}
+	if t.Topic != "" && t.Topic != old.Topic {
+		go c.publishData()
+	}
+	return nil
+}
+
+// PublishNow публикует текущий снимок данных немедленно, вне расписания.
+func (c *MQTTClient) PublishNow() {
+	c.publishData()
 }
 
 // StopPublishing останавливает публикацию данных
@@ -103,6 +537,7 @@ func (c *MQTTClient) Disconnect() {
 
 // publishData публикует данные в MQTT
 func (c *MQTTClient) publishData() {
+	cfg := c.currentConfig()
 	vehicleData := c.dataSource()
 	if vehicleData == nil {
 		log.Println("Нет данных для публикации")
@@ -114,18 +549,72 @@ func (c *MQTTClient) publishData() {
 		log.Printf("Ошибка сериализации данных: %v", err)
 		return
 	}
+	if cfg.Sequence {
+		data = withSeq(data, c.seq.Add(1))
+	}
+
+	if limit := cfg.MaxPayloadBytes; limit > 0 && len(data) > limit {
+		size := len(data)
+		pruned, dropped, err := pruneSnapshot(data, limit, cfg.PrunePriority)
+		if err != nil {
+			log.Printf("Снимок данных (%d байт) превышает MaxPayloadBytes=%d и не отправлен: %v", size, limit, err)
+			return
+		}
+		log.Printf("Снимок данных (%d байт) превышает MaxPayloadBytes=%d, удалены поля: %v (осталось %d байт)", size, limit, dropped, len(pruned))
+		data = pruned
+	}
 
-	token := c.client.Publish(c.config.Topic, 0, false, data)
-	if token.Wait() && token.Error() != nil {
-		log.Printf("Ошибка отправки данных в MQTT: %v", token.Error())
+	if err := c.publish(c.topics().Topic, cfg.RetainData, data); err != nil {
+		log.Printf("Ошибка отправки данных в MQTT: %v", err)
 	} else {
-		log.Printf("Данные отправлены в MQTT (%d байт)", len(data))
+		logging.Debugf("Данные отправлены в MQTT (%d байт, %s)", len(data), deliveryNote(cfg.QoS))
 	}
 }
 
+// withSeq добавляет поле seq в начало JSON-объекта data.
+func withSeq(data []byte, seq uint64) []byte {
+	if len(data) < 2 || data[0] != '{' {
+		return data
+	}
+	field := fmt.Sprintf(`{"seq":%d`, seq)
+	rest := data[1:]
+	if len(bytes.TrimSpace(rest)) > 1 { // объект не пустой
+		field += ","
+	}
+	return append([]byte(field), rest...)
+}
+
+// resubscribeOnVINChange переподписывается на топик команд, если он зависит от VIN
+// и VIN изменился с момента подписки.
+func (c *MQTTClient) resubscribeOnVINChange() {
+	c.configMutex.RLock()
+	subscribed := c.subscribedCommandTopic
+	c.configMutex.RUnlock()
+	if subscribed == "" || subscribed == c.topics().CommandTopic || !c.client.IsConnected() {
+		return
+	}
+	log.Printf("Топик команд изменился после получения VIN: %s -> %s", subscribed, c.topics().CommandTopic)
+	c.resubscribe()
+}
+
+// resubscribe отменяет текущую подписку на команды и подписывается на актуальный топик.
+func (c *MQTTClient) resubscribe() {
+	c.configMutex.RLock()
+	subscribed := c.subscribedCommandTopic
+	c.configMutex.RUnlock()
+	if subscribed != "" {
+		// Не ждем токен: метод может вызываться из обработчика команд paho
+		c.client.Unsubscribe(subscribed)
+	}
+	c.subscribeToCommands()
+}
+
 // subscribeToCommands подписывается на топик команд от сервера.
 func (c *MQTTClient) subscribeToCommands() {
-	commandTopic := c.config.CommandTopic
+	commandTopic := c.topics().CommandTopic
+	c.configMutex.Lock()
+	c.subscribedCommandTopic = commandTopic
+	c.configMutex.Unlock()
 	if commandTopic == "" {
 		log.Println("Топик для команд не указан, подписка не будет выполнена.")
 		return
@@ -152,37 +641,123 @@ func (c *MQTTClient) handleIncomingCommand(client mqtt.Client, msg mqtt.Message)
 		return
 	}
 
-	if c.commandHandler != nil {
-		if err := c.commandHandler(cmd); err != nil {
-			log.Printf("Ошибка обработки команды %s: %v", cmd.Type, err)
-		}
-	} else {
+	if c.commandHandler == nil {
 		log.Println("Обработчик команд не настроен.")
+		return
 	}
+	ack := common.CommandAck{CommandID: cmd.ID, Type: cmd.Type, Success: true}
+	if err := c.commandHandler(cmd); err != nil {
+		log.Printf("Ошибка обработки команды %s: %v", cmd.Type, err)
+		ack.Success, ack.Message = false, err.Error()
+	}
+	c.publishAck(ack)
+}
+
+// ackTopic возвращает топик результатов команд; по умолчанию - топик команд + "/ack".
+func (c *MQTTClient) ackTopic() string {
+	if topic := c.currentConfig().AckTopic; topic != "" {
+		return c.expandTopic(topic)
+	}
+	return c.topics().CommandTopic + "/ack"
+}
+
+// publishAck публикует результат выполнения команды. Вызывается из обработчика
+// сообщений paho, поэтому подтверждение брокера не ожидается.
+func (c *MQTTClient) publishAck(ack common.CommandAck) {
+	data, err := json.Marshal(ack)
+	if err != nil {
+		log.Printf("Ошибка сериализации результата команды: %v", err)
+		return
+	}
+	c.client.Publish(c.ackTopic(), 1, false, data)
+}
+
+// PublishRawFrame публикует принятый кадр в RawTopic с QoS 0. Вызывается для каждого
+// кадра шины, поэтому подтверждение брокера не ожидается, а без подключения кадр пропускается.
+func (c *MQTTClient) PublishRawFrame(frame common.RawFrame) {
+	topic := c.currentConfig().RawTopic
+	if topic == "" || !c.client.IsConnected() {
+		return
+	}
+	data, err := json.Marshal(frame)
+	if err != nil {
+		log.Printf("Ошибка сериализации кадра: %v", err)
+		return
+	}
+	c.client.Publish(c.expandTopic(topic), 0, false, data)
+}
+
+// PublishDTCReport публикует отчет DTC одного блока в топик DTC.
+func (c *MQTTClient) PublishDTCReport(report common.DTCReport) {
+	cfg := c.currentConfig()
+	if !c.client.IsConnected() {
+		log.Println("MQTT клиент не подключен, отчет DTC не будет отправлен")
+		return
+	}
+	if cfg.Sequence {
+		report.Seq = c.seq.Add(1)
+	}
+	data, err := json.Marshal(report)
+	if err == nil {
+		data, err = cfg.FieldAliases.Apply(data)
+	}
+	if err != nil {
+		log.Printf("Ошибка сериализации отчета DTC: %v", err)
+		return
+	}
+	if limit := cfg.MaxPayloadBytes; limit > 0 && len(data) > limit {
+		log.Printf("Отчет DTC блока %d (%d байт) превышает MaxPayloadBytes=%d и не отправлен", report.SA, len(data), limit)
+		return
+	}
+
+	dtcTopic := c.dtcTopic()
+	if err := c.publish(dtcTopic, false, data); err != nil {
+		log.Printf("Ошибка отправки отчета DTC в MQTT: %v", err)
+	} else {
+		log.Printf("Отчет DTC блока %d (%d кодов) отправлен в MQTT на топик %s (%d байт, %s)", report.SA, len(report.DTCs), dtcTopic, len(data), deliveryNote(cfg.QoS))
+	}
+}
+
+// dtcTopic возвращает топик DTC; по умолчанию - Topic + "/dtc".
+func (c *MQTTClient) dtcTopic() string {
+	topics := c.topics()
+	if topics.DTCTopic == "" {
+		return topics.Topic + "/dtc"
+	}
+	return topics.DTCTopic
 }
 
 // PublishDTC публикует один DTC в MQTT
 func (c *MQTTClient) PublishDTC(dtc common.DTCCode) {
+	cfg := c.currentConfig()
 	if !c.client.IsConnected() {
 		log.Println("MQTT клиент не подключен, DTC не будет отправлен")
 		return
 	}
 
-	data, err := json.Marshal(dtc)
+	if cfg.Sequence {
+		dtc.Seq = c.seq.Add(1)
+	}
+	data, trimmed, err := fitDTC(dtc, cfg.MaxPayloadBytes)
+	if err == nil {
+		data, err = cfg.FieldAliases.Apply(data)
+	}
 	if err != nil {
 		log.Printf("Ошибка сериализации DTC: %v", err)
 		return
 	}
-
-	dtcTopic := c.config.DTCTopic
-	if dtcTopic == "" {
-		dtcTopic = c.config.Topic + "/dtc" // Топик по умолчанию, если не задан
+	if trimmed {
+		log.Printf("DTC %d превышает MaxPayloadBytes=%d, стоп-кадр не отправлен", dtc.SPN, cfg.MaxPayloadBytes)
+	}
+	if limit := cfg.MaxPayloadBytes; limit > 0 && len(data) > limit {
+		log.Printf("DTC %d (%d байт) превышает MaxPayloadBytes=%d и не отправлен", dtc.SPN, len(data), limit)
+		return
 	}
 
-	token := c.client.Publish(dtcTopic, 0, false, data)
-	if token.Wait() && token.Error() != nil {
-		log.Printf("Ошибка отправки DTC в MQTT: %v", token.Error())
+	dtcTopic := c.dtcTopic()
+	if err := c.publish(dtcTopic, false, data); err != nil {
+		log.Printf("Ошибка отправки DTC в MQTT: %v", err)
 	} else {
-		log.Printf("DTC %d отправлен в MQTT на топик %s (%d байт)", dtc.SPN, dtcTopic, len(data))
+		log.Printf("DTC %d отправлен в MQTT на топик %s (%d байт, %s)", dtc.SPN, dtcTopic, len(data), deliveryNote(cfg.QoS))
 	}
 }
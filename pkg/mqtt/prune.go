@@ -0,0 +1,72 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/serebryakov7/j1708-stats/common"
+)
+
+// protectedField не удаляется при сокращении снимка.
+const protectedField = "timestamp"
+
+// pruneSnapshot сокращает сериализованный снимок data до maxBytes, удаляя поля:
+// сначала в порядке priority (наименее важные первыми), затем самые крупные из оставшихся.
+// Возвращает сокращенный снимок и имена удаленных полей. Если уложиться в maxBytes
+// нельзя даже без всех полей, возвращает ошибку.
+func pruneSnapshot(data []byte, maxBytes int, priority []string) ([]byte, []string, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, nil, err
+	}
+
+	// Оставшиеся поля в порядке удаления: сначала по приоритету, затем по убыванию размера
+	var order []string
+	listed := make(map[string]bool, len(priority))
+	for _, key := range priority {
+		if _, ok := fields[key]; ok && !listed[key] {
+			order = append(order, key)
+			listed[key] = true
+		}
+	}
+	var rest []string
+	for key := range fields {
+		if !listed[key] && key != protectedField {
+			rest = append(rest, key)
+		}
+	}
+	sort.Slice(rest, func(i, j int) bool {
+		if len(fields[rest[i]]) != len(fields[rest[j]]) {
+			return len(fields[rest[i]]) > len(fields[rest[j]])
+		}
+		return rest[i] < rest[j]
+	})
+	order = append(order, rest...)
+
+	var dropped []string
+	for _, key := range order {
+		delete(fields, key)
+		dropped = append(dropped, key)
+		pruned, err := json.Marshal(fields)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(pruned) <= maxBytes {
+			return pruned, dropped, nil
+		}
+	}
+	return nil, dropped, fmt.Errorf("снимок не укладывается в %d байт даже без всех полей", maxBytes)
+}
+
+// fitDTC сериализует DTC; если сообщение превышает maxBytes, стоп-кадр отбрасывается.
+// Возвращает данные и признак того, что стоп-кадр был отброшен.
+func fitDTC(dtc common.DTCCode, maxBytes int) ([]byte, bool, error) {
+	data, err := json.Marshal(dtc)
+	if err != nil || maxBytes <= 0 || len(data) <= maxBytes || dtc.FreezeFrame == nil {
+		return data, false, err
+	}
+	dtc.FreezeFrame = nil
+	data, err = json.Marshal(dtc)
+	return data, true, err
+}
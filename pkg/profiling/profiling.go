@@ -0,0 +1,29 @@
+// Package profiling запускает HTTP-сервер с обработчиками net/http/pprof
+// для профилирования агентов на месте эксплуатации.
+package profiling
+
+import (
+	"log"
+	"net/http"
+	"net/http/pprof"
+)
+
+// Serve запускает в фоне сервер pprof на адресе addr (например, "127.0.0.1:6060").
+// Обработчики регистрируются на отдельном ServeMux, а не на http.DefaultServeMux.
+// Сервер не требует аутентификации, поэтому его следует слушать только на localhost
+// и подключаться через SSH-туннель.
+func Serve(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	go func() {
+		log.Printf("pprof доступен на http://%s/debug/pprof/", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Printf("Ошибка сервера pprof на %s: %v", addr, err)
+		}
+	}()
+}
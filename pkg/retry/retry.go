@@ -0,0 +1,67 @@
+// Package retry повторяет операции, которые могут временно не удаваться при запуске агента:
+// например, открытие порта USB-адаптера или CAN-интерфейса, еще не готовых после загрузки.
+package retry
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	// DefaultInitialDelay - пауза перед второй попыткой, если Policy.InitialDelay не задана.
+	DefaultInitialDelay = 500 * time.Millisecond
+	// DefaultMaxDelay - предел паузы между попытками, если Policy.MaxDelay не задан.
+	DefaultMaxDelay = 10 * time.Second
+)
+
+// Policy - ограничения повторных попыток. Если не заданы ни MaxAttempts, ни Timeout,
+// попытки повторяются до успеха.
+type Policy struct {
+	// MaxAttempts - максимальное число попыток, включая первую; 0 - без ограничения.
+	MaxAttempts int
+	// Timeout - время, после которого новые попытки не начинаются; 0 - без ограничения.
+	Timeout time.Duration
+	// InitialDelay - пауза после первой неудачи; после каждой следующей она удваивается
+	// до MaxDelay.
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+}
+
+// Do вызывает fn, пока она не завершится успешно или не будут исчерпаны ограничения policy.
+// Ошибки, для которых retryable возвращает false, возвращаются сразу; retryable == nil
+// повторяет любые ошибки. Возвращаемая ошибка оборачивает ошибку последней попытки.
+// name используется в сообщениях лога.
+func Do(name string, policy Policy, retryable func(error) bool, fn func() error) error {
+	delay := policy.InitialDelay
+	if delay <= 0 {
+		delay = DefaultInitialDelay
+	}
+	maxDelay := policy.MaxDelay
+	if maxDelay <= 0 {
+		maxDelay = DefaultMaxDelay
+	}
+
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil {
+			if attempt > 1 {
+				log.Printf("%s: успешно с попытки %d", name, attempt)
+			}
+			return nil
+		}
+		if retryable != nil && !retryable(err) {
+			return err
+		}
+		if policy.MaxAttempts > 0 && attempt >= policy.MaxAttempts {
+			return fmt.Errorf("%s: попыток %d: %w", name, attempt, err)
+		}
+		if policy.Timeout > 0 && time.Since(start)+delay > policy.Timeout {
+			return fmt.Errorf("%s: попытки не удались в течение %v: %w", name, policy.Timeout, err)
+		}
+		log.Printf("%s: попытка %d не удалась: %v. Повтор через %v", name, attempt, err, delay)
+		time.Sleep(delay)
+		delay = min(delay*2, maxDelay)
+	}
+}
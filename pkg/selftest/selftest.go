@@ -0,0 +1,154 @@
+// Package selftest собирает и выводит результаты самопроверки агента (-selftest):
+// прием кадров с шины и подключение к MQTT брокеру.
+package selftest
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/serebryakov7/j1708-stats/common"
+	"github.com/serebryakov7/j1708-stats/pkg/mqtt"
+)
+
+// ErrNoValidFrames - кадры с шины принимаются, но ни один не прошел проверку
+// (например, неверна скорость или формат кадра порта).
+var ErrNoValidFrames = errors.New("нет корректных кадров")
+
+// DefaultDuration - время чтения шины при самопроверке.
+const DefaultDuration = 5 * time.Second
+
+// maxListedIDs - сколько наблюдавшихся PID/PGN выводится в отчете, остальные только считаются.
+const maxListedIDs = 40
+
+// Report - результаты самопроверки. Observe безопасен для вызова из горутин чтения шины.
+type Report struct {
+	// Protocol - протокол шины (j1587 или j1939), Source - порт или CAN-интерфейс.
+	Protocol string
+	Source   string
+	// Duration - время чтения шины.
+	Duration time.Duration
+	// Frames - число принятых кадров, Valid - из них с верной контрольной суммой
+	// и разобранных без ошибок.
+	Frames uint64
+	Valid  uint64
+
+	// mqttChecked - подключение к MQTT проверялось (не проверяется в режиме -stdout).
+	mqttChecked bool
+	mqttBroker  string
+	mqttErr     error
+
+	mutex    sync.Mutex
+	observed map[string]uint64
+}
+
+// NewReport создает пустой отчет самопроверки.
+func NewReport(protocol, source string, duration time.Duration) *Report {
+	return &Report{
+		Protocol: protocol,
+		Source:   source,
+		Duration: duration,
+		observed: make(map[string]uint64),
+	}
+}
+
+// Observe учитывает параметр или группу параметров id (например, "PID 190" или "PGN 0xF004"),
+// встретившуюся на шине.
+func (r *Report) Observe(id string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.observed[id]++
+}
+
+// Observed возвращает список встретившихся параметров. Номера одного вида упорядочены
+// по возрастанию: более короткие строки ("PID 84") идут раньше длинных ("PID 190").
+func (r *Report) Observed() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	ids := make([]string, 0, len(r.observed))
+	for id := range r.observed {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if len(ids[i]) != len(ids[j]) {
+			return len(ids[i]) < len(ids[j])
+		}
+		return ids[i] < ids[j]
+	})
+	return ids
+}
+
+// SetMQTTResult записывает результат подключения к брокеру broker (err == nil - успешно).
+func (r *Report) SetMQTTResult(broker string, err error) {
+	r.mqttChecked = true
+	r.mqttBroker = broker
+	r.mqttErr = err
+}
+
+// Problems возвращает причины, по которым самопроверка не пройдена; пусто - пройдена.
+// Ошибки оборачивают common.ErrBusSilent, ErrNoValidFrames или mqtt.ErrConnectFailed.
+func (r *Report) Problems() []error {
+	var problems []error
+	switch {
+	case r.Frames == 0:
+		problems = append(problems, fmt.Errorf("%w: за %v с %s не принято ни одного кадра, проверьте подключение адаптера, скорость и зажигание", common.ErrBusSilent, r.Duration, r.Source))
+	case r.Valid == 0:
+		problems = append(problems, fmt.Errorf("%w: принято %d кадров, проверьте скорость порта и формат кадра", ErrNoValidFrames, r.Frames))
+	}
+	if r.mqttChecked && r.mqttErr != nil {
+		problems = append(problems, r.mqttErr)
+	}
+	return problems
+}
+
+// Passed сообщает, пройдена ли самопроверка.
+func (r *Report) Passed() bool {
+	return len(r.Problems()) == 0
+}
+
+// Log выводит отчет в лог.
+func (r *Report) Log() {
+	log.Printf("Самопроверка %s (%s, %v):", r.Protocol, r.Source, r.Duration)
+	log.Printf("  кадров принято: %d, корректных: %d", r.Frames, r.Valid)
+	ids := r.Observed()
+	listed := ids
+	if len(listed) > maxListedIDs {
+		listed = listed[:maxListedIDs]
+	}
+	line := strings.Join(listed, ", ")
+	if len(ids) > len(listed) {
+		line += fmt.Sprintf(" и еще %d", len(ids)-len(listed))
+	}
+	log.Printf("  параметры на шине (%d): %s", len(ids), line)
+	switch {
+	case !r.mqttChecked:
+		log.Println("  MQTT: не проверялся (-stdout)")
+	case r.mqttErr != nil:
+		log.Printf("  MQTT: ошибка подключения к %s: %v", r.mqttBroker, r.mqttErr)
+	default:
+		log.Printf("  MQTT: подключение к %s успешно", r.mqttBroker)
+	}
+	if problems := r.Problems(); len(problems) > 0 {
+		for _, p := range problems {
+			log.Printf("  ОШИБКА: %v", p)
+		}
+		log.Println("Самопроверка НЕ ПРОЙДЕНА")
+		return
+	}
+	log.Println("Самопроверка пройдена")
+}
+
+// CheckMQTT проверяет подключение к MQTT брокеру broker с идентификатором clientID
+// и сразу отключается. Топик команд не используется.
+func CheckMQTT(broker, clientID string) error {
+	client := mqtt.NewClient(mqtt.MQTTConfig{Broker: broker, ClientID: clientID, CleanSession: true}, nil, nil)
+	if err := client.Connect(); err != nil {
+		return err
+	}
+	client.Disconnect()
+	return nil
+}
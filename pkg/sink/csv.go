@@ -0,0 +1,181 @@
+package sink
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/serebryakov7/j1708-stats/common"
+)
+
+// timestampColumn - первый столбец CSV, заполняется временной меткой снимка.
+const timestampColumn = "timestamp"
+
+// CSV записывает снимок данных в CSV-файл строкой на каждый интервал публикации.
+//
+// Набор столбцов фиксируется при создании: если файл уже содержит заголовок,
+// используется он, иначе заголовок формируется из переданного списка столбцов
+// и записывается первой строкой. Метрики, отсутствующие в заголовке (например,
+// появившиеся в новой версии агента), в файл не попадают. DTC в CSV не пишутся.
+type CSV struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	writer  *csv.Writer
+	columns []string
+	ticker  ticker
+}
+
+// NewCSV создает CSV-получатель для файла path.
+// columns - имена метрик в порядке столбцов (без столбца timestamp).
+func NewCSV(path string, columns []string, interval time.Duration, dataSource func() json.Marshaler) *CSV {
+	return &CSV{
+		path:    path,
+		columns: columns,
+		ticker:  newTicker(interval, dataSource),
+	}
+}
+
+// Connect открывает файл на дозапись и при необходимости записывает заголовок.
+func (c *CSV) Connect() error {
+	header, err := readCSVHeader(c.path)
+	if err != nil {
+		return fmt.Errorf("ошибка чтения заголовка CSV %s: %w", c.path, err)
+	}
+
+	file, err := os.OpenFile(c.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("ошибка открытия CSV %s: %w", c.path, err)
+	}
+	c.file = file
+	c.writer = csv.NewWriter(file)
+
+	if header != nil {
+		c.columns = header[1:]
+		log.Printf("CSV %s: дозапись с существующим заголовком (%d столбцов)", c.path, len(header))
+		return nil
+	}
+
+	if err := c.writer.Write(append([]string{timestampColumn}, c.columns...)); err != nil {
+		return fmt.Errorf("ошибка записи заголовка CSV: %w", err)
+	}
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+// readCSVHeader возвращает заголовок существующего файла или nil, если файла нет или он пуст.
+func readCSVHeader(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	header, err := csv.NewReader(bufio.NewReader(file)).Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if len(header) == 0 || header[0] != timestampColumn {
+		return nil, fmt.Errorf("первый столбец заголовка должен быть %q", timestampColumn)
+	}
+	return header, nil
+}
+
+// StartPublishing начинает периодическую запись строк.
+func (c *CSV) StartPublishing() {
+	log.Printf("Начало записи данных в CSV %s", c.path)
+	c.ticker.start(c.writeRow)
+}
+
+// PublishNow записывает строку с текущим снимком данных.
+func (c *CSV) PublishNow() {
+	c.ticker.publishNow(c.writeRow)
+}
+
+// StopPublishing останавливает периодическую запись.
+func (c *CSV) StopPublishing() {
+	c.ticker.stop()
+}
+
+// Disconnect сбрасывает буфер и закрывает файл.
+func (c *CSV) Disconnect() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.file == nil {
+		return
+	}
+	c.writer.Flush()
+	if err := c.file.Close(); err != nil {
+		log.Printf("Ошибка закрытия CSV %s: %v", c.path, err)
+	}
+	c.file = nil
+}
+
+// PublishDTC ничего не делает: DTC не записываются в CSV.
+func (c *CSV) PublishDTC(dtc common.DTCCode) {}
+
+// PublishDTCReport ничего не делает: DTC не записываются в CSV.
+func (c *CSV) PublishDTCReport(report common.DTCReport) {}
+
+func (c *CSV) writeRow(data []byte) {
+	var snapshot map[string]any
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		log.Printf("CSV: ошибка разбора снимка данных: %v", err)
+		return
+	}
+
+	row := make([]string, 0, len(c.columns)+1)
+	row = append(row, formatCSVValue(snapshot[timestampColumn]))
+	for _, column := range c.columns {
+		row = append(row, formatCSVValue(snapshot[column]))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.file == nil {
+		return
+	}
+	if err := c.writer.Write(row); err != nil {
+		log.Printf("CSV: ошибка записи строки: %v", err)
+		return
+	}
+	c.writer.Flush()
+	if err := c.writer.Error(); err != nil {
+		log.Printf("CSV: ошибка записи в файл %s: %v", c.path, err)
+	}
+}
+
+// formatCSVValue преобразует значение метрики в текст ячейки.
+// Недоступные значения дают пустую ячейку, составные - JSON.
+func formatCSVValue(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return ""
+		}
+		return string(encoded)
+	}
+}
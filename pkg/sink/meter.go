@@ -0,0 +1,180 @@
+package sink
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/serebryakov7/j1708-stats/common"
+)
+
+const (
+	// meterSampleInterval - период пересчета скоростей.
+	meterSampleInterval = 10 * time.Second
+	// meterEMAWindow - постоянная времени экспоненциального сглаживания скоростей.
+	meterEMAWindow = time.Minute
+)
+
+// Throughput - сглаженные (EMA) скорости публикации и общий объем данных.
+type Throughput struct {
+	BytesPerSec    float64 `json:"bytes_per_s"`
+	MessagesPerSec float64 `json:"messages_per_s"`
+	DTCPerSec      float64 `json:"dtc_per_s"`
+	TotalBytes     uint64  `json:"total_bytes"`
+	TotalMessages  uint64  `json:"total_messages"`
+	TotalDTCs      uint64  `json:"total_dtcs"`
+}
+
+// Meter считает объем данных, отправляемых получателями, и сглаживает скорости
+// экспоненциальным скользящим средним. Снимки данных учитываются при сериализации
+// каждым получателем (см. Source), DTC - при передаче в обернутый Publisher (см. Wrap).
+type Meter struct {
+	mutex       sync.Mutex
+	logInterval time.Duration
+	stopChan    chan struct{}
+
+	// Счетчики с момента последнего пересчета скоростей.
+	bytes, messages, dtcs uint64
+	lastSample            time.Time
+	lastLog               time.Time
+	rates                 Throughput
+}
+
+// NewMeter создает счетчик. Раз в logInterval скорости выводятся в лог; 0 - не выводятся.
+func NewMeter(logInterval time.Duration) *Meter {
+	return &Meter{
+		logInterval: logInterval,
+		stopChan:    make(chan struct{}),
+	}
+}
+
+// Source оборачивает источник снимков данных: каждая сериализация снимка
+// получателем учитывается как одно отправленное сообщение.
+func (m *Meter) Source(source func() json.Marshaler) func() json.Marshaler {
+	return func() json.Marshaler {
+		snapshot := source()
+		if snapshot == nil {
+			return nil
+		}
+		return meteredMarshaler{Marshaler: snapshot, meter: m}
+	}
+}
+
+// Wrap оборачивает получателя: DTC учитываются при публикации, а пересчет скоростей
+// выполняется между StartPublishing и StopPublishing.
+func (m *Meter) Wrap(p Publisher) Publisher {
+	return &meteredPublisher{Publisher: p, meter: m}
+}
+
+// Throughput возвращает текущие сглаженные скорости.
+func (m *Meter) Throughput() Throughput {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.rates
+}
+
+func (m *Meter) record(bytes int, dtc bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.bytes += uint64(bytes)
+	m.rates.TotalBytes += uint64(bytes)
+	if dtc {
+		m.dtcs++
+		m.rates.TotalDTCs++
+	} else {
+		m.messages++
+		m.rates.TotalMessages++
+	}
+}
+
+// sample пересчитывает скорости по счетчикам, накопленным с прошлого вызова.
+func (m *Meter) sample(now time.Time) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.lastSample.IsZero() {
+		m.lastSample, m.lastLog = now, now
+		m.bytes, m.messages, m.dtcs = 0, 0, 0
+		return
+	}
+	dt := now.Sub(m.lastSample).Seconds()
+	if dt <= 0 {
+		return
+	}
+	alpha := 1 - math.Exp(-dt/meterEMAWindow.Seconds())
+	ema := func(avg *float64, count uint64) {
+		*avg += alpha * (float64(count)/dt - *avg)
+	}
+	ema(&m.rates.BytesPerSec, m.bytes)
+	ema(&m.rates.MessagesPerSec, m.messages)
+	ema(&m.rates.DTCPerSec, m.dtcs)
+	m.bytes, m.messages, m.dtcs = 0, 0, 0
+	m.lastSample = now
+
+	if m.logInterval > 0 && now.Sub(m.lastLog) >= m.logInterval {
+		m.lastLog = now
+		log.Printf("Отправка данных: %.1f байт/с, %.3f сообщ./с, %.3f DTC/с (всего %d байт, %d сообщ., %d DTC)",
+			m.rates.BytesPerSec, m.rates.MessagesPerSec, m.rates.DTCPerSec,
+			m.rates.TotalBytes, m.rates.TotalMessages, m.rates.TotalDTCs)
+	}
+}
+
+func (m *Meter) run() {
+	tk := time.NewTicker(meterSampleInterval)
+	defer tk.Stop()
+	m.sample(time.Now())
+	for {
+		select {
+		case <-m.stopChan:
+			return
+		case now := <-tk.C:
+			m.sample(now)
+		}
+	}
+}
+
+// meteredMarshaler учитывает размер сериализованного снимка.
+type meteredMarshaler struct {
+	json.Marshaler
+	meter *Meter
+}
+
+func (mm meteredMarshaler) MarshalJSON() ([]byte, error) {
+	data, err := mm.Marshaler.MarshalJSON()
+	if err == nil {
+		mm.meter.record(len(data), false)
+	}
+	return data, err
+}
+
+// meteredPublisher учитывает публикуемые DTC и управляет пересчетом скоростей.
+type meteredPublisher struct {
+	Publisher
+	meter *Meter
+	once  sync.Once
+}
+
+func (p *meteredPublisher) StartPublishing() {
+	go p.meter.run()
+	p.Publisher.StartPublishing()
+}
+
+func (p *meteredPublisher) StopPublishing() {
+	p.Publisher.StopPublishing()
+	p.once.Do(func() { close(p.meter.stopChan) })
+}
+
+func (p *meteredPublisher) PublishDTC(dtc common.DTCCode) {
+	if data, err := json.Marshal(dtc); err == nil {
+		p.meter.record(len(data), true)
+	}
+	p.Publisher.PublishDTC(dtc)
+}
+
+func (p *meteredPublisher) PublishDTCReport(report common.DTCReport) {
+	if data, err := json.Marshal(report); err == nil {
+		p.meter.record(len(data), true)
+	}
+	p.Publisher.PublishDTCReport(report)
+}
@@ -0,0 +1,127 @@
+package sink
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/serebryakov7/j1708-stats/common"
+)
+
+// Publisher описывает получателя данных агента: периодические снимки данных
+// и отдельные DTC. *mqtt.MQTTClient удовлетворяет этому интерфейсу.
+type Publisher interface {
+	Connect() error
+	StartPublishing()
+	StopPublishing()
+	// PublishNow публикует текущий снимок данных немедленно, вне расписания.
+	PublishNow()
+	Disconnect()
+	PublishDTC(dtc common.DTCCode)
+	// PublishDTCReport публикует коды одного блока одним сообщением (см. common.DTCReport).
+	PublishDTCReport(report common.DTCReport)
+}
+
+// Multi рассылает данные нескольким получателям.
+type Multi []Publisher
+
+// Connect подключает всех получателей. Возвращает первую ошибку.
+func (m Multi) Connect() error {
+	for _, p := range m {
+		if err := p.Connect(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StartPublishing запускает периодическую публикацию у всех получателей.
+func (m Multi) StartPublishing() {
+	for _, p := range m {
+		p.StartPublishing()
+	}
+}
+
+// StopPublishing останавливает периодическую публикацию у всех получателей.
+func (m Multi) StopPublishing() {
+	for _, p := range m {
+		p.StopPublishing()
+	}
+}
+
+// PublishNow немедленно публикует снимок данных у всех получателей.
+func (m Multi) PublishNow() {
+	for _, p := range m {
+		p.PublishNow()
+	}
+}
+
+// Disconnect отключает всех получателей.
+func (m Multi) Disconnect() {
+	for _, p := range m {
+		p.Disconnect()
+	}
+}
+
+// PublishDTC отправляет DTC всем получателям.
+func (m Multi) PublishDTC(dtc common.DTCCode) {
+	for _, p := range m {
+		p.PublishDTC(dtc)
+	}
+}
+
+// PublishDTCReport отправляет отчет DTC всем получателям.
+func (m Multi) PublishDTCReport(report common.DTCReport) {
+	for _, p := range m {
+		p.PublishDTCReport(report)
+	}
+}
+
+// ticker вызывает publish с заданным интервалом до закрытия stopChan.
+type ticker struct {
+	interval   time.Duration
+	stopChan   chan struct{}
+	dataSource func() json.Marshaler
+}
+
+func newTicker(interval time.Duration, dataSource func() json.Marshaler) ticker {
+	return ticker{
+		interval:   interval,
+		stopChan:   make(chan struct{}),
+		dataSource: dataSource,
+	}
+}
+
+// start запускает горутину, которая периодически передает снимок данных в publish.
+func (t *ticker) start(publish func(data []byte)) {
+	go func() {
+		tk := time.NewTicker(t.interval)
+		defer tk.Stop()
+		for {
+			select {
+			case <-t.stopChan:
+				return
+			case <-tk.C:
+				t.publishNow(publish)
+			}
+		}
+	}()
+}
+
+// publishNow передает текущий снимок данных в publish.
+func (t *ticker) publishNow(publish func(data []byte)) {
+	snapshot := t.dataSource()
+	if snapshot == nil {
+		return
+	}
+	data, err := snapshot.MarshalJSON()
+	if err != nil {
+		log.Printf("Ошибка сериализации данных: %v", err)
+		return
+	}
+	publish(data)
+}
+
+func (t *ticker) stop() {
+	close(t.stopChan)
+}
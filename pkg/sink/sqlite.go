@@ -0,0 +1,223 @@
+package sink
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/serebryakov7/j1708-stats/common"
+)
+
+const (
+	// sqliteDriver - имя драйвера database/sql. Драйвер (modernc.org/sqlite, без cgo)
+	// подключается при сборке с тегом sqlite, см. sqlite_driver.go.
+	sqliteDriver = "sqlite"
+	// sqlitePruneInterval - как часто удалять устаревшие записи.
+	sqlitePruneInterval = time.Hour
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS samples (
+	ts         INTEGER NOT NULL,
+	key        TEXT    NOT NULL,
+	value      REAL,
+	text_value TEXT
+);
+CREATE INDEX IF NOT EXISTS idx_samples_ts ON samples(ts);
+CREATE INDEX IF NOT EXISTS idx_samples_key_ts ON samples(key, ts);
+CREATE TABLE IF NOT EXISTS dtc_events (
+	ts        INTEGER NOT NULL,
+	mid       INTEGER NOT NULL,
+	pid       INTEGER,
+	spn       INTEGER,
+	fmi       INTEGER NOT NULL,
+	oc        INTEGER,
+	code_type TEXT,
+	payload   TEXT    NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_dtc_events_ts ON dtc_events(ts);
+`
+
+// SQLite сохраняет все значения метрик и события DTC в локальную базу SQLite
+// для последующего анализа на шлюзе. Время хранится в Unix-наносекундах.
+// Записи старше retention периодически удаляются (0 - хранить бессрочно).
+type SQLite struct {
+	mu        sync.Mutex
+	path      string
+	db        *sql.DB
+	retention time.Duration
+	lastPrune time.Time
+	ticker    ticker
+}
+
+// SQLiteAvailable сообщает, подключен ли драйвер SQLite (сборка с тегом sqlite).
+// Без него -sqlite отклоняется при разборе параметров, а не при подключении.
+func SQLiteAvailable() bool {
+	for _, d := range sql.Drivers() {
+		if d == sqliteDriver {
+			return true
+		}
+	}
+	return false
+}
+
+// NewSQLite создает получателя, пишущего в базу SQLite по пути path.
+func NewSQLite(path string, retention time.Duration, interval time.Duration, dataSource func() json.Marshaler) *SQLite {
+	return &SQLite{
+		path:      path,
+		retention: retention,
+		ticker:    newTicker(interval, dataSource),
+	}
+}
+
+// Connect открывает базу и создает таблицы и индексы.
+func (s *SQLite) Connect() error {
+	db, err := sql.Open(sqliteDriver, s.path)
+	if err != nil {
+		return fmt.Errorf("ошибка открытия SQLite %s (агент собран с тегом sqlite?): %w", s.path, err)
+	}
+	// SQLite не поддерживает параллельную запись, одного соединения достаточно
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return fmt.Errorf("ошибка создания схемы SQLite: %w", err)
+	}
+	s.db = db
+	log.Printf("База SQLite %s открыта (хранение: %v)", s.path, s.retention)
+	return nil
+}
+
+// StartPublishing начинает периодическую запись снимков данных.
+func (s *SQLite) StartPublishing() {
+	s.ticker.start(s.insertSamples)
+}
+
+// PublishNow записывает текущий снимок данных.
+func (s *SQLite) PublishNow() {
+	s.ticker.publishNow(s.insertSamples)
+}
+
+// StopPublishing останавливает периодическую запись.
+func (s *SQLite) StopPublishing() {
+	s.ticker.stop()
+}
+
+// Disconnect закрывает базу.
+func (s *SQLite) Disconnect() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.db == nil {
+		return
+	}
+	if err := s.db.Close(); err != nil {
+		log.Printf("Ошибка закрытия SQLite %s: %v", s.path, err)
+	}
+	s.db = nil
+}
+
+// PublishDTC сохраняет событие DTC.
+func (s *SQLite) PublishDTC(dtc common.DTCCode) {
+	payload, err := json.Marshal(dtc)
+	if err != nil {
+		log.Printf("SQLite: ошибка сериализации DTC: %v", err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.db == nil {
+		return
+	}
+	_, err = s.db.Exec(
+		`INSERT INTO dtc_events (ts, mid, pid, spn, fmi, oc, code_type, payload) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		dtc.Timestamp, dtc.MID, dtc.PID, dtc.SPN, dtc.FMI, dtc.OC, dtc.CodeType, string(payload))
+	if err != nil {
+		log.Printf("SQLite: ошибка записи DTC: %v", err)
+	}
+}
+
+// PublishDTCReport сохраняет коды отчета как отдельные события DTC.
+func (s *SQLite) PublishDTCReport(report common.DTCReport) {
+	for _, dtc := range report.DTCs {
+		s.PublishDTC(dtc)
+	}
+}
+
+func (s *SQLite) insertSamples(data []byte) {
+	var snapshot map[string]any
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		log.Printf("SQLite: ошибка разбора снимка данных: %v", err)
+		return
+	}
+
+	ts := time.Now()
+	if raw, ok := snapshot[timestampColumn].(string); ok {
+		if parsed, err := time.Parse(time.RFC3339Nano, raw); err == nil {
+			ts = parsed
+		}
+	}
+	delete(snapshot, timestampColumn)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.db == nil {
+		return
+	}
+
+	if err := s.insertSnapshot(ts.UnixNano(), snapshot); err != nil {
+		log.Printf("SQLite: ошибка записи снимка данных: %v", err)
+	}
+	if s.retention > 0 && time.Since(s.lastPrune) >= sqlitePruneInterval {
+		s.prune(ts.Add(-s.retention).UnixNano())
+		s.lastPrune = time.Now()
+	}
+}
+
+// insertSnapshot записывает все метрики снимка в одной транзакции.
+// Числовые значения пишутся в value, остальные - в text_value.
+func (s *SQLite) insertSnapshot(ts int64, snapshot map[string]any) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(`INSERT INTO samples (ts, key, value, text_value) VALUES (?, ?, ?, ?)`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	for key, value := range snapshot {
+		var number sql.NullFloat64
+		var text sql.NullString
+		switch v := value.(type) {
+		case nil:
+		case float64:
+			number = sql.NullFloat64{Float64: v, Valid: true}
+		default:
+			text = sql.NullString{String: formatCSVValue(v), Valid: true}
+		}
+		if _, err := stmt.Exec(ts, key, number, text); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// prune удаляет записи старше before.
+func (s *SQLite) prune(before int64) {
+	for _, table := range []string{"samples", "dtc_events"} {
+		res, err := s.db.Exec("DELETE FROM "+table+" WHERE ts < ?", before)
+		if err != nil {
+			log.Printf("SQLite: ошибка удаления устаревших записей из %s: %v", table, err)
+			continue
+		}
+		if n, err := res.RowsAffected(); err == nil && n > 0 {
+			log.Printf("SQLite: удалено %d устаревших записей из %s", n, table)
+		}
+	}
+}
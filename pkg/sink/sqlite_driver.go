@@ -0,0 +1,10 @@
+//go:build sqlite
+
+package sink
+
+// Драйвер SQLite на чистом Go подключается только при сборке с тегом sqlite,
+// чтобы агенты без локального хранилища не включали его в исполняемый файл
+// (модуль указан в go.mod):
+//
+//	go build -tags sqlite ./cmd/...
+import _ "modernc.org/sqlite"
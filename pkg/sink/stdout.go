@@ -0,0 +1,105 @@
+package sink
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/serebryakov7/j1708-stats/common"
+)
+
+// stdoutLine - строка вывода: тип записи и ее содержимое.
+type stdoutLine struct {
+	Kind string          `json:"kind"` // "data", "dtc" или "dtc_report"
+	Data json.RawMessage `json:"data"`
+}
+
+// Stdout печатает снимки данных и DTC в виде JSON-строк без подключения к брокеру.
+// Используется для диагностики на стенде.
+type Stdout struct {
+	mu     sync.Mutex
+	out    io.Writer
+	ticker ticker
+	// aliases - псевдонимы полей DTC (снимок данных переименовывается при сериализации).
+	aliases common.FieldAliases
+}
+
+// NewStdout создает получателя, печатающего данные в stdout с интервалом interval.
+func NewStdout(interval time.Duration, dataSource func() json.Marshaler) *Stdout {
+	return &Stdout{
+		out:    os.Stdout,
+		ticker: newTicker(interval, dataSource),
+	}
+}
+
+// SetFieldAliases задает псевдонимы полей DTC и отчетов DTC. Вызывается до начала публикации.
+func (s *Stdout) SetFieldAliases(aliases common.FieldAliases) {
+	s.aliases = aliases
+}
+
+// Connect ничего не делает: подключение не требуется.
+func (s *Stdout) Connect() error { return nil }
+
+// Disconnect ничего не делает: подключение не требуется.
+func (s *Stdout) Disconnect() {}
+
+// StartPublishing начинает периодический вывод снимков данных.
+func (s *Stdout) StartPublishing() {
+	s.ticker.start(func(data []byte) {
+		s.writeLine("data", data)
+	})
+}
+
+// PublishNow печатает текущий снимок данных.
+func (s *Stdout) PublishNow() {
+	s.ticker.publishNow(func(data []byte) {
+		s.writeLine("data", data)
+	})
+}
+
+// StopPublishing останавливает периодический вывод.
+func (s *Stdout) StopPublishing() {
+	s.ticker.stop()
+}
+
+// PublishDTC печатает один DTC.
+func (s *Stdout) PublishDTC(dtc common.DTCCode) {
+	data, err := json.Marshal(dtc)
+	if err == nil {
+		data, err = s.aliases.Apply(data)
+	}
+	if err != nil {
+		log.Printf("Ошибка сериализации DTC: %v", err)
+		return
+	}
+	s.writeLine("dtc", data)
+}
+
+// PublishDTCReport печатает отчет DTC одного блока.
+func (s *Stdout) PublishDTCReport(report common.DTCReport) {
+	data, err := json.Marshal(report)
+	if err == nil {
+		data, err = s.aliases.Apply(data)
+	}
+	if err != nil {
+		log.Printf("Ошибка сериализации отчета DTC: %v", err)
+		return
+	}
+	s.writeLine("dtc_report", data)
+}
+
+func (s *Stdout) writeLine(kind string, data []byte) {
+	line, err := json.Marshal(stdoutLine{Kind: kind, Data: data})
+	if err != nil {
+		log.Printf("Ошибка сериализации строки вывода: %v", err)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.out.Write(append(line, '\n')); err != nil {
+		log.Printf("Ошибка записи в stdout: %v", err)
+	}
+}
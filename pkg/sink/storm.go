@@ -0,0 +1,219 @@
+package sink
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/serebryakov7/j1708-stats/common"
+)
+
+// stormTopSPNs - сколько самых частых кодов включается в сводку шторма.
+const stormTopSPNs = 5
+
+// StormConfig - параметры обнаружения шторма DTC.
+type StormConfig struct {
+	// Limit - число DTC за Window, при превышении которого начинается шторм.
+	Limit int
+	// Window - окно подсчета DTC. Шторм завершается после окна, в котором DTC не больше Limit.
+	Window time.Duration
+	// DetailInterval - во время шторма DTC публикуются по отдельности не чаще раза
+	// в DetailInterval, остальные учитываются только в сводке. 0 - только сводка.
+	DetailInterval time.Duration
+}
+
+// SPNCount - число срабатываний кода за шторм.
+type SPNCount struct {
+	MID   int    `json:"mid"`
+	SPN   int    `json:"spn"`
+	Count uint64 `json:"count"`
+}
+
+// StormSummary - сводка шторма DTC.
+type StormSummary struct {
+	// Active - шторм продолжается; в последней сводке false и задан End.
+	Active bool       `json:"active"`
+	Start  time.Time  `json:"start"`
+	End    *time.Time `json:"end,omitempty"`
+	// Received - DTC, полученные с начала шторма, Suppressed - из них не опубликованные по отдельности.
+	Received   uint64 `json:"received"`
+	Suppressed uint64 `json:"suppressed"`
+	// TopSPNs - самые частые коды шторма по убыванию числа срабатываний.
+	TopSPNs []SPNCount `json:"top_spns"`
+}
+
+// stormKey - код в подсчете шторма.
+type stormKey struct {
+	mid, spn int
+}
+
+// StormGuard защищает брокер от потока DTC неисправного блока: если за окно пришло
+// больше Limit кодов, они публикуются по отдельности не чаще раза в DetailInterval,
+// а вместо остальных onSummary получает сводку (число кодов и самые частые SPN):
+// в начале шторма, после каждого окна шторма и при его завершении.
+// Отчеты DTC (PublishDTCReport) уже объединяют коды блока и не ограничиваются.
+type StormGuard struct {
+	config    StormConfig
+	onSummary func(StormSummary)
+
+	mutex       sync.Mutex
+	windowStart time.Time
+	windowCount int
+	// storm - текущий шторм; nil - шторма нет.
+	storm      *StormSummary
+	counts     map[stormKey]uint64
+	lastDetail time.Time
+	stopChan   chan struct{}
+}
+
+// NewStormGuard создает защиту от шторма DTC. onSummary вызывается из горутины,
+// публикующей DTC, или из горутины проверки окон.
+func NewStormGuard(config StormConfig, onSummary func(StormSummary)) *StormGuard {
+	return &StormGuard{
+		config:    config,
+		onSummary: onSummary,
+		stopChan:  make(chan struct{}),
+	}
+}
+
+// Wrap оборачивает получателя: DTC проходят через защиту от шторма, а окна
+// проверяются по таймеру между StartPublishing и StopPublishing, чтобы шторм
+// завершался и без новых кодов.
+func (g *StormGuard) Wrap(p Publisher) Publisher {
+	return &stormPublisher{Publisher: p, guard: g}
+}
+
+// allow учитывает dtc и сообщает, публиковать ли его по отдельности.
+func (g *StormGuard) allow(dtc common.DTCCode, now time.Time) bool {
+	g.mutex.Lock()
+	summaries := g.roll(now)
+	g.windowCount++
+	started := g.storm == nil && g.windowCount > g.config.Limit
+	if started {
+		g.storm = &StormSummary{Active: true, Start: now}
+		g.counts = make(map[stormKey]uint64)
+		g.lastDetail = time.Time{}
+		log.Printf("Шторм DTC: больше %d кодов за %v, отдельные коды публикуются не чаще раза в %v", g.config.Limit, g.config.Window, g.config.DetailInterval)
+	}
+	allowed := true
+	if g.storm != nil {
+		g.storm.Received++
+		g.counts[stormKey{dtc.MID, dtc.SPN}]++
+		if g.config.DetailInterval > 0 && (g.lastDetail.IsZero() || now.Sub(g.lastDetail) >= g.config.DetailInterval) {
+			g.lastDetail = now
+		} else {
+			g.storm.Suppressed++
+			allowed = false
+		}
+	}
+	if started {
+		summaries = append(summaries, g.snapshot())
+	}
+	g.mutex.Unlock()
+	for _, s := range summaries {
+		g.emit(s)
+	}
+	return allowed
+}
+
+// tick завершает истекшее окно без новых DTC.
+func (g *StormGuard) tick(now time.Time) {
+	g.mutex.Lock()
+	summaries := g.roll(now)
+	g.mutex.Unlock()
+	for _, s := range summaries {
+		g.emit(s)
+	}
+}
+
+// roll начинает новое окно, если текущее истекло, и возвращает сводки шторма
+// за завершенное окно. Вызывается под mutex.
+func (g *StormGuard) roll(now time.Time) []StormSummary {
+	if g.windowStart.IsZero() {
+		g.windowStart = now
+	}
+	if now.Sub(g.windowStart) < g.config.Window {
+		return nil
+	}
+	var summaries []StormSummary
+	if g.storm != nil {
+		if g.windowCount <= g.config.Limit {
+			g.storm.Active = false
+			end := now
+			g.storm.End = &end
+			summaries = append(summaries, g.snapshot())
+			log.Printf("Шторм DTC завершен: получено %d кодов, не опубликовано по отдельности %d", g.storm.Received, g.storm.Suppressed)
+			g.storm, g.counts = nil, nil
+		} else {
+			summaries = append(summaries, g.snapshot())
+		}
+	}
+	g.windowStart, g.windowCount = now, 0
+	return summaries
+}
+
+// snapshot возвращает копию сводки текущего шторма. Вызывается под mutex.
+func (g *StormGuard) snapshot() StormSummary {
+	s := *g.storm
+	s.TopSPNs = make([]SPNCount, 0, len(g.counts))
+	for key, n := range g.counts {
+		s.TopSPNs = append(s.TopSPNs, SPNCount{MID: key.mid, SPN: key.spn, Count: n})
+	}
+	sort.Slice(s.TopSPNs, func(i, j int) bool {
+		a, b := s.TopSPNs[i], s.TopSPNs[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		if a.SPN != b.SPN {
+			return a.SPN < b.SPN
+		}
+		return a.MID < b.MID
+	})
+	if len(s.TopSPNs) > stormTopSPNs {
+		s.TopSPNs = s.TopSPNs[:stormTopSPNs]
+	}
+	return s
+}
+
+func (g *StormGuard) emit(s StormSummary) {
+	if g.onSummary != nil {
+		g.onSummary(s)
+	}
+}
+
+func (g *StormGuard) run() {
+	tk := time.NewTicker(g.config.Window)
+	defer tk.Stop()
+	for {
+		select {
+		case <-g.stopChan:
+			return
+		case now := <-tk.C:
+			g.tick(now)
+		}
+	}
+}
+
+// stormPublisher пропускает DTC через StormGuard и управляет проверкой окон.
+type stormPublisher struct {
+	Publisher
+	guard *StormGuard
+	once  sync.Once
+}
+
+func (p *stormPublisher) StartPublishing() {
+	go p.guard.run()
+	p.Publisher.StartPublishing()
+}
+
+func (p *stormPublisher) StopPublishing() {
+	p.Publisher.StopPublishing()
+	p.once.Do(func() { close(p.guard.stopChan) })
+}
+
+func (p *stormPublisher) PublishDTC(dtc common.DTCCode) {
+	if p.guard.allow(dtc, time.Now()) {
+		p.Publisher.PublishDTC(dtc)
+	}
+}
@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/serebryakov7/j1708-stats/common"
+)
+
+// ActiveDTCs хранит в памяти последний список активных кодов от каждого источника
+// (MID J1587 или адреса J1939), например для повторной публикации по команде resync.
+// В отличие от DTCStore, коды здесь не накапливаются: каждое сообщение источника
+// заменяет его список целиком.
+type ActiveDTCs struct {
+	mutex   sync.Mutex
+	ttl     time.Duration
+	sources map[int]activeList
+}
+
+type activeList struct {
+	codes    []common.DTCCode
+	received time.Time
+}
+
+// NewActiveDTCs создает пустой список. Коды источника, от которого дольше ttl
+// не было сообщений (например, блок выключен), не считаются активными; 0 - без ограничения.
+func NewActiveDTCs(ttl time.Duration) *ActiveDTCs {
+	return &ActiveDTCs{ttl: ttl, sources: make(map[int]activeList)}
+}
+
+// Update заменяет список активных кодов источника source. Пустой список означает,
+// что у источника нет активных неисправностей. Для nil ничего не делает.
+func (a *ActiveDTCs) Update(source int, codes []common.DTCCode, now time.Time) {
+	if a == nil {
+		return
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.sources[source] = activeList{codes: append([]common.DTCCode(nil), codes...), received: now}
+}
+
+// Codes возвращает последний список активных кодов источника source без учета ttl.
+func (a *ActiveDTCs) Codes(source int) []common.DTCCode {
+	if a == nil {
+		return nil
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return append([]common.DTCCode(nil), a.sources[source].codes...)
+}
+
+// List возвращает активные на момент now коды всех источников,
+// упорядоченные по источнику, SPN и FMI.
+func (a *ActiveDTCs) List(now time.Time) []common.DTCCode {
+	if a == nil {
+		return nil
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	var codes []common.DTCCode
+	for _, l := range a.sources {
+		if a.ttl > 0 && now.Sub(l.received) > a.ttl {
+			continue
+		}
+		codes = append(codes, l.codes...)
+	}
+	sort.Slice(codes, func(i, j int) bool {
+		if codes[i].MID != codes[j].MID {
+			return codes[i].MID < codes[j].MID
+		}
+		if codes[i].SPN != codes[j].SPN {
+			return codes[i].SPN < codes[j].SPN
+		}
+		return codes[i].FMI < codes[j].FMI
+	})
+	return codes
+}
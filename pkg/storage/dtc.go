@@ -1,7 +1,11 @@
 package storage
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
 	"time"
 
 	bolt "go.etcd.io/bbolt"
@@ -12,11 +16,45 @@ const (
 	bucketKey = "active_dtcs"
 )
 
+// Режимы хранилища DTC (параметр -dtc-store).
+const (
+	// DTCStoreBolt - дедупликация DTC и прочее состояние агента хранятся в bbolt.
+	DTCStoreBolt = "bolt"
+	// DTCStoreMemory - коды хранятся в памяти и публикуются повторно после перезапуска;
+	// база не открывается, как и в режиме DTCStoreNone.
+	DTCStoreMemory = "memory"
+	// DTCStoreNone - база не открывается: DTC проверяются на повтор только окном -dtc-window,
+	// VIN, поездки и настройки, измененные командами, не сохраняются между запусками.
+	DTCStoreNone = "none"
+)
+
+// ParseDTCStore разбирает значение параметра -dtc-store.
+func ParseDTCStore(s string) (string, error) {
+	switch mode := strings.ToLower(strings.TrimSpace(s)); mode {
+	case DTCStoreBolt, DTCStoreMemory, DTCStoreNone:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("неизвестное хранилище DTC %q (допустимо: %s, %s, %s)", s, DTCStoreBolt, DTCStoreMemory, DTCStoreNone)
+	}
+}
+
+// ErrDBLocked - файл базы заблокирован другим процессом (например, второй агент
+// запущен с тем же путем к базе).
+var ErrDBLocked = errors.New("база данных заблокирована другим процессом")
+
+// openTimeout - время ожидания блокировки файла базы.
+const openTimeout = time.Second
+
 // OpenDB открывает (или создаёт) bbolt-базу и гарантирует наличие bucket’а.
+// Если файл заблокирован другим процессом дольше openTimeout, возвращает ошибку,
+// удовлетворяющую errors.Is(err, ErrDBLocked).
 func OpenDB(path string) (*bolt.DB, error) {
-	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 1 * time.Second})
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: openTimeout})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, fmt.Errorf("%w: %s (блокировка не снята за %v)", ErrDBLocked, path, openTimeout)
+	}
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("открытие %s: %w", path, err)
 	}
 	// Создаём bucket, если его нет
 	err = db.Update(func(tx *bolt.Tx) error {
@@ -30,38 +68,211 @@ func OpenDB(path string) (*bolt.DB, error) {
 	return db, nil
 }
 
+// dtcKey возвращает ключ кода spn/fmi в хранилище.
+func dtcKey(spn uint32, fmi uint8) []byte {
+	return []byte(fmt.Sprintf("%d:%d", spn, fmi))
+}
+
 // IsNew проверяет, встречался ли ранее код spn/fmi.
 // Возвращает true и добавляет код, если он новый.
 func IsNew(db *bolt.DB, spn uint32, fmi uint8) (bool, error) {
-	key := []byte(fmt.Sprintf("%d:%d", spn, fmi))
+	return isNew(db, spn, fmi, 0)
+}
+
+// isNew - реализация IsNew. Если после добавления кодов больше maxKeys (> 0),
+// удаляются самые давно зарегистрированные.
+func isNew(db *bolt.DB, spn uint32, fmi uint8, maxKeys int) (bool, error) {
+	return observe(db, spn, fmi, 0, false, maxKeys)
+}
+
+// isNewOccurrence - реализация IsNewOccurrence, см. также isNew.
+func isNewOccurrence(db *bolt.DB, spn uint32, fmi uint8, oc uint8, maxKeys int) (bool, error) {
+	return observe(db, spn, fmi, oc, true, maxKeys)
+}
+
+// observe запоминает код spn/fmi со счетчиком срабатываний oc и сообщает, публиковать ли его:
+// код новый или, при trackOC, oc больше сохраненного.
+func observe(db *bolt.DB, spn uint32, fmi uint8, oc uint8, trackOC bool, maxKeys int) (bool, error) {
+	key := dtcKey(spn, fmi)
 	var isNew bool
 
 	err := db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(bucketKey))
-		if b.Get(key) == nil {
+		b, err := tx.CreateBucketIfNotExists([]byte(bucketKey))
+		if err != nil {
+			return err
+		}
+		existing := b.Get(key)
+		if existing == nil {
 			// Ключа нет — это новый код
 			isNew = true
-			return b.Put(key, []byte{1})
+			raw, err := json.Marshal(DTCRecord{SPN: spn, FMI: fmi, FirstSeen: time.Now().UTC(), OC: oc})
+			if err != nil {
+				return err
+			}
+			if err := b.Put(key, raw); err != nil {
+				return err
+			}
+			return evictOldest(b, maxKeys)
 		}
-		// Уже был — игнорируем
-		isNew = false
-		return nil
+		if !trackOC {
+			// Уже был — игнорируем
+			return nil
+		}
+		r, err := decodeRecord(key, existing)
+		if err != nil {
+			return err
+		}
+		if oc <= r.OC {
+			return nil
+		}
+		// Счетчик срабатываний вырос — неисправность повторилась
+		isNew = true
+		r.OC = oc
+		raw, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		return b.Put(key, raw)
 	})
 	return isNew, err
 }
 
 // Remove удаляет код spn/fmi (например, при получении PID 194I).
 func Remove(db *bolt.DB, spn uint32, fmi uint8) error {
-	key := []byte(fmt.Sprintf("%d:%d", spn, fmi))
 	return db.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(bucketKey))
-		return b.Delete(key)
+		if b == nil {
+			return nil
+		}
+		return b.Delete(dtcKey(spn, fmi))
 	})
 }
 
 // ClearAll сбрасывает все записи (например, после успешного PID 195→196).
+// Bucket создается заново, чтобы последующие IsNew работали без повторного открытия базы.
 func ClearAll(db *bolt.DB) error {
 	return db.Update(func(tx *bolt.Tx) error {
-		return tx.DeleteBucket([]byte(bucketKey))
+		if err := tx.DeleteBucket([]byte(bucketKey)); err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+			return err
+		}
+		_, err := tx.CreateBucket([]byte(bucketKey))
+		return err
 	})
 }
+
+// evictOldest удаляет из bucket самые давно зарегистрированные коды, пока их не станет
+// не больше maxKeys (0 - без ограничения). Записи ранних версий без времени регистрации
+// считаются самыми старыми.
+func evictOldest(b *bolt.Bucket, maxKeys int) error {
+	if maxKeys <= 0 {
+		return nil
+	}
+	// Stats не учитывает изменения незавершенной транзакции, поэтому записи перебираются
+	var records []DTCRecord
+	err := b.ForEach(func(k, v []byte) error {
+		r, err := decodeRecord(k, v)
+		if err != nil {
+			return err
+		}
+		records = append(records, r)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	excess := len(records) - maxKeys
+	if excess <= 0 {
+		return nil
+	}
+	for _, r := range oldestRecords(records, excess) {
+		if err := b.Delete(dtcKey(r.SPN, r.FMI)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// oldestRecords возвращает n записей с самым ранним временем регистрации.
+func oldestRecords(records []DTCRecord, n int) []DTCRecord {
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].FirstSeen.Before(records[j].FirstSeen)
+	})
+	if n > len(records) {
+		n = len(records)
+	}
+	return records[:n]
+}
+
+// DBSize возвращает размер файла базы в байтах.
+// bbolt не уменьшает файл при удалении записей, но повторно использует освободившиеся страницы.
+func DBSize(db *bolt.DB) (int64, error) {
+	var size int64
+	err := db.View(func(tx *bolt.Tx) error {
+		size = tx.Size()
+		return nil
+	})
+	return size, err
+}
+
+// List возвращает все сохраненные коды, упорядоченные по SPN и FMI.
+func List(db *bolt.DB) ([]DTCRecord, error) {
+	var records []DTCRecord
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketKey))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			r, err := decodeRecord(k, v)
+			if err != nil {
+				return err
+			}
+			records = append(records, r)
+			return nil
+		})
+	})
+	sortRecords(records)
+	return records, err
+}
+
+// Get возвращает запись о коде spn/fmi и false, если код не сохранен.
+func Get(db *bolt.DB, spn uint32, fmi uint8) (DTCRecord, bool, error) {
+	var (
+		r     DTCRecord
+		found bool
+	)
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketKey))
+		if b == nil {
+			return nil
+		}
+		key := dtcKey(spn, fmi)
+		v := b.Get(key)
+		if v == nil {
+			return nil
+		}
+		found = true
+		var err error
+		r, err = decodeRecord(key, v)
+		return err
+	})
+	return r, found, err
+}
+
+// decodeRecord разбирает запись хранилища. Ранние версии хранили вместо записи
+// один байт-признак, для них SPN и FMI восстанавливаются из ключа.
+func decodeRecord(key, value []byte) (DTCRecord, error) {
+	var r DTCRecord
+	if len(value) > 1 {
+		if err := json.Unmarshal(value, &r); err != nil {
+			return r, fmt.Errorf("запись %s: %w", key, err)
+		}
+		return r, nil
+	}
+	var spn uint32
+	var fmi uint8
+	if _, err := fmt.Sscanf(string(key), "%d:%d", &spn, &fmi); err != nil {
+		return r, fmt.Errorf("некорректный ключ %q: %w", key, err)
+	}
+	return DTCRecord{SPN: spn, FMI: fmi}, nil
+}
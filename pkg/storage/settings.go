@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	settingsBucketKey = "runtime_overrides"
+	overridesKey      = "overrides"
+)
+
+// Overrides - настройки, измененные командами сервера во время работы.
+// Нулевые значения означают, что используется значение из флагов.
+type Overrides struct {
+	Interval     time.Duration `json:"interval,omitempty"`
+	Topic        string        `json:"topic,omitempty"`
+	DTCTopic     string        `json:"dtc_topic,omitempty"`
+	CommandTopic string        `json:"command_topic,omitempty"`
+	// Metrics - метрики, включаемые в снимок данных (команда set_metrics); пусто - все.
+	Metrics []string `json:"metrics,omitempty"`
+}
+
+// IsZero сообщает, что переопределений нет.
+func (o Overrides) IsZero() bool {
+	return o.Interval == 0 && o.Topic == "" && o.DTCTopic == "" && o.CommandTopic == "" && len(o.Metrics) == 0
+}
+
+// LoadOverrides читает сохраненные переопределения настроек.
+// Если их нет, возвращает нулевое значение.
+func LoadOverrides(db *bolt.DB) (Overrides, error) {
+	var o Overrides
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(settingsBucketKey))
+		if b == nil {
+			return nil
+		}
+		raw := b.Get([]byte(overridesKey))
+		if raw == nil {
+			return nil
+		}
+		return json.Unmarshal(raw, &o)
+	})
+	return o, err
+}
+
+// UpdateOverrides изменяет сохраненные переопределения в одной транзакции.
+func UpdateOverrides(db *bolt.DB, update func(o *Overrides)) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(settingsBucketKey))
+		if err != nil {
+			return err
+		}
+		var o Overrides
+		if raw := b.Get([]byte(overridesKey)); raw != nil {
+			if err := json.Unmarshal(raw, &o); err != nil {
+				return err
+			}
+		}
+		update(&o)
+		raw, err := json.Marshal(o)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(overridesKey), raw)
+	})
+}
+
+// ClearOverrides удаляет все сохраненные переопределения.
+func ClearOverrides(db *bolt.DB) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		err := tx.DeleteBucket([]byte(settingsBucketKey))
+		if errors.Is(err, bolt.ErrBucketNotFound) {
+			return nil
+		}
+		return err
+	})
+}
@@ -0,0 +1,198 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// DTCRecord - запись хранилища об опубликованном коде неисправности.
+type DTCRecord struct {
+	SPN uint32 `json:"spn"`
+	FMI uint8  `json:"fmi"`
+	// FirstSeen - время первой регистрации кода; нулевое для записей,
+	// сохраненных до появления этого поля.
+	FirstSeen time.Time `json:"first_seen"`
+	// OC - наибольший учтенный счетчик срабатываний (см. IsNewOccurrence).
+	OC uint8 `json:"oc,omitempty"`
+}
+
+// DTCStore хранит опубликованные коды неисправностей для дедупликации.
+// Реализации безопасны для одновременного использования из нескольких горутин.
+type DTCStore interface {
+	// IsNew возвращает true и запоминает код, если он встречается впервые.
+	IsNew(spn uint32, fmi uint8) (bool, error)
+	// IsNewOccurrence возвращает true, если код встречается впервые или его счетчик
+	// срабатываний oc больше сохраненного (неисправность повторилась), и запоминает oc.
+	IsNewOccurrence(spn uint32, fmi uint8, oc uint8) (bool, error)
+	// Remove удаляет код, чтобы при следующем появлении он был опубликован снова.
+	Remove(spn uint32, fmi uint8) error
+	// ClearAll удаляет все коды.
+	ClearAll() error
+	// List возвращает все коды, упорядоченные по SPN и FMI.
+	List() ([]DTCRecord, error)
+	// Get возвращает запись о коде и false, если код не встречался.
+	Get(spn uint32, fmi uint8) (DTCRecord, bool, error)
+	// Len возвращает число сохраненных кодов.
+	Len() (int, error)
+}
+
+// OpenDTCStore открывает хранилище DTC в режиме mode (см. ParseDTCStore).
+// Для DTCStoreBolt возвращает и открытую базу path, в которой хранится остальное состояние агента;
+// для DTCStoreMemory база не открывается, для DTCStoreNone не создается и хранилище (nil).
+// maxKeys ограничивает число хранимых кодов (0 - без ограничения), см. NewBoltDTCStore.
+func OpenDTCStore(mode, path string, maxKeys int) (*bolt.DB, DTCStore, error) {
+	switch mode {
+	case DTCStoreBolt:
+		db, err := OpenDB(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		return db, NewBoltDTCStore(db, maxKeys), nil
+	case DTCStoreMemory:
+		return nil, NewMemoryDTCStore(maxKeys), nil
+	case DTCStoreNone:
+		return nil, nil, nil
+	default:
+		return nil, nil, fmt.Errorf("неизвестное хранилище DTC %q", mode)
+	}
+}
+
+// BoltDTCStore хранит коды в базе bbolt; записи переживают перезапуск агента.
+type BoltDTCStore struct {
+	db      *bolt.DB
+	maxKeys int
+}
+
+// NewBoltDTCStore создает хранилище поверх базы, открытой OpenDB.
+// Если кодов становится больше maxKeys (> 0), самые давно зарегистрированные удаляются:
+// на блоках с множеством ложных кодов это ограничивает рост файла базы.
+// Удаленный код, если он все еще активен, будет опубликован повторно.
+func NewBoltDTCStore(db *bolt.DB, maxKeys int) *BoltDTCStore {
+	return &BoltDTCStore{db: db, maxKeys: maxKeys}
+}
+
+func (s *BoltDTCStore) IsNew(spn uint32, fmi uint8) (bool, error) {
+	return isNew(s.db, spn, fmi, s.maxKeys)
+}
+
+func (s *BoltDTCStore) IsNewOccurrence(spn uint32, fmi uint8, oc uint8) (bool, error) {
+	return isNewOccurrence(s.db, spn, fmi, oc, s.maxKeys)
+}
+func (s *BoltDTCStore) Remove(spn uint32, fmi uint8) error { return Remove(s.db, spn, fmi) }
+func (s *BoltDTCStore) ClearAll() error                    { return ClearAll(s.db) }
+func (s *BoltDTCStore) List() ([]DTCRecord, error)         { return List(s.db) }
+
+func (s *BoltDTCStore) Get(spn uint32, fmi uint8) (DTCRecord, bool, error) {
+	return Get(s.db, spn, fmi)
+}
+
+func (s *BoltDTCStore) Len() (int, error) {
+	var n int
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(bucketKey)); b != nil {
+			n = b.Stats().KeyN
+		}
+		return nil
+	})
+	return n, err
+}
+
+// MemoryDTCStore хранит коды в памяти: для тестов и устройств без записываемого диска.
+// Записи теряются при перезапуске, после чего активные коды публикуются повторно.
+type MemoryDTCStore struct {
+	mutex   sync.Mutex
+	records map[string]DTCRecord
+	maxKeys int
+}
+
+// NewMemoryDTCStore создает пустое хранилище в памяти.
+// maxKeys ограничивает число кодов так же, как в NewBoltDTCStore.
+func NewMemoryDTCStore(maxKeys int) *MemoryDTCStore {
+	return &MemoryDTCStore{records: make(map[string]DTCRecord), maxKeys: maxKeys}
+}
+
+func (s *MemoryDTCStore) IsNew(spn uint32, fmi uint8) (bool, error) {
+	return s.observe(spn, fmi, 0, false)
+}
+
+func (s *MemoryDTCStore) IsNewOccurrence(spn uint32, fmi uint8, oc uint8) (bool, error) {
+	return s.observe(spn, fmi, oc, true)
+}
+
+// observe - реализация IsNew и IsNewOccurrence (trackOC).
+func (s *MemoryDTCStore) observe(spn uint32, fmi uint8, oc uint8, trackOC bool) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	key := string(dtcKey(spn, fmi))
+	if r, ok := s.records[key]; ok {
+		if !trackOC || oc <= r.OC {
+			return false, nil
+		}
+		r.OC = oc
+		s.records[key] = r
+		return true, nil
+	}
+	s.records[key] = DTCRecord{SPN: spn, FMI: fmi, FirstSeen: time.Now().UTC(), OC: oc}
+	if excess := len(s.records) - s.maxKeys; s.maxKeys > 0 && excess > 0 {
+		records := make([]DTCRecord, 0, len(s.records))
+		for _, r := range s.records {
+			records = append(records, r)
+		}
+		for _, r := range oldestRecords(records, excess) {
+			delete(s.records, string(dtcKey(r.SPN, r.FMI)))
+		}
+	}
+	return true, nil
+}
+
+func (s *MemoryDTCStore) Remove(spn uint32, fmi uint8) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.records, string(dtcKey(spn, fmi)))
+	return nil
+}
+
+func (s *MemoryDTCStore) ClearAll() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.records = make(map[string]DTCRecord)
+	return nil
+}
+
+func (s *MemoryDTCStore) List() ([]DTCRecord, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	records := make([]DTCRecord, 0, len(s.records))
+	for _, r := range s.records {
+		records = append(records, r)
+	}
+	sortRecords(records)
+	return records, nil
+}
+
+func (s *MemoryDTCStore) Get(spn uint32, fmi uint8) (DTCRecord, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	r, ok := s.records[string(dtcKey(spn, fmi))]
+	return r, ok, nil
+}
+
+func (s *MemoryDTCStore) Len() (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.records), nil
+}
+
+// sortRecords упорядочивает записи по SPN и FMI.
+func sortRecords(records []DTCRecord) {
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].SPN != records[j].SPN {
+			return records[i].SPN < records[j].SPN
+		}
+		return records[i].FMI < records[j].FMI
+	})
+}
@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	tripBucketKey = "trip"
+	tripKey       = "current"
+)
+
+// TripBaseline - начало и накопленные показатели текущей поездки. Сохраняется,
+// чтобы перезапуск агента посреди поездки не сбрасывал ее расстояние и длительность.
+type TripBaseline struct {
+	Start time.Time `json:"start"`
+	// StartOdometer - показание одометра в начале поездки, км; nil, если еще не получено.
+	StartOdometer *float64 `json:"start_odometer,omitempty"`
+	// IdleS, MovingS - накопленное время работы на холостом ходу и в движении, с.
+	IdleS   float64 `json:"idle_s,omitempty"`
+	MovingS float64 `json:"moving_s,omitempty"`
+}
+
+// LoadTripBaseline читает начало незавершенной поездки.
+// Если поездки нет, возвращает nil.
+func LoadTripBaseline(db *bolt.DB) (*TripBaseline, error) {
+	var t *TripBaseline
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(tripBucketKey))
+		if b == nil {
+			return nil
+		}
+		raw := b.Get([]byte(tripKey))
+		if raw == nil {
+			return nil
+		}
+		t = &TripBaseline{}
+		return json.Unmarshal(raw, t)
+	})
+	return t, err
+}
+
+// SaveTripBaseline сохраняет начало текущей поездки.
+func SaveTripBaseline(db *bolt.DB, t TripBaseline) error {
+	raw, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(tripBucketKey))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(tripKey), raw)
+	})
+}
+
+// ClearTripBaseline удаляет сохраненное начало поездки (поездка завершена).
+func ClearTripBaseline(db *bolt.DB) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(tripBucketKey))
+		if b == nil {
+			return nil
+		}
+		return b.Delete([]byte(tripKey))
+	})
+}
@@ -0,0 +1,36 @@
+package storage
+
+import (
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	vehicleBucketKey = "vehicle"
+	vinKey           = "vin"
+)
+
+// LoadVIN читает VIN, сохраненный при предыдущем запуске, чтобы он был доступен
+// сразу, до получения с шины. Если VIN не сохранялся, возвращает пустую строку.
+func LoadVIN(db *bolt.DB) (string, error) {
+	var vin string
+	err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(vehicleBucketKey))
+		if b == nil {
+			return nil
+		}
+		vin = string(b.Get([]byte(vinKey)))
+		return nil
+	})
+	return vin, err
+}
+
+// SaveVIN сохраняет VIN, полученный с шины.
+func SaveVIN(db *bolt.DB, vin string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(vehicleBucketKey))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(vinKey), []byte(vin))
+	})
+}
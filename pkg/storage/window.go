@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultDTCWindow - окно подавления повторной публикации DTC по умолчанию.
+const DefaultDTCWindow = 5 * time.Second
+
+// DTCWindow подавляет повторную публикацию одного и того же кода spn/fmi
+// в течение короткого окна независимо от состояния bbolt. Это закрывает гонку,
+// когда база очищена командой, а блок продолжает передавать тот же код
+// каждую секунду. Хранится только в памяти.
+type DTCWindow struct {
+	mutex  sync.Mutex
+	window time.Duration
+	seen   map[string]time.Time
+}
+
+// NewDTCWindow создает окно подавления длиной window.
+// window <= 0 отключает подавление: Allow всегда возвращает true.
+func NewDTCWindow(window time.Duration) *DTCWindow {
+	return &DTCWindow{
+		window: window,
+		seen:   make(map[string]time.Time),
+	}
+}
+
+// Allow сообщает, можно ли опубликовать код spn/fmi в момент now.
+// Возвращает false, если код уже пропускался менее окна назад;
+// иначе запоминает момент и возвращает true.
+func (w *DTCWindow) Allow(spn uint32, fmi uint8, now time.Time) bool {
+	if w == nil || w.window <= 0 {
+		return true
+	}
+	key := fmt.Sprintf("%d:%d", spn, fmi)
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if last, ok := w.seen[key]; ok && now.Sub(last) < w.window {
+		return false
+	}
+	w.seen[key] = now
+
+	// Удаляем устаревшие записи, чтобы карта не росла без ограничений
+	for k, t := range w.seen {
+		if now.Sub(t) >= w.window {
+			delete(w.seen, k)
+		}
+	}
+	return true
+}